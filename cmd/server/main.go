@@ -3,9 +3,12 @@ package main
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/api"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/daemon"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/retention"
 	"log"
 )
 
@@ -32,9 +35,12 @@ import (
 func main() {
 	cache.InitRedis()
 	database.ConnectDatabase()
+	auth.InitOIDC()
 
 	// Initialize daemon
 	go daemon.InitDaemon()
+	go retention.InitRetention()
+	export.InitJobs()
 
 	//gin.SetMode(gin.ReleaseMode)
 	gin.SetMode(gin.DebugMode)
@@ -0,0 +1,136 @@
+package currencies
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daemon/schedule"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"gorm.io/plugin/dbresolver"
+)
+
+// snapshot identifies the latest rates stored by the daemon
+type snapshot struct {
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// validators are the HTTP cache validators of a response derived from a snapshot
+type validators struct {
+	ETag         string
+	LastModified time.Time
+	// snapshot is the time of the latest rates the validators were derived from
+	snapshot time.Time
+}
+
+func loadSnapshot(ctx context.Context) (snapshot, error) {
+	// Read from the primary, like the latest rates themselves
+	var updatedAt sql.NullTime
+	if err := database.DB.WithContext(ctx).Clauses(dbresolver.Write).Model(&models.Currency{}).Select("MAX(created_at)").Scan(&updatedAt).Error; err != nil {
+		return snapshot{}, err
+	}
+
+	if !updatedAt.Valid {
+		return snapshot{}, errNoCurrencies
+	}
+
+	return snapshot{UpdatedAt: updatedAt.Time}, nil
+}
+
+// responseValidators derives the validators of the current request from the latest snapshot.
+// When they cannot be computed the zero value is returned, and the response is sent without them.
+func responseValidators(c *gin.Context) validators {
+	latest, err := cache.GetOrLoad(c.Request.Context(), cache.Latest, "snapshot", loadSnapshot)
+	if err != nil {
+		if !errors.Is(err, errNoCurrencies) {
+			log.Printf("Error loading latest snapshot: %s\n", err)
+		}
+		return validators{}
+	}
+
+	// Encode sorts the query, so equivalent requests share an ETag
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s",
+		latest.UpdatedAt.UnixNano(), strings.ToUpper(c.Request.URL.Path), c.Request.URL.Query().Encode())))
+
+	// Weak, since the same rates may be serialized in a different order
+	return validators{
+		ETag:         `W/"` + hex.EncodeToString(hash[:16]) + `"`,
+		LastModified: latest.UpdatedAt.UTC().Truncate(time.Second),
+		snapshot:     latest.UpdatedAt,
+	}
+}
+
+// cacheKey scopes the cache key of a response body to the snapshot of the validators, so a body cached before
+// new rates arrived is never sent with the ETag of the new ones
+func (v validators) cacheKey(key string) string {
+	if v.ETag == "" {
+		return key
+	}
+
+	return key + "@" + strconv.FormatInt(v.snapshot.UnixNano(), 10)
+}
+
+// variant derives the validators of the same response in another format, which must not share its ETag
+func (v validators) variant(format export.Format) validators {
+	if v.ETag == "" || format == export.JSON {
+		return v
+	}
+
+	v.ETag = strings.TrimSuffix(v.ETag, `"`) + "-" + string(format) + `"`
+	return v
+}
+
+// notModified reports whether the client copy described by the conditional headers is current.
+// If-Modified-Since is only considered without If-None-Match.
+func (v validators) notModified(r *http.Request) bool {
+	if v.ETag == "" {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, etag := range strings.Split(ifNoneMatch, ",") {
+			etag = strings.TrimSpace(etag)
+			if etag == "*" || strings.TrimPrefix(etag, "W/") == strings.TrimPrefix(v.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !v.LastModified.After(since)
+	}
+
+	return false
+}
+
+// write sets the validators, and a Cache-Control max-age lasting until the daemon fetches new rates.
+// Responses are private, since the routes need a token that shared caches must not answer for.
+func (v validators) write(c *gin.Context) {
+	if v.ETag == "" {
+		return
+	}
+
+	c.Header("ETag", v.ETag)
+	c.Header("Last-Modified", v.LastModified.Format(http.TimeFormat))
+	c.Writer.Header().Add("Vary", "Authorization")
+
+	if untilNextRun, ok := schedule.UntilNextRun(); ok {
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(untilNextRun.Seconds())))
+	} else {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+}
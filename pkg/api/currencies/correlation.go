@@ -0,0 +1,124 @@
+package currencies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+// GetCorrelation godoc
+// @Summary Get the correlation of several currencies
+// @Description Get the Pearson correlation matrix of the log returns of the currencies in symbols, resampled on step
+// @Tags Analytics
+// @Produce json
+// @Param symbols query string true "Comma-separated currency codes, at least two"
+// @Param finit query string false "Start date, as RFC 3339, a date and time in tz, a date, or relative like now-7d. Defaults to a week before fend"
+// @Param fend query string false "End date, in the same formats as finit. Defaults to now"
+// @Param tz query string false "IANA time zone days are aligned on, UTC by default"
+// @Param step query string false "Bucket length returns are taken between, like 1h or 1d. Defaults to 1d"
+// @Param If-None-Match header string false "ETag of the cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of the cached copy"
+// @Success 200 {object} models.CorrelationMatrix
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {string} string "Invalid symbols, step or date range"
+// @Failure 404 {string} string "Currency is not valid"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /analytics/correlation [get]
+func GetCorrelation(c *gin.Context) {
+	symbols, err := parseSymbols(c.Query("symbols"))
+	if err != nil || len(symbols) < 2 {
+		problem.Write(c, problem.Invalid("symbols", "At least 2 valid symbols are required"))
+		return
+	}
+	if len(symbols) > maxSymbols {
+		problem.Write(c, problem.Invalid("symbols", fmt.Sprintf("At most %d symbols can be requested", maxSymbols)))
+		return
+	}
+
+	// Filling gaps would add returns that never happened
+	if c.Query("fill") != "" {
+		problem.Write(c, problem.Invalid("fill", "fill is not supported"))
+		return
+	}
+
+	dateRange, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	stepValue := c.DefaultQuery("step", "1d")
+	step, ok := parseStep(c, stepValue, dateRange)
+	if !ok {
+		return
+	}
+
+	// Check every currency is known
+	known, err := knownCurrencies(symbols)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	if !known {
+		problem.Write(c, errUnknownCurrency)
+		return
+	}
+
+	// Answer from the client copy when the rates did not change since it was fetched
+	validators := responseValidators(c)
+	if validators.notModified(c.Request) {
+		validators.write(c)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cacheKey := "correlation_" + strings.Join(symbols, ",") + "_" + dateRange.Key() + "_" + step.String()
+	correlation, err := cache.GetOrLoad(c.Request.Context(), cache.History, validators.cacheKey(cacheKey), func(ctx context.Context) (models.CorrelationMatrix, error) {
+		return loadCorrelation(ctx, symbols, dateRange, stepValue, step)
+	})
+	if errors.Is(err, errNoCurrencies) {
+		problem.Write(c, errNoRates)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	validators.write(c)
+	c.JSON(http.StatusOK, correlation)
+}
+
+// loadCorrelation correlates the returns of the currencies in symbols, resampled without filling
+func loadCorrelation(ctx context.Context, symbols []string, dateRange daterange.Range, stepValue string, step time.Duration) (models.CorrelationMatrix, error) {
+	history, err := rates.History(ctx, database.DB, symbols, dateRange.Start, dateRange.End)
+	if err != nil {
+		return models.CorrelationMatrix{}, err
+	}
+
+	if len(history) == 0 {
+		return models.CorrelationMatrix{}, errNoCurrencies
+	}
+
+	grid := rates.NewGrid(dateRange.Start, dateRange.End, step, dateRange.Location)
+	matrix, observations := rates.Correlation(rates.Resample(history, grid, rates.FillNone), grid, symbols)
+
+	return models.CorrelationMatrix{
+		Symbols:      symbols,
+		From:         dateRange.Format(dateRange.Start),
+		To:           dateRange.Format(dateRange.End),
+		Step:         stepValue,
+		Matrix:       matrix,
+		Observations: observations,
+	}, nil
+}
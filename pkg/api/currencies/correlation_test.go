@@ -0,0 +1,68 @@
+package currencies
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestGetCorrelation(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/analytics/correlation", GetCorrelation)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 5, 23, 59, 59, 999999000, time.UTC)
+	day := func(n int) time.Time { return start.AddDate(0, 0, n).Add(12 * time.Hour) }
+
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2\)`).
+		WithArgs("EUR", "MXN").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(day(4)))
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY code, created_at`).
+		WithArgs("EUR", "MXN", start, end, "EUR", "MXN", start, end, "EUR", "MXN", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", day(0), "1").
+			AddRow("EUR", day(1), "1.1").
+			AddRow("EUR", day(3), "1.21").
+			AddRow("EUR", day(4), "1.1").
+			AddRow("MXN", day(0), "20").
+			AddRow("MXN", day(1), "22").
+			AddRow("MXN", day(2), "20").
+			AddRow("MXN", day(3), "22").
+			AddRow("MXN", day(4), "20"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/analytics/correlation?symbols=mxn,eur&finit=2024-03-01&fend=2024-03-05", nil)
+
+	// Then the returns around the missing EUR day are left out rather than taken over two days
+	require.Equal(t, http.StatusOK, w.Code)
+	expected := `{"symbols":["EUR","MXN"],"from":"2024-03-01T00:00:00","to":"2024-03-05T23:59:59","step":"1d","matrix":[[1,1],[1,1]],"observations":[[2,2],[2,4]]}`
+	require.Equal(t, expected, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetCorrelation_SingleSymbol(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/analytics/correlation", GetCorrelation)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/analytics/correlation?symbols=MXN", nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "At least 2 valid symbols are required", helper.DecodeProblem(t, w).Detail)
+}
@@ -0,0 +1,70 @@
+package currencies
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+// negotiateFormat picks the format of a history response, answering with the error when the format param is unknown
+func negotiateFormat(c *gin.Context) (export.Format, bool) {
+	format, err := export.Negotiate(c.Query("format"), c.GetHeader("Accept"))
+	if err != nil {
+		problem.Write(c, problem.Invalid("format", "Invalid format"))
+		return format, false
+	}
+
+	// The Accept header picks the representation, so caches must key on it
+	c.Header("Vary", "Accept")
+
+	return format, true
+}
+
+// streamHistory answers with the history of the currencies in symbols in format, written as it is read from the database.
+// Headers are only sent with the first row, so errors before it are answered as usual. Errors after it abort the
+// connection, so clients see the download fail rather than take a truncated file for a complete one.
+func streamHistory(c *gin.Context, validators validators, format export.Format, symbols []string, base string, dateRange daterange.Range, resampling rates.Resampling) {
+	query := export.Query{Symbols: symbols, Base: base, Range: dateRange, Step: resampling.Step, Fill: resampling.Fill}
+
+	var w export.Writer
+	err := export.Each(c.Request.Context(), database.DB, query, func(row export.Row) error {
+		if w == nil {
+			var err error
+			if w, err = export.NewWriter(format, c.Writer); err != nil {
+				return err
+			}
+
+			validators.write(c)
+			c.Header("Content-Type", format.ContentType())
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.Filename(symbols, format)))
+			c.Status(http.StatusOK)
+		}
+
+		return w.Write(row)
+	})
+
+	if w == nil {
+		if err != nil {
+			problem.Write(c, problem.Internal(err))
+			return
+		}
+		problem.Write(c, errNoRates)
+		return
+	}
+
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("Error exporting history of %s request_id=%s: %s\n", strings.Join(symbols, ","), c.GetString("request_id"), err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,106 @@
+package currencies
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestHandleCurrencyRequest_AcceptCSV(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currency/:name", HandleCurrencyRequest)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 23, 59, 59, 999999000, time.UTC)
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
+		WithArgs("MXN", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).AddRow("MXN", "Mexican Peso"))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(at))
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY created_at, code`).
+		WithArgs("MXN", start, end, "MXN", start, end, "MXN", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("MXN", at, "17.05").
+			AddRow("MXN", at.Add(time.Hour), "17.1"))
+
+	// When
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/currency/mxn?finit=2024-03-01&fend=2024-03-01", nil)
+	req.Header.Set("Accept", "text/csv")
+	r.ServeHTTP(w, req)
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="MXN.csv"`, w.Header().Get("Content-Disposition"))
+	require.Equal(t, "Accept", w.Header().Get("Vary"))
+	require.Regexp(t, `-csv"$`, w.Header().Get("ETag"))
+	require.Equal(t, "code,date,value,filled\nMXN,2024-03-01T19:15:00,17.05,false\nMXN,2024-03-01T20:15:00,17.1,false\n", w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetHistory_FormatNDJSON(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 23, 59, 59, 999999000, time.UTC)
+
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2,\$3\)`).
+		WithArgs("BRL", "MXN", "EUR").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(at))
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY created_at, code`).
+		WithArgs("BRL", "MXN", "EUR", start, end, "BRL", "MXN", "EUR", start, end, "BRL", "MXN", "EUR", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("BRL", at, "5").
+			AddRow("EUR", at, "0.8").
+			AddRow("MXN", at, "17"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/history?symbols=mxn,BRL&base=eur&finit=2024-03-01&fend=2024-03-01&format=ndjson", nil)
+
+	// Then rows are rebased, without the base itself
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	expected := `{"code":"BRL","date":"2024-03-01T19:15:00","value":6.25,"filled":false}` + "\n" +
+		`{"code":"MXN","date":"2024-03-01T19:15:00","value":21.25,"filled":false}` + "\n"
+	require.Equal(t, expected, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetHistory_InvalidFormat(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/history?symbols=MXN&format=xml", nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "Invalid format", helper.DecodeProblem(t, w).Detail)
+}
@@ -0,0 +1,266 @@
+package currencies
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/storage"
+	"gorm.io/gorm"
+)
+
+// CreateExport godoc
+// @Summary Request an export job
+// @Description Queue an export of the history of several currencies, written to storage in the background. Takes the same parameters as /history
+// @Tags Exports
+// @Security JwtAuth
+// @Accept json
+// @Produce json
+// @Param export body models.ExportRequest true "Currencies, range and format of the export"
+// @Success 202 {object} models.ExportStatus
+// @Failure 400 {string} string "Invalid symbols, base, date range or format"
+// @Failure 404 {string} string "Currency is not valid"
+// @Failure 500 {string} string "Internal Server Error"
+// @Failure 503 {string} string "Exports are disabled"
+// @Router /exports [post]
+func CreateExport(c *gin.Context) {
+	if export.Store == nil {
+		problem.Write(c, errExportsDisabled)
+		return
+	}
+
+	var request models.ExportRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	symbols, err := parseSymbols(strings.Join(request.Symbols, ","))
+	if err != nil || len(symbols) == 0 {
+		problem.Write(c, problem.Invalid("symbols", "Invalid symbols"))
+		return
+	}
+	if len(symbols) > maxSymbols {
+		problem.Write(c, problem.Invalid("symbols", fmt.Sprintf("At most %d symbols can be requested", maxSymbols)))
+		return
+	}
+
+	base := strings.ToUpper(request.Base)
+	if base == "" {
+		base = rates.Base
+	}
+	if !symbolPattern.MatchString(base) {
+		problem.Write(c, problem.Invalid("base", "Invalid base"))
+		return
+	}
+
+	dateRange, err := resolveDateRange(request.Finit, request.Fend, request.TZ)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	if _, err := resolveResampling(request.Step, request.Fill, dateRange); err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	format, err := export.Negotiate(request.Format, "")
+	if err != nil || format == export.JSON {
+		problem.Write(c, problem.Invalid("format", "Invalid format"))
+		return
+	}
+
+	codes := symbols
+	if !slices.Contains(codes, base) {
+		codes = append(slices.Clone(codes), base)
+	}
+
+	known, err := knownCurrencies(codes)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	if !known {
+		problem.Write(c, errUnknownCurrency)
+		return
+	}
+
+	job := models.ExportJob{
+		ID:        export.NewJobID(),
+		UserID:    c.GetInt("user_id"),
+		Symbols:   strings.Join(symbols, ","),
+		Base:      base,
+		Start:     dateRange.Start,
+		End:       dateRange.End,
+		TZ:        request.TZ,
+		Step:      request.Step,
+		Fill:      request.Fill,
+		Format:    string(format),
+		Status:    models.ExportQueued,
+		ExpiresAt: time.Now().Add(export.JobTTL),
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	c.Header("Location", "/api/v1/exports/"+job.ID)
+	c.JSON(http.StatusAccepted, exportStatus(c, job))
+}
+
+// GetExport godoc
+// @Summary Get an export job
+// @Description Get the status of an export job of the current user, with a download link once it is done
+// @Tags Exports
+// @Security JwtAuth
+// @Produce json
+// @Param id path string true "Export job ID"
+// @Success 200 {object} models.ExportStatus
+// @Failure 404 {string} string "Export not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /exports/{id} [get]
+func GetExport(c *gin.Context) {
+	job, ok := findExport(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, exportStatus(c, job))
+}
+
+// DownloadExport godoc
+// @Summary Download an export
+// @Description Download the file of a finished export job of the current user, redirecting to the storage when it serves files itself
+// @Tags Exports
+// @Security JwtAuth
+// @Produce text/csv,application/x-ndjson,application/vnd.apache.parquet
+// @Param id path string true "Export job ID"
+// @Success 200 {file} file "The exported rates"
+// @Success 302 {string} string "Redirect to the file in storage"
+// @Failure 404 {string} string "Export not found"
+// @Failure 409 {string} string "Export is not done"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /exports/{id}/download [get]
+func DownloadExport(c *gin.Context) {
+	job, ok := findExport(c)
+	if !ok {
+		return
+	}
+
+	if job.Status != models.ExportDone {
+		problem.Write(c, errExportNotDone)
+		return
+	}
+
+	filename := export.Filename(strings.Split(job.Symbols, ","), export.Format(job.Format))
+	link, err := export.Store.URL(c.Request.Context(), job.StorageKey, filename, time.Until(job.ExpiresAt))
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	if link != "" {
+		c.Redirect(http.StatusFound, link)
+		return
+	}
+
+	file, err := export.Store.Open(c.Request.Context(), job.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		problem.Write(c, errExportNotFound)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, job.Size, export.Format(job.Format).ContentType(), file, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, filename),
+	})
+}
+
+// findExport reads the job in the id path param, answering with the error when the current user has no such job
+func findExport(c *gin.Context) (models.ExportJob, bool) {
+	if export.Store == nil {
+		problem.Write(c, errExportsDisabled)
+		return models.ExportJob{}, false
+	}
+
+	// Jobs of other users are reported missing, rather than forbidden, so their IDs cannot be probed
+	var job models.ExportJob
+	err := database.DB.Where("id = ? AND user_id = ? AND expires_at > ?", c.Param("id"), c.GetInt("user_id"), time.Now()).
+		First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		problem.Write(c, errExportNotFound)
+		return job, false
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return job, false
+	}
+
+	return job, true
+}
+
+// exportErrorCodes are the codes of the errors recorded on failed jobs
+var exportErrorCodes = map[string]string{
+	export.JobFailedMessage:    "export_failed",
+	export.JobAbandonedMessage: "export_abandoned",
+}
+
+// exportError returns the code and description a failed job is reported with
+func exportError(job models.ExportJob) (string, string) {
+	return exportErrorCodes[job.Error], job.Error
+}
+
+// exportStatus returns the public view of a job, linking to its file when it is done
+func exportStatus(c *gin.Context, job models.ExportJob) models.ExportStatus {
+	status := models.ExportStatus{
+		ID:         job.ID,
+		Status:     job.Status,
+		Symbols:    strings.Split(job.Symbols, ","),
+		Base:       job.Base,
+		Step:       job.Step,
+		Fill:       job.Fill,
+		Format:     job.Format,
+		Rows:       job.Rows,
+		Size:       job.Size,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: job.FinishedAt,
+		ExpiresAt:  job.ExpiresAt,
+	}
+
+	if job.Status == models.ExportFailed {
+		status.ErrorCode, status.Error = exportError(job)
+	}
+
+	if query, err := export.JobQuery(job); err == nil {
+		status.From = query.Range.Format(job.Start)
+		status.To = query.Range.Format(job.End)
+	}
+
+	if job.Status == models.ExportDone {
+		filename := export.Filename(status.Symbols, export.Format(job.Format))
+		link, err := export.Store.URL(c.Request.Context(), job.StorageKey, filename, time.Until(job.ExpiresAt))
+		if err != nil {
+			log.Printf("Error linking export %s: %s\n", job.ID, err)
+		}
+		if link == "" {
+			link = "/api/v1/exports/" + job.ID + "/download"
+		}
+		status.DownloadURL = link
+	}
+
+	return status
+}
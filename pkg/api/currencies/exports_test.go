@@ -0,0 +1,129 @@
+package currencies
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/storage"
+)
+
+// newExportsRouter routes the export handlers as alice, whose ID is 1, with files in a temporary directory
+func newExportsRouter(t *testing.T) (*gin.Engine, storage.Storage) {
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	export.Store = store
+	t.Cleanup(func() { export.Store = nil })
+
+	r := gin.Default()
+	r.Use(func(c *gin.Context) {
+		c.Set("user_id", 1)
+		c.Set("username", "alice")
+	})
+	r.POST("/exports", CreateExport)
+	r.GET("/exports/:id", GetExport)
+	r.GET("/exports/:id/download", DownloadExport)
+
+	return r, store
+}
+
+func TestCreateExport(t *testing.T) {
+	// Given
+	r, _ := newExportsRouter(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2\)`).
+		WithArgs("MXN", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT INTO "export_job"`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	body := `{"symbols":["mxn"],"finit":"2024-03-01","fend":"2024-03-31","step":"1d","format":"parquet"}`
+	w := helper.PerformRequest(r, "POST", "/exports", []byte(body))
+
+	// Then
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var status models.ExportStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Len(t, status.ID, 32)
+	require.Equal(t, "/api/v1/exports/"+status.ID, w.Header().Get("Location"))
+	require.Equal(t, models.ExportQueued, status.Status)
+	require.Equal(t, []string{"MXN"}, status.Symbols)
+	require.Equal(t, "2024-03-31T23:59:59", status.To)
+	require.Empty(t, status.DownloadURL)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestCreateExport_InvalidFormat(t *testing.T) {
+	// Given
+	r, _ := newExportsRouter(t)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/exports", []byte(`{"symbols":["MXN"],"format":"json"}`))
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "Invalid format", helper.DecodeProblem(t, w).Detail)
+}
+
+func TestGetExport_Done(t *testing.T) {
+	// Given
+	r, store := newExportsRouter(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	require.NoError(t, store.Put(context.Background(), "exports/job.csv", strings.NewReader("code\n"), 5, "text/csv"))
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job" WHERE id = \$1 AND user_id = \$2 AND expires_at > \$3`).
+		WithArgs("job", 1, sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "symbols", "base", "start", "end", "format", "status", "error", "expires_at"}).
+			AddRow("job", 1, "MXN", "USD", start, start.Add(time.Hour), "csv", models.ExportFailed, export.JobFailedMessage, time.Now().Add(time.Hour)))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/exports/job", nil)
+
+	// Then
+	var status models.ExportStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.Equal(t, "export_failed", status.ErrorCode)
+	require.Equal(t, export.JobFailedMessage, status.Error)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetExport_OtherUser(t *testing.T) {
+	// Given
+	r, _ := newExportsRouter(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job" WHERE id = \$1 AND user_id = \$2 AND expires_at > \$3`).
+		WithArgs("job", 1, sqlmock.AnyArg(), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/exports/job", nil)
+
+	// Then
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "Export not found", helper.DecodeProblem(t, w).Detail)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -1,206 +1,366 @@
 package currencies
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+	"gorm.io/gorm"
 )
 
-// HandleCurrencyRequest godoc
-// @Summary Manage currency requests
-// @Description check param name to get all currencies or a specific currency by date range
-// @Produce json
-// @Param name path string true "Currency name"
-func HandleCurrencyRequest(c *gin.Context) {
-	// Get query params
-	currencyName := strings.ToUpper(c.Param("name"))
-	finitQuery := c.DefaultQuery("finit", "")
-	fendQuery := c.DefaultQuery("fend", "")
+// errNoCurrencies is returned by loaders when there are no rates to return
+var errNoCurrencies = errors.New("no currencies found")
+
+// Errors answered by the currency endpoints
+var (
+	errUnknownCurrency = problem.New(http.StatusNotFound, "unknown_currency", "Currency is not valid")
+	errNoRates         = problem.New(http.StatusNotFound, "no_rates", "No currencies found for the specified date range")
+	errNoLatestRates   = problem.New(http.StatusNotFound, "no_rates", "No currencies found")
+	errExportNotFound  = problem.New(http.StatusNotFound, "export_not_found", "Export not found")
+	errExportNotDone   = problem.New(http.StatusConflict, "export_not_done", "Export is not done")
+	errExportsDisabled = problem.New(http.StatusServiceUnavailable, "exports_disabled", "Exports are disabled")
+)
 
-	// Check if currency name is "ALL" to fetch all currencies
-	// The Albanian Lek (ALL) code conflicts with the "ALL" keyword
-	if currencyName == "ALL" && finitQuery == "" && fendQuery == "" {
-		fetchAllCurrencies(c)
+// symbolPattern matches a currency code in the symbols filter
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9]{1,16}$`)
+
+// ListCurrencies godoc
+// @Summary Get all currencies
+// @Description Get the latest rate of every currency, or of those in symbols
+// @Tags Currencies
+// @Produce json
+// @Param symbols query string false "Comma-separated currency codes to return, all by default"
+// @Param tz query string false "IANA time zone dates are rendered in, UTC by default"
+// @Param If-None-Match header string false "ETag of the cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of the cached copy"
+// @Success 200 {object} []models.GroupedCurrencies
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {string} string "Invalid symbols or tz"
+// @Failure 404 {string} string "No currencies found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /currencies [get]
+func ListCurrencies(c *gin.Context) {
+	loc, err := daterange.LoadLocation(c.Query("tz"))
+	if err != nil {
+		problem.Write(c, problem.Invalid("tz", "Invalid tz"))
 		return
 	}
+	zoned := c.Query("tz") != ""
 
-	// Check database for the currency
-	var currency models.Currency
-	if err := database.DB.Where("name = ?", currencyName).First(&currency).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Currency is not valid"})
+	symbols, err := parseSymbols(c.Query("symbols"))
+	if err != nil {
+		problem.Write(c, problem.Invalid("symbols", "Invalid symbols"))
 		return
 	}
 
-	// Parse query params into time.Time
-	layout := "2006-01-02T15:04:05"
-	var finit, fend time.Time
-	var err error
+	fetchAllCurrencies(c, loc, zoned, symbols)
+}
 
-	if finitQuery != "" {
-		finit, err = time.Parse(layout, finitQuery)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid finit date format"})
-			return
-		}
-	}
+// LegacyDeprecatedAt is when /currencies/all was deprecated in favour of /currencies
+var LegacyDeprecatedAt = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+// LegacySunset is when /currencies/all will be removed, set with CURRENCIES_ALL_SUNSET
+var LegacySunset = loadLegacySunset()
 
-	if fendQuery != "" {
-		fend, err = time.Parse(layout, fendQuery)
+func loadLegacySunset() time.Time {
+	sunset := LegacyDeprecatedAt.AddDate(0, 6, 0)
+
+	if value := config.String("CURRENCIES_ALL_SUNSET", ""); value != "" {
+		parsed, err := time.Parse(time.DateOnly, value)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fend date format"})
-			return
+			log.Printf("Error parsing CURRENCIES_ALL_SUNSET, using %s: %s\n", sunset.Format(time.DateOnly), err)
+			return sunset
 		}
+		sunset = parsed
 	}
 
-	// Fetch or retrieve currencies by date range
-	fetchCurrencyByDateRange(c, currencyName, finit, fend)
+	return sunset
 }
 
-// fetchAllCurrencies godoc
-// @Summary Get all currencies
-// @Description Get all currencies from the database
+// ListCurrenciesLegacy godoc
+// @Summary Get the history of all currencies (deprecated)
+// @Description Get every stored rate of every currency, or the Albanian Lek history when finit or fend are given. Use /currencies, /history and /currencies/ALL instead.
 // @Tags Currencies
 // @Produce json
+// @Param finit query string false "Start date of the Albanian Lek history"
+// @Param fend query string false "End date of the Albanian Lek history"
+// @Param If-None-Match header string false "ETag of the cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of the cached copy"
 // @Success 200 {object} []models.GroupedCurrencies
+// @Success 304 {string} string "Not Modified"
 // @Failure 404 {string} string "No currencies found"
 // @Failure 500 {string} string "Internal Server Error"
+// @Deprecated
 // @Router /currencies/all [get]
-func fetchAllCurrencies(c *gin.Context) {
-	// Get all currencies from the database or cache
-	var groupedCurrencies []models.GroupedCurrencies
-	cacheKey := "currency_all"
-
-	// Attempt to retrieve currencies from cache
-	if cachedCurrencies, err := getCurrenciesFromCache(cacheKey); err == nil {
-		c.JSON(http.StatusOK, cachedCurrencies)
+func ListCurrenciesLegacy(c *gin.Context) {
+	// Dates used to select the Albanian Lek, whose code is ALL
+	if c.Query("finit") != "" || c.Query("fend") != "" {
+		c.Params = append(c.Params, gin.Param{Key: "name", Value: "ALL"})
+		HandleCurrencyRequest(c)
 		return
 	}
 
-	// Get all currencies from the database
-	var currencies []models.Currency
-	if err := database.DB.Select("name, created_at, value").Find(&currencies).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Answer from the client copy when the rates did not change since it was fetched
+	validators := responseValidators(c)
+	if validators.notModified(c.Request) {
+		validators.write(c)
+		c.Status(http.StatusNotModified)
 		return
 	}
 
-	if len(currencies) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No currencies found"})
+	// Get the whole history from the cache, or the database on a miss
+	groupedCurrencies, err := cache.GetOrLoad(c.Request.Context(), cache.History, validators.cacheKey("legacy_all"), loadAllCurrencies)
+	if errors.Is(err, errNoCurrencies) {
+		problem.Write(c, errNoLatestRates)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
 		return
+	}
 
+	validators.write(c)
+	c.JSON(http.StatusOK, groupedCurrencies)
+}
+
+// loadAllCurrencies reads every stored rate, grouped by code, with dates in the legacy layout
+func loadAllCurrencies(ctx context.Context) ([]models.GroupedCurrencies, error) {
+	all, err := rates.All(ctx, database.DB)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create a map to store grouped currencies
-	currencyMap := make(map[string][]models.Currency)
+	// Rates are ordered by code, so every series is a run of rows
+	var groupedCurrencies []models.GroupedCurrencies
+	for _, rate := range all {
+		if len(groupedCurrencies) == 0 || groupedCurrencies[len(groupedCurrencies)-1].Code != rate.Code {
+			groupedCurrencies = append(groupedCurrencies, models.GroupedCurrencies{Code: rate.Code})
+		}
+		series := &groupedCurrencies[len(groupedCurrencies)-1]
+		series.Data = append(series.Data, models.CurrencyData{Date: daterange.FormatIn(rate.Time, time.UTC, false), Value: rate.Value})
+	}
 
-	// Group currencies by code
-	for _, currency := range currencies {
-		currencyMap[currency.Name] = append(currencyMap[currency.Name], currency)
+	if len(groupedCurrencies) == 0 {
+		return nil, errNoCurrencies
 	}
 
-	// Format grouped currencies into GroupedCurrencies struct
-	for code, currencyList := range currencyMap {
-		var data []models.CurrencyData
+	return groupedCurrencies, nil
+}
+
+// parseSymbols returns the distinct codes of a comma-separated symbols filter, sorted, or nil when it is empty
+func parseSymbols(query string) ([]string, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
 
-		for _, currency := range currencyList {
-			data = append(data, models.CurrencyData{
-				Date:  currency.CreatedAt.Format("2006-01-02T15:04:05"),
-				Value: currency.Value,
-			})
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, symbol := range strings.Split(query, ",") {
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if !symbolPattern.MatchString(symbol) {
+			return nil, fmt.Errorf("invalid symbol %q", symbol)
 		}
+		if !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
 
-		groupedCurrencies = append(groupedCurrencies, models.GroupedCurrencies{
-			Code: code,
-			Data: data,
-		})
+	return symbols, nil
+}
+
+// parseDateRange resolves the finit, fend and tz query params, answering with the error when they are invalid
+func parseDateRange(c *gin.Context) (daterange.Range, bool) {
+	dateRange, err := resolveDateRange(c.Query("finit"), c.Query("fend"), c.Query("tz"))
+	if err != nil {
+		problem.Write(c, err)
+		return daterange.Range{}, false
 	}
 
-	// Store currencies in cache
-	storeCurrenciesInCache(cacheKey, groupedCurrencies)
+	return dateRange, true
+}
 
-	c.JSON(http.StatusOK, groupedCurrencies)
+// resolveDateRange resolves a finit, fend and tz, with errors worded for the client
+func resolveDateRange(finitValue, fendValue, tz string) (daterange.Range, error) {
+	// Parse query params into time.Time, relative to the current second
+	return daterange.Parse(finitValue, fendValue, tz, time.Now().Truncate(time.Second))
 }
 
-// fetchCurrencyByDateRange godoc
+// HandleCurrencyRequest godoc
 // @Summary Get currency by date range
-// @Description Get a specific currency by date range from the database
-// @Produce json
+// @Description Get the history of a currency, by ISO 4217 code, within a date range
+// @Produce json,text/csv,application/x-ndjson,application/vnd.apache.parquet
 // @Tags Currencies
-// @Param name path string true "Currency name"
-// @Param finit query string false "Start date"
-// @Param fend query string false "End date"
+// @Param name path string true "Currency code"
+// @Param finit query string false "Start date, as RFC 3339, a date and time in tz, a date, or relative like now-7d. Defaults to a week before fend"
+// @Param fend query string false "End date, in the same formats as finit. Defaults to now"
+// @Param tz query string false "IANA time zone dates are read and rendered in, UTC by default"
+// @Param step query string false "Bucket length rates are aligned on, in minutes, hours or days, like 5m, 1h or 1d"
+// @Param fill query string false "How buckets without rates are filled: none, previous or linear. Defaults to none"
+// @Param format query string false "Export format, csv, ndjson or parquet, taking precedence over Accept. JSON by default"
+// @Param Accept header string false "text/csv, application/x-ndjson or application/vnd.apache.parquet to export the rates as rows"
+// @Param If-None-Match header string false "ETag of the cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of the cached copy"
 // @Success 200 {object} models.GroupedCurrencies
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {string} string "Invalid date range"
 // @Failure 404 {string} string "No currencies found for the specified date range"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /currencies/{name} [get]
-func fetchCurrencyByDateRange(c *gin.Context, currencyName string, startDate, endDate time.Time) {
-	// Prepare cache key using currency name and date range
-	cacheKey := "currency_" + currencyName + "_start_" + startDate.Format("2006-01-02T15:04:05") + "_end_" + endDate.Format("2006-01-02T15:04:05")
+func HandleCurrencyRequest(c *gin.Context) {
+	// Get query params
+	currencyName := strings.ToUpper(c.Param("name"))
 
-	// Attempt to retrieve currencies from cache
-	if cachedCurrencies, err := getCurrenciesFromCache(cacheKey); err == nil {
-		c.JSON(http.StatusOK, cachedCurrencies)
+	// Dates are validated after the currency, and tz before it
+	if _, err := daterange.LoadLocation(c.Query("tz")); err != nil {
+		problem.Write(c, problem.Invalid("tz", "Invalid tz"))
 		return
 	}
 
-	// Retrieve currency history from the database
-	var currencyHistory []models.Currency
-	if err := database.DB.Select("name, created_at, value").
-		Where("name = ? AND created_at BETWEEN ? AND ?", currencyName, startDate, endDate).
-		Find(&currencyHistory).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Check the currency is known
+	var currency models.CurrencyMetadata
+	if err := database.DB.Where("code = ?", currencyName).First(&currency).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		problem.Write(c, errUnknownCurrency)
+		return
+	} else if err != nil {
+		problem.Write(c, problem.Internal(err))
 		return
 	}
 
-	if len(currencyHistory) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No currencies found for the specified date range"})
+	dateRange, ok := parseDateRange(c)
+	if !ok {
 		return
+	}
 
+	resampling, ok := parseResampling(c, dateRange)
+	if !ok {
+		return
 	}
 
-	// Format currency history into desired structure
-	var formattedHistory []models.CurrencyData
-	for _, history := range currencyHistory {
-		formattedHistory = append(formattedHistory, models.CurrencyData{
-			Date:  history.CreatedAt.Format("2006-01-02T15:04:05"),
-			Value: history.Value,
-		})
+	format, ok := negotiateFormat(c)
+	if !ok {
+		return
 	}
-	groupedCurrencies := models.GroupedCurrencies{
-		Code: currencyName,
-		Data: formattedHistory,
+
+	// Fetch or retrieve currencies by date range
+	fetchCurrencyByDateRange(c, currencyName, dateRange, resampling, format)
+}
+
+// fetchAllCurrencies answers with the latest rate of the currencies in symbols, or of all of them when it is empty
+func fetchAllCurrencies(c *gin.Context, loc *time.Location, zoned bool, symbols []string) {
+	// Answer from the client copy when the rates did not change since it was fetched
+	validators := responseValidators(c)
+	if validators.notModified(c.Request) {
+		validators.write(c)
+		c.Status(http.StatusNotModified)
+		return
 	}
 
-	// Store currency history in cache
-	storeCurrenciesInCache(cacheKey, groupedCurrencies)
+	// Get the latest rates from the cache, or the database on a miss
+	key := "latest"
+	if len(symbols) > 0 {
+		key += "_" + strings.Join(symbols, ",")
+	}
+	if zoned {
+		key += "_" + loc.String()
+	}
+	groupedCurrencies, err := cache.GetOrLoad(c.Request.Context(), cache.Latest, validators.cacheKey(key), func(ctx context.Context) ([]models.GroupedCurrencies, error) {
+		return loadLatestCurrencies(ctx, loc, zoned, symbols)
+	})
+	if errors.Is(err, errNoCurrencies) {
+		problem.Write(c, errNoLatestRates)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
 
+	validators.write(c)
 	c.JSON(http.StatusOK, groupedCurrencies)
 }
 
-func getCurrenciesFromCache(cacheKey string) (interface{}, error) {
-	// Retrieve currencies from cache
-	var currencies interface{}
-
-	cachedCurrencies, err := cache.Rdb.Get(cache.Ctx, cacheKey).Result()
+// loadLatestCurrencies reads the last rate of the currencies in symbols, or of every currency when it is nil, ordered
+// by code, with dates rendered in loc
+func loadLatestCurrencies(ctx context.Context, loc *time.Location, zoned bool, symbols []string) ([]models.GroupedCurrencies, error) {
+	latest, err := rates.Latest(ctx, database.DB, symbols)
 	if err != nil {
-		return currencies, err
+		return nil, err
+	}
+
+	if len(latest) == 0 {
+		return nil, errNoCurrencies
 	}
 
-	err = json.Unmarshal([]byte(cachedCurrencies), &currencies)
-	return currencies, err
+	groupedCurrencies := make([]models.GroupedCurrencies, len(latest))
+	for i, sample := range latest {
+		groupedCurrencies[i] = models.GroupedCurrencies{
+			Code: sample.Code,
+			Data: []models.CurrencyData{{Date: daterange.FormatIn(sample.Time, loc, zoned), Value: sample.Value}},
+		}
+	}
+
+	return groupedCurrencies, nil
 }
 
-func storeCurrenciesInCache(cacheKey string, currency interface{}) {
-	// Store currencies in cache
-	serializedCurrencies, err := json.Marshal(currency)
+// fetchCurrencyByDateRange answers with the history of a currency within a date range, in format
+func fetchCurrencyByDateRange(c *gin.Context, currencyName string, dateRange daterange.Range, resampling rates.Resampling, format export.Format) {
+	// Prepare cache key using currency name, date range and resampling
+	cacheKey := currencyName + "_" + dateRange.Key() + resampling.Key()
+
+	// Answer from the client copy when the rates did not change since it was fetched
+	validators := responseValidators(c).variant(format)
+	if validators.notModified(c.Request) {
+		validators.write(c)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// Exports are streamed from the database rather than cached
+	if format != export.JSON {
+		streamHistory(c, validators, format, []string{currencyName}, rates.Base, dateRange, resampling)
+		return
+	}
+
+	// Get the currency history from the cache, or the database on a miss
+	groupedCurrencies, err := cache.GetOrLoad(c.Request.Context(), cache.History, validators.cacheKey(cacheKey), func(ctx context.Context) (models.GroupedCurrencies, error) {
+		return loadCurrencyHistory(ctx, currencyName, dateRange, resampling)
+	})
+	if errors.Is(err, errNoCurrencies) {
+		problem.Write(c, errNoRates)
+		return
+	}
 	if err != nil {
+		problem.Write(c, problem.Internal(err))
 		return
 	}
 
-	_ = cache.Rdb.Set(cache.Ctx, cacheKey, serializedCurrencies, 0).Err()
+	validators.write(c)
+	c.JSON(http.StatusOK, groupedCurrencies)
+}
+
+// loadCurrencyHistory reads the history of a currency within a date range from the database
+func loadCurrencyHistory(ctx context.Context, currencyName string, dateRange daterange.Range, resampling rates.Resampling) (models.GroupedCurrencies, error) {
+	history, err := loadHistory(ctx, []string{currencyName}, rates.Base, dateRange, resampling)
+	if err != nil {
+		return models.GroupedCurrencies{}, err
+	}
+
+	return history[0], nil
 }
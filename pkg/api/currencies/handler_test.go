@@ -1,16 +1,25 @@
 package currencies
 
 import (
+	"context"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/middleware"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
 	"gorm.io/gorm"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHandleCurrencyRequest_EmptyCurrency(t *testing.T) {
@@ -32,7 +41,7 @@ func TestHandleCurrencyRequest_InvalidCurrency(t *testing.T) {
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
 
-	dbMock.ExpectQuery(`SELECT \* FROM "currency" WHERE name = (.+) ORDER BY "currency"."id" LIMIT (.+)`).
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
 		WithArgs("INVALID", 1).
 		WillReturnError(gorm.ErrRecordNotFound)
 
@@ -40,8 +49,7 @@ func TestHandleCurrencyRequest_InvalidCurrency(t *testing.T) {
 	w := helper.PerformRequest(r, "GET", "/currency/INVALID", nil)
 	require.Equal(t, http.StatusNotFound, w.Code)
 
-	expected := `{"error":"Currency is not valid"}`
-	require.Equal(t, expected, w.Body.String())
+	require.Equal(t, "Currency is not valid", helper.DecodeProblem(t, w).Detail)
 
 	// Verify all expectations were met
 	if err := dbMock.ExpectationsWereMet(); err != nil {
@@ -62,23 +70,21 @@ func TestHandleCurrencyRequest_InvalidFinitDate(t *testing.T) {
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
 
-	mockCurrency := models.Currency{
-		ID:    1,
-		Name:  "USD",
-		Value: 1.0,
+	mockCurrency := models.CurrencyMetadata{
+		Code: "USD",
+		Name: "US Dollar",
 	}
 
-	dbMock.ExpectQuery(`SELECT \* FROM "currency" WHERE name = (.+) ORDER BY "currency"."id" LIMIT (.+)`).
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
 		WithArgs("USD", 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "value"}).
-			AddRow(mockCurrency.ID, mockCurrency.Name, mockCurrency.Value))
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).
+			AddRow(mockCurrency.Code, mockCurrency.Name))
 
 	// When
 	w := helper.PerformRequest(r, "GET", "/currency/usd?"+q.Encode(), nil)
 	require.Equal(t, http.StatusBadRequest, w.Code)
 
-	expected := `{"error":"Invalid finit date format"}`
-	require.Equal(t, expected, w.Body.String())
+	require.Equal(t, "Invalid finit date format", helper.DecodeProblem(t, w).Detail)
 
 	// Verify all expectations were met
 	if err := dbMock.ExpectationsWereMet(); err != nil {
@@ -99,26 +105,348 @@ func TestHandleCurrencyRequest_InvalidFendDate(t *testing.T) {
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
 
-	mockCurrency := models.Currency{
-		ID:    1,
-		Name:  "USD",
-		Value: 1.0,
+	mockCurrency := models.CurrencyMetadata{
+		Code: "USD",
+		Name: "US Dollar",
 	}
 
-	dbMock.ExpectQuery(`SELECT \* FROM "currency" WHERE name = (.+) ORDER BY "currency"."id" LIMIT (.+)`).
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
 		WithArgs("USD", 1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "value"}).
-			AddRow(mockCurrency.ID, mockCurrency.Name, mockCurrency.Value))
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).
+			AddRow(mockCurrency.Code, mockCurrency.Name))
 
 	// When
 	w := helper.PerformRequest(r, "GET", "/currency/usd?"+q.Encode(), nil)
 	require.Equal(t, http.StatusBadRequest, w.Code)
 
-	expected := `{"error":"Invalid fend date format"}`
-	require.Equal(t, expected, w.Body.String())
+	require.Equal(t, "Invalid fend date format", helper.DecodeProblem(t, w).Detail)
 
 	// Verify all expectations were met
 	if err := dbMock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestListCurrencies_FromCache(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	seedLatestCache(t)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Then
+	expected := `[{"code":"USD","data":[{"date":"2024-03-01T19:15:00","value":1}]}]`
+	require.Equal(t, expected, w.Body.String())
+	require.NotEmpty(t, w.Header().Get("ETag"))
+	require.Equal(t, "Fri, 01 Mar 2024 19:15:00 GMT", w.Header().Get("Last-Modified"))
+	require.Contains(t, w.Header().Get("Cache-Control"), "private")
+}
+
+func TestListCurrencies_PrivateWhenAuthenticated(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", middleware.JWTAuth(), ListCurrencies)
+
+	seedLatestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	user := models.User{ID: 1, Username: "test", Role: models.RoleUser}
+	token, err := auth.GenerateToken(user)
+	require.NoError(t, err)
+
+	dbMock.ExpectQuery(`SELECT "id","username","role","token_version" FROM "user" WHERE id = (.+) LIMIT (.+)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "token_version"}).AddRow(1, "test", models.RoleUser, 0))
+
+	// When
+	w := performConditionalRequest(r, "/currencies", "Authorization", middleware.BearerSchema+token)
+
+	// Then shared caches must not store the response for other clients
+	require.Equal(t, http.StatusOK, w.Code)
+	require.True(t, strings.HasPrefix(w.Header().Get("Cache-Control"), "private, "))
+	require.Equal(t, "Authorization", w.Header().Get("Vary"))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestListCurrencies_NewSnapshot(t *testing.T) {
+	// Given rates cached before the daemon stored new ones
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	seedLatestCache(t)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	updatedAt := time.Date(2024, 3, 1, 20, 15, 0, 0, time.UTC)
+	require.NoError(t, cache.Set(context.Background(), cache.Latest, "snapshot", snapshot{UpdatedAt: updatedAt}))
+	dbMock.ExpectQuery(`SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+ORDER BY c.code`).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).AddRow("USD", updatedAt, "1"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies", nil)
+
+	// Then the validators of the new rates come with them rather than the cached ones
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "Fri, 01 Mar 2024 20:15:00 GMT", w.Header().Get("Last-Modified"))
+	require.Equal(t, `[{"code":"USD","data":[{"date":"2024-03-01T20:15:00","value":1}]}]`, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestListCurrencies_IfNoneMatch(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	seedLatestCache(t)
+	etag := helper.PerformRequest(r, "GET", "/currencies", nil).Header().Get("ETag")
+
+	// When
+	w := performConditionalRequest(r, "/currencies", "If-None-Match", etag)
+
+	// Then
+	require.Equal(t, http.StatusNotModified, w.Code)
+	require.Empty(t, w.Body.String())
+	require.Equal(t, etag, w.Header().Get("ETag"))
+}
+
+func TestListCurrencies_IfNoneMatchStale(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	seedLatestCache(t)
+
+	// When
+	w := performConditionalRequest(r, "/currencies", "If-None-Match", `W/"outdated"`)
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestListCurrencies_IfModifiedSince(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	seedLatestCache(t)
+
+	// When
+	notModified := performConditionalRequest(r, "/currencies", "If-Modified-Since", "Fri, 01 Mar 2024 19:15:00 GMT")
+	modified := performConditionalRequest(r, "/currencies", "If-Modified-Since", "Fri, 01 Mar 2024 19:14:59 GMT")
+
+	// Then
+	require.Equal(t, http.StatusNotModified, notModified.Code)
+	require.Equal(t, http.StatusOK, modified.Code)
+}
+
+func TestListCurrencies_Symbols(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	seedLatestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(`SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+WHERE c.code IN \(\$1,\$2\)`).
+		WithArgs("MXN", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).AddRow("USD", at, "1"))
+	dbMock.ExpectQuery(`SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+WHERE c.code IN \(\$1\)`).
+		WithArgs("EUR").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies?symbols=usd,%20mxn", nil)
+	missing := helper.PerformRequest(r, "GET", "/currencies?symbols=EUR", nil)
+	invalid := helper.PerformRequest(r, "GET", "/currencies?symbols=US-D", nil)
+
+	// Then only the currencies asked for are read
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `[{"code":"USD","data":[{"date":"2024-03-01T19:15:00","value":1}]}]`, w.Body.String())
+	require.Equal(t, http.StatusNotFound, missing.Code)
+	require.Equal(t, http.StatusBadRequest, invalid.Code)
+	require.Equal(t, "Invalid symbols", helper.DecodeProblem(t, invalid).Detail)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestListCurrencies_DatabaseError(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies", ListCurrencies)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnError(errors.New(`pq: relation "currency" does not exist`))
+	dbMock.ExpectQuery(`SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+ORDER BY c.code`).
+		WillReturnError(errors.New(`pq: relation "currency" does not exist`))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies", nil)
+
+	// Then the database error is not revealed
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, "internal_error", helper.DecodeProblem(t, w).Code)
+	require.NotContains(t, w.Body.String(), "relation")
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestListCurrenciesLegacy_All(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies/all", ListCurrenciesLegacy)
+
+	seedLatestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency\s+UNION ALL(.+)ORDER BY code, created_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("MXN", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), "17.1").
+			AddRow("MXN", time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC), "17.05").
+			AddRow("USD", time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC), "1"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies/all", nil)
+
+	// Then the whole history is returned by currency, as before the endpoint was deprecated
+	require.Equal(t, http.StatusOK, w.Code)
+	expected := `[{"code":"MXN","data":[{"date":"2024-02-01T00:00:00","value":17.1},{"date":"2024-03-01T19:15:00","value":17.05}]},` +
+		`{"code":"USD","data":[{"date":"2024-03-01T19:15:00","value":1}]}]`
+	require.Equal(t, expected, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestListCurrenciesLegacy_AlbanianLek(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies/all", ListCurrenciesLegacy)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
+		WithArgs("ALL", 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies/all?finit=2024-03-01", nil)
+
+	// Then dates select the Albanian Lek
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "Currency is not valid", helper.DecodeProblem(t, w).Detail)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+// seedLatestCache caches the latest rates and their snapshot, so requests for all currencies don't reach the database.
+func seedLatestCache(t *testing.T) {
+	helper.SetupTestCache(t)
+
+	cached := []models.GroupedCurrencies{{
+		Code: "USD",
+		Data: []models.CurrencyData{{Date: "2024-03-01T19:15:00", Value: decimal.NewFromInt(1)}},
+	}}
+	updatedAt := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	require.NoError(t, cache.Set(context.Background(), cache.Latest, validators{ETag: "seeded", snapshot: updatedAt}.cacheKey("latest"), cached))
+	require.NoError(t, cache.Set(context.Background(), cache.Latest, "snapshot", snapshot{UpdatedAt: updatedAt}))
+}
+
+func performConditionalRequest(r *gin.Engine, path, header, value string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set(header, value)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleCurrencyRequest_InvertedRange(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currency/:name", HandleCurrencyRequest)
+
+	q := url.Values{}
+	q.Add("finit", "2024-03-02")
+	q.Add("fend", "2024-03-01")
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
+		WithArgs("USD", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).AddRow("USD", "US Dollar"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currency/usd?"+q.Encode(), nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "finit must not be after fend", helper.DecodeProblem(t, w).Detail)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestHandleCurrencyRequest_InvalidTimeZone(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currency/:name", HandleCurrencyRequest)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currency/usd?tz=Mars/Olympus_Mons", nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "Invalid tz", helper.DecodeProblem(t, w).Detail)
+}
+
+func TestHandleCurrencyRequest_HistoryInTimeZone(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currency/:name", HandleCurrencyRequest)
+
+	q := url.Values{}
+	q.Add("finit", "2024-03-01")
+	q.Add("tz", "America/Mexico_City")
+	q.Add("fend", "2024-03-01")
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
+		WithArgs("USD", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).AddRow("USD", "US Dollar"))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 3, 2, 5, 0, 0, 0, time.UTC)))
+
+	// The day in Mexico City starts at 06:00 UTC
+	start := time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 2, 5, 59, 59, 999999000, time.UTC)
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency`).
+		WithArgs("USD", start, end, "USD", start, end, "USD", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("USD", time.Date(2024, 3, 2, 5, 0, 0, 0, time.UTC), "17.05"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currency/usd?"+q.Encode(), nil)
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, `{"code":"USD","data":[{"date":"2024-03-01T23:00:00-06:00","value":17.05}]}`, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,208 @@
+package currencies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+var (
+	// maxSymbols is the most currencies a history request can ask for
+	maxSymbols = config.Int("HISTORY_MAX_SYMBOLS", 20)
+)
+
+// parseResampling reads the step and fill query params, answering with the error when they are invalid
+func parseResampling(c *gin.Context, dateRange daterange.Range) (rates.Resampling, bool) {
+	resampling, err := resolveResampling(c.Query("step"), c.Query("fill"), dateRange)
+	if err != nil {
+		problem.Write(c, err)
+		return resampling, false
+	}
+
+	return resampling, true
+}
+
+// resolveResampling resolves a step and fill, with errors worded for the client
+func resolveResampling(stepValue, fillValue string, dateRange daterange.Range) (rates.Resampling, error) {
+	return rates.ParseResampling(stepValue, fillValue, dateRange)
+}
+
+// parseStep reads a bucket length, answering with the error when it is invalid or splits the range into too many buckets
+func parseStep(c *gin.Context, value string, dateRange daterange.Range) (time.Duration, bool) {
+	step, err := rates.ParseRangeStep(value, dateRange)
+	if err != nil {
+		problem.Write(c, err)
+		return 0, false
+	}
+
+	return step, true
+}
+
+// knownCurrencies reports whether every currency in codes is in the currencies table
+func knownCurrencies(codes []string) (bool, error) {
+	var known int64
+	if err := database.DB.Model(&models.CurrencyMetadata{}).Where("code IN ?", codes).Count(&known).Error; err != nil {
+		return false, err
+	}
+
+	return known == int64(len(codes)), nil
+}
+
+// GetHistory godoc
+// @Summary Get the history of several currencies
+// @Description Get one series per currency in symbols within a date range, quoted against base
+// @Tags Currencies
+// @Produce json,text/csv,application/x-ndjson,application/vnd.apache.parquet
+// @Param symbols query string true "Comma-separated currency codes"
+// @Param base query string false "Currency the rates are quoted against, USD by default"
+// @Param finit query string false "Start date, as RFC 3339, a date and time in tz, a date, or relative like now-7d. Defaults to a week before fend"
+// @Param fend query string false "End date, in the same formats as finit. Defaults to now"
+// @Param tz query string false "IANA time zone dates are read and rendered in, UTC by default"
+// @Param step query string false "Bucket length series are aligned on, in minutes, hours or days, like 5m, 1h or 1d"
+// @Param fill query string false "How buckets without rates are filled: none, previous or linear. Defaults to none"
+// @Param format query string false "Export format, csv, ndjson or parquet, taking precedence over Accept. JSON by default"
+// @Param Accept header string false "text/csv, application/x-ndjson or application/vnd.apache.parquet to export the rates as rows"
+// @Param If-None-Match header string false "ETag of the cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of the cached copy"
+// @Success 200 {object} []models.GroupedCurrencies
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {string} string "Invalid symbols, base or date range"
+// @Failure 404 {string} string "No currencies found for the specified date range"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /history [get]
+func GetHistory(c *gin.Context) {
+	symbols, err := parseSymbols(c.Query("symbols"))
+	if err != nil || len(symbols) == 0 {
+		problem.Write(c, problem.Invalid("symbols", "Invalid symbols"))
+		return
+	}
+	if len(symbols) > maxSymbols {
+		problem.Write(c, problem.Invalid("symbols", fmt.Sprintf("At most %d symbols can be requested", maxSymbols)))
+		return
+	}
+
+	base := strings.ToUpper(c.DefaultQuery("base", rates.Base))
+	if !symbolPattern.MatchString(base) {
+		problem.Write(c, problem.Invalid("base", "Invalid base"))
+		return
+	}
+
+	dateRange, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	resampling, ok := parseResampling(c, dateRange)
+	if !ok {
+		return
+	}
+
+	format, ok := negotiateFormat(c)
+	if !ok {
+		return
+	}
+
+	// Check every currency is known
+	codes := symbols
+	if !slices.Contains(codes, base) {
+		codes = append(slices.Clone(codes), base)
+	}
+
+	known, err := knownCurrencies(codes)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	if !known {
+		problem.Write(c, errUnknownCurrency)
+		return
+	}
+
+	// Answer from the client copy when the rates did not change since it was fetched
+	validators := responseValidators(c).variant(format)
+	if validators.notModified(c.Request) {
+		validators.write(c)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	// Exports are streamed from the database rather than cached
+	if format != export.JSON {
+		streamHistory(c, validators, format, symbols, base, dateRange, resampling)
+		return
+	}
+
+	// Symbols are sorted, so the same basket shares its cache entry whatever order it was asked in
+	cacheKey := strings.Join(symbols, ",") + "_" + base + "_" + dateRange.Key() + resampling.Key()
+	history, err := cache.GetOrLoad(c.Request.Context(), cache.History, validators.cacheKey(cacheKey), func(ctx context.Context) ([]models.GroupedCurrencies, error) {
+		return loadHistory(ctx, symbols, base, dateRange, resampling)
+	})
+	if errors.Is(err, errNoCurrencies) {
+		problem.Write(c, errNoRates)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	validators.write(c)
+	c.JSON(http.StatusOK, history)
+}
+
+// loadHistory reads the history of every currency in symbols with a single query, quoted against base.
+// Series are resampled before they are rebased, so rates sampled at different times can be divided.
+func loadHistory(ctx context.Context, symbols []string, base string, dateRange daterange.Range, resampling rates.Resampling) ([]models.GroupedCurrencies, error) {
+	codes := symbols
+	if base != rates.Base && !slices.Contains(codes, base) {
+		codes = append(slices.Clone(codes), base)
+	}
+
+	// Retrieve the history from the database, at the resolution kept for each period
+	history, err := rates.History(ctx, database.DB, codes, dateRange.Start, dateRange.End)
+	if err != nil {
+		return nil, err
+	}
+	if resampling.Step > 0 {
+		grid := rates.NewGrid(dateRange.Start, dateRange.End, resampling.Step, dateRange.Location)
+		history = rates.Resample(history, grid, resampling.Fill)
+	}
+	history = rates.Rebase(history, base)
+
+	// History is ordered by code, so every series is a run of rows
+	series := make(map[string][]models.CurrencyData, len(symbols))
+	for _, rate := range history {
+		series[rate.Code] = append(series[rate.Code], models.CurrencyData{
+			Date:   dateRange.Format(rate.Time),
+			Value:  rate.Value,
+			Filled: rate.Filled,
+		})
+	}
+
+	var groupedCurrencies []models.GroupedCurrencies
+	for _, symbol := range symbols {
+		if data, ok := series[symbol]; ok {
+			groupedCurrencies = append(groupedCurrencies, models.GroupedCurrencies{Code: symbol, Data: data})
+		}
+	}
+
+	if len(groupedCurrencies) == 0 {
+		return nil, errNoCurrencies
+	}
+
+	return groupedCurrencies, nil
+}
@@ -0,0 +1,137 @@
+package currencies
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestGetHistory_RebasedBasket(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 23, 59, 59, 999999000, time.UTC)
+
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2,\$3\)`).
+		WithArgs("BRL", "MXN", "EUR").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(at))
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY code, created_at`).
+		WithArgs("BRL", "MXN", "EUR", start, end, "BRL", "MXN", "EUR", start, end, "BRL", "MXN", "EUR", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("BRL", at, "5").
+			AddRow("EUR", at, "0.8").
+			AddRow("MXN", at, "17"))
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2,\$3\)`).
+		WithArgs("BRL", "MXN", "EUR").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/history?symbols=mxn,BRL&base=eur&finit=2024-03-01&fend=2024-03-01", nil)
+	cached := helper.PerformRequest(r, "GET", "/history?symbols=BRL,MXN&base=EUR&finit=2024-03-01&fend=2024-03-01", nil)
+
+	// Then one series per symbol, quoted against EUR, and the same basket in another order hits the cache
+	require.Equal(t, http.StatusOK, w.Code)
+	expected := `[{"code":"BRL","data":[{"date":"2024-03-01T19:15:00","value":6.25}]},{"code":"MXN","data":[{"date":"2024-03-01T19:15:00","value":21.25}]}]`
+	require.Equal(t, expected, w.Body.String())
+	require.Equal(t, http.StatusOK, cached.Code)
+	require.Equal(t, expected, cached.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetHistory_MissingSymbols(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/history?finit=2024-03-01", nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "Invalid symbols", helper.DecodeProblem(t, w).Detail)
+}
+
+func TestGetHistory_UnknownCurrency(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2\)`).
+		WithArgs("XYZ", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/history?symbols=XYZ", nil)
+
+	// Then
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "Currency is not valid", helper.DecodeProblem(t, w).Detail)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetHistory_Resampled(t *testing.T) {
+	// Given EUR and MXN sampled at different times
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT count\(\*\) FROM "currencies" WHERE code IN \(\$1,\$2\)`).
+		WithArgs("MXN", "EUR").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY code, created_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", time.Date(2024, 3, 1, 10, 1, 0, 0, time.UTC), "0.8").
+			AddRow("EUR", time.Date(2024, 3, 1, 11, 2, 0, 0, time.UTC), "0.8").
+			AddRow("MXN", time.Date(2024, 3, 1, 10, 3, 0, 0, time.UTC), "16"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/history?symbols=MXN&base=EUR&finit=2024-03-01T10:00:00&fend=2024-03-01T11:30:00&step=1h&fill=previous", nil)
+
+	// Then the series are aligned on hours before they are rebased
+	require.Equal(t, http.StatusOK, w.Code)
+	expected := `[{"code":"MXN","data":[{"date":"2024-03-01T10:00:00","value":20},{"date":"2024-03-01T11:00:00","value":20,"filled":true}]}]`
+	require.Equal(t, expected, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetHistory_InvalidStep(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/history", GetHistory)
+
+	// When
+	invalid := helper.PerformRequest(r, "GET", "/history?symbols=MXN&step=1w", nil)
+	tooFine := helper.PerformRequest(r, "GET", "/history?symbols=MXN&finit=now-365d&step=1m", nil)
+	fillOnly := helper.PerformRequest(r, "GET", "/history?symbols=MXN&fill=linear", nil)
+
+	// Then
+	require.Equal(t, "Invalid step", helper.DecodeProblem(t, invalid).Detail)
+	require.Equal(t, "The step must split the range into at most 10000 buckets", helper.DecodeProblem(t, tooFine).Detail)
+	require.Equal(t, "fill requires a step", helper.DecodeProblem(t, fillOnly).Detail)
+}
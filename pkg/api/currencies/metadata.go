@@ -0,0 +1,46 @@
+package currencies
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+)
+
+// GetCurrencyMetadata godoc
+// @Summary Get currency metadata
+// @Description Get the name, symbol and minor units of every currency, by ISO 4217 code
+// @Tags Currencies
+// @Produce json
+// @Security JwtAuth
+// @Success 200 {object} []models.CurrencyMetadata
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /currencies/meta [get]
+func GetCurrencyMetadata(c *gin.Context) {
+	metadata, err := Metadata(c.Request.Context())
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, metadata)
+}
+
+// Metadata returns every currency, ordered by code, from the cache or the database on a miss
+func Metadata(ctx context.Context) ([]models.CurrencyMetadata, error) {
+	return cache.GetOrLoad(ctx, cache.Metadata, "all", loadCurrencyMetadata)
+}
+
+// loadCurrencyMetadata reads every currency from the database, ordered by code
+func loadCurrencyMetadata(ctx context.Context) ([]models.CurrencyMetadata, error) {
+	metadata := []models.CurrencyMetadata{}
+	if err := database.DB.WithContext(ctx).Order("code").Find(&metadata).Error; err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
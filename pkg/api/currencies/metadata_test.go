@@ -0,0 +1,39 @@
+package currencies
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestGetCurrencyMetadata(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies/meta", GetCurrencyMetadata)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" ORDER BY code`).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name", "minor_units", "symbol", "active"}).
+			AddRow("JPY", "Yen", 0, "¥", true).
+			AddRow("MXN", "Mexican Peso", 2, "$", true))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies/meta", nil)
+	cached := helper.PerformRequest(r, "GET", "/currencies/meta", nil)
+
+	// Then the second request is served from the cache
+	require.Equal(t, http.StatusOK, w.Code)
+	expected := `[{"code":"JPY","name":"Yen","minor_units":0,"symbol":"¥","active":true},{"code":"MXN","name":"Mexican Peso","minor_units":2,"symbol":"$","active":true}]`
+	require.Equal(t, expected, w.Body.String())
+	require.Equal(t, expected, cached.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
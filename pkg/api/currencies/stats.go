@@ -0,0 +1,137 @@
+package currencies
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+	"gorm.io/gorm"
+)
+
+// maxWindow is the longest moving average window, in samples
+const maxWindow = 1000
+
+// GetCurrencyStats godoc
+// @Summary Get currency statistics
+// @Description Get the min, max, mean, standard deviation, percent change, annualized volatility and moving averages of a currency within a date range
+// @Tags Currencies
+// @Produce json
+// @Param name path string true "Currency code"
+// @Param finit query string false "Start date, as RFC 3339, a date and time in tz, a date, or relative like now-7d. Defaults to a week before fend"
+// @Param fend query string false "End date, in the same formats as finit. Defaults to now"
+// @Param tz query string false "IANA time zone dates are read and rendered in, UTC by default"
+// @Param sma query string false "Comma-separated simple moving average windows, in samples. Defaults to 20,50"
+// @Param ema query string false "Comma-separated exponential moving average windows, in samples. Defaults to 12,26"
+// @Param If-None-Match header string false "ETag of the cached copy"
+// @Param If-Modified-Since header string false "Last-Modified of the cached copy"
+// @Success 200 {object} models.CurrencyStats
+// @Success 304 {string} string "Not Modified"
+// @Failure 400 {string} string "Invalid date range or windows"
+// @Failure 404 {string} string "No currencies found for the specified date range"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /currencies/{name}/stats [get]
+func GetCurrencyStats(c *gin.Context) {
+	currencyName := strings.ToUpper(c.Param("name"))
+
+	sma, err := parseWindows(c.DefaultQuery("sma", "20,50"))
+	if err != nil {
+		problem.Write(c, problem.Invalid("sma", "Invalid sma"))
+		return
+	}
+
+	ema, err := parseWindows(c.DefaultQuery("ema", "12,26"))
+	if err != nil {
+		problem.Write(c, problem.Invalid("ema", "Invalid ema"))
+		return
+	}
+
+	// Check the currency is known
+	var currency models.CurrencyMetadata
+	err = database.DB.WithContext(c.Request.Context()).Where("code = ?", currencyName).First(&currency).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		problem.Write(c, errUnknownCurrency)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	dateRange, ok := parseDateRange(c)
+	if !ok {
+		return
+	}
+
+	// Answer from the client copy when the rates did not change since it was fetched
+	validators := responseValidators(c)
+	if validators.notModified(c.Request) {
+		validators.write(c)
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	cacheKey := fmt.Sprintf("stats_%s_%s_%v_%v", currencyName, dateRange.Key(), sma, ema)
+	stats, err := cache.GetOrLoad(c.Request.Context(), cache.History, validators.cacheKey(cacheKey), func(ctx context.Context) (models.CurrencyStats, error) {
+		return loadCurrencyStats(ctx, currencyName, dateRange, sma, ema)
+	})
+	if errors.Is(err, errNoCurrencies) {
+		problem.Write(c, errNoRates)
+		return
+	}
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	validators.write(c)
+	c.JSON(http.StatusOK, stats)
+}
+
+// loadCurrencyStats computes the statistics of a currency within a date range
+func loadCurrencyStats(ctx context.Context, currencyName string, dateRange daterange.Range, sma, ema []int) (models.CurrencyStats, error) {
+	stats, err := rates.Stats(ctx, database.DB, currencyName, dateRange.Start, dateRange.End, sma, ema)
+	if err != nil {
+		return models.CurrencyStats{}, err
+	}
+
+	if stats.Samples == 0 {
+		return models.CurrencyStats{}, errNoCurrencies
+	}
+
+	stats.From = dateRange.Format(dateRange.Start)
+	stats.To = dateRange.Format(dateRange.End)
+
+	return stats, nil
+}
+
+// parseWindows returns the distinct moving average windows of a comma-separated list
+func parseWindows(query string) ([]int, error) {
+	var windows []int
+	for _, value := range strings.Split(query, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		window, err := strconv.Atoi(value)
+		if err != nil || window < 1 || window > maxWindow {
+			return nil, fmt.Errorf("invalid window %q", value)
+		}
+		if !slices.Contains(windows, window) {
+			windows = append(windows, window)
+		}
+	}
+
+	return windows, nil
+}
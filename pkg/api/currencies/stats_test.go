@@ -0,0 +1,78 @@
+package currencies
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestGetCurrencyStats(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies/:name/stats", GetCurrencyStats)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
+		WithArgs("MXN", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).AddRow("MXN", "Mexican Peso"))
+	dbMock.ExpectQuery(`SELECT MAX\(created_at\) FROM "currency"`).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)))
+	dbMock.ExpectQuery(`WITH history AS (.+) AS sma_5\s+FROM returns`).
+		WillReturnRows(sqlmock.NewRows([]string{"samples", "min", "max", "mean", "std_dev", "first", "last", "volatility", "sma_5"}).
+			AddRow(int64(3), "16.5", "17.5", "17", "0.5", "16.5", "17.5", "0.12", nil))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies/mxn/stats?finit=2024-03-01&fend=2024-03-01&sma=5&ema=", nil)
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	expected := `{"code":"MXN","from":"2024-03-01T00:00:00","to":"2024-03-01T23:59:59","samples":3,"min":16.5,"max":17.5,"mean":17,"stddev":0.5,"change":6.0606060606060606,"volatility":0.12,"sma":{"5":null},"ema":{}}`
+	require.Equal(t, expected, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestGetCurrencyStats_InvalidWindow(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies/:name/stats", GetCurrencyStats)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies/mxn/stats?sma=0", nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "Invalid sma", helper.DecodeProblem(t, w).Detail)
+}
+
+func TestGetCurrencyStats_DatabaseError(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/currencies/:name/stats", GetCurrencyStats)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "currencies" WHERE code = (.+) ORDER BY "currencies"."code" LIMIT (.+)`).
+		WithArgs("MXN", 1).
+		WillReturnError(errors.New("connection refused"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/currencies/mxn/stats", nil)
+
+	// Then the outage is not reported as an unknown currency
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, "internal_error", helper.DecodeProblem(t, w).Code)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
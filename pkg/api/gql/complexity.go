@@ -0,0 +1,187 @@
+package gql
+
+import (
+	"math"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+var (
+	// maxDepth is the deepest a query can nest selections, leaving room for the introspection query of GraphQL tools
+	maxDepth = config.Int("GRAPHQL_MAX_DEPTH", 15)
+	// maxComplexity bounds the estimated cost of a query
+	maxComplexity = config.Int("GRAPHQL_MAX_COMPLEXITY", 5000)
+	// listSize is the length assumed for lists whose length is not bounded by a symbols argument
+	listSize = config.Int("GRAPHQL_LIST_SIZE", 100)
+	// sampleInterval is how often the daemon stores rates, so how far apart raw history samples are
+	sampleInterval = config.Seconds("DAEMON_WAKEUP", time.Minute)
+)
+
+// measure estimates the cost of an operation before it runs. Every field costs one, plus the cost of its selection
+// times the length of the list it returns, taken from its symbols argument or listSize otherwise, so a query asking
+// for the history of every currency costs far more than one asking for two. The length of a history is the number of
+// buckets its range splits into, or of raw samples it may hold without a step.
+func measure(schema graphql.Schema, document *ast.Document, operation *ast.OperationDefinition, variables map[string]interface{}) (complexity, depth int) {
+	m := measurer{fragments: make(map[string]*ast.FragmentDefinition), variables: variables, visiting: make(map[string]bool)}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			m.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	var root graphql.Type = schema.QueryType()
+	if operation.Operation == ast.OperationTypeSubscription {
+		root = schema.SubscriptionType()
+	}
+
+	complexity = m.selectionSet(operation.SelectionSet, root, 1)
+	return complexity, m.depth
+}
+
+type measurer struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// visiting holds the fragments being measured, validation rejects cycles but measuring comes first
+	visiting map[string]bool
+	depth    int
+}
+
+func (m *measurer) selectionSet(set *ast.SelectionSet, parent graphql.Type, depth int) int {
+	if set == nil {
+		return 0
+	}
+
+	cost := 0
+	for _, selection := range set.Selections {
+		switch selection := selection.(type) {
+		case *ast.Field:
+			cost = saturatedAdd(cost, m.field(selection, parent, depth))
+		case *ast.InlineFragment:
+			cost = saturatedAdd(cost, m.selectionSet(selection.SelectionSet, parent, depth))
+		case *ast.FragmentSpread:
+			name := selection.Name.Value
+			if fragment, ok := m.fragments[name]; ok && !m.visiting[name] {
+				m.visiting[name] = true
+				cost = saturatedAdd(cost, m.selectionSet(fragment.SelectionSet, parent, depth))
+				m.visiting[name] = false
+			}
+		}
+	}
+
+	return cost
+}
+
+func (m *measurer) field(field *ast.Field, parent graphql.Type, depth int) int {
+	m.depth = max(m.depth, depth)
+	if field.SelectionSet == nil {
+		return 1
+	}
+
+	var fieldType graphql.Type
+	if object, ok := parent.(*graphql.Object); ok {
+		if definition, ok := object.Fields()[field.Name.Value]; ok {
+			fieldType = definition.Type
+		}
+	}
+
+	size := 1
+	fieldType, isList := unwrapList(fieldType)
+	if isList && field.Name.Value == "history" {
+		size = m.historySize(field)
+	} else if isList {
+		size = m.listSize(field)
+	}
+
+	return saturatedAdd(1, saturatedMul(size, m.selectionSet(field.SelectionSet, fieldType, depth+1)))
+}
+
+// listSize is the length of the symbols argument of field, or listSize without one
+func (m *measurer) listSize(field *ast.Field) int {
+	if values, ok := m.argument(field, "symbols").([]interface{}); ok {
+		return len(values)
+	}
+
+	return listSize
+}
+
+// historySize is the number of rates a history field may return: the buckets of its range with a step, or the raw
+// samples stored within it without one. Arguments that are not valid are left for the resolver to reject.
+func (m *measurer) historySize(field *ast.Field) int {
+	argument := func(name string) string {
+		value, _ := m.argument(field, name).(string)
+		return value
+	}
+
+	dateRange, err := daterange.Parse(argument("finit"), argument("fend"), argument("tz"), time.Now())
+	if err != nil {
+		return 1
+	}
+
+	interval := sampleInterval
+	if step := argument("step"); step != "" {
+		if interval, err = rates.ParseRangeStep(step, dateRange); err != nil {
+			return 1
+		}
+	}
+
+	return saturatedAdd(int(dateRange.End.Sub(dateRange.Start)/interval), 1)
+}
+
+// argument returns the value of the argument name of field, read from the variables when it is one, or nil
+func (m *measurer) argument(field *ast.Field, name string) interface{} {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != name {
+			continue
+		}
+
+		switch value := argument.Value.(type) {
+		case *ast.Variable:
+			return m.variables[value.Name.Value]
+		case *ast.ListValue:
+			values := make([]interface{}, len(value.Values))
+			for i, item := range value.Values {
+				values[i] = item.GetValue()
+			}
+			return values
+		default:
+			return value.GetValue()
+		}
+	}
+
+	return nil
+}
+
+// unwrapList strips the non-null and list wrappers of t, reporting whether it is a list
+func unwrapList(t graphql.Type) (graphql.Type, bool) {
+	isList := false
+	for {
+		switch wrapper := t.(type) {
+		case *graphql.NonNull:
+			t = wrapper.OfType
+		case *graphql.List:
+			isList = true
+			t = wrapper.OfType
+		default:
+			return t, isList
+		}
+	}
+}
+
+func saturatedAdd(a, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
+func saturatedMul(a, b int) int {
+	if a != 0 && b > math.MaxInt32/a {
+		return math.MaxInt32
+	}
+	return a * b
+}
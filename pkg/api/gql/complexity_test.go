@@ -0,0 +1,55 @@
+package gql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/require"
+)
+
+func measureQuery(t *testing.T, query string, variables map[string]interface{}) (int, int) {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	require.NoError(t, err)
+
+	return measure(Schema, document, document.Definitions[0].(*ast.OperationDefinition), variables)
+}
+
+func TestMeasure_ListSizeFromSymbols(t *testing.T) {
+	// When
+	literal, depth := measureQuery(t, `{ currencies(symbols: ["EUR", "MXN"]) { code latest { value } } }`, nil)
+	variable, _ := measureQuery(t, `query ($symbols: [String!]) { currencies(symbols: $symbols) { code } }`,
+		map[string]interface{}{"symbols": []interface{}{"EUR", "MXN", "JPY"}})
+	unbounded, _ := measureQuery(t, `{ currencies { code } }`, nil)
+
+	// Then
+	require.Equal(t, 1+2*(1+2), literal)
+	require.Equal(t, 3, depth)
+	require.Equal(t, 1+3*1, variable)
+	require.Equal(t, 1+listSize*1, unbounded)
+}
+
+func TestMeasure_Fragments(t *testing.T) {
+	// When
+	complexity, depth := measureQuery(t, `
+		query { currencies(symbols: ["EUR"]) { ...fields } }
+		fragment fields on Currency { code ... on Currency { name } }`, nil)
+
+	// Then
+	require.Equal(t, 1+1*2, complexity)
+	require.Equal(t, 2, depth)
+}
+
+func TestMeasure_HistorySize(t *testing.T) {
+	// When
+	stepped, _ := measureQuery(t, `{ currency(code: "MXN") { history(finit: "2024-03-01", fend: "2024-03-30", step: "1d") { value } } }`, nil)
+	raw, _ := measureQuery(t, `query ($finit: String) { currency(code: "MXN") { history(finit: $finit, fend: "2024-03-01T01:00:00") { value } } }`,
+		map[string]interface{}{"finit": "2024-03-01T00:00:00"})
+	invalid, _ := measureQuery(t, `{ currency(code: "MXN") { history(step: "1w") { value } } }`, nil)
+
+	// Then every bucket, or every raw sample without a step, is priced
+	require.Equal(t, 1+(1+30*1), stepped)
+	require.Equal(t, 1+(1+int(time.Hour/sampleInterval+1)*1), raw)
+	require.Equal(t, 1+(1+1), invalid)
+}
@@ -0,0 +1,274 @@
+// Package gql serves currencies, rates and conversions over GraphQL.
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+)
+
+// heartbeat is how often an idle subscription sends a comment, so proxies keep its connection open
+const heartbeat = 15 * time.Second
+
+// Request is a GraphQL request, sent as the JSON body of a POST or the query string of a GET
+type Request struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Handle godoc
+// @Summary Run a GraphQL operation
+// @Description Runs a query against the schema of currencies, their latest rates, history and conversions.
+// @Description Subscriptions are answered as server-sent events, a next event per result and a complete event at the end.
+// @Tags GraphQL
+// @Accept json
+// @Produce json,text/event-stream
+// @Security JwtAuth
+// @Param request body Request false "Operation, sent as the body of a POST"
+// @Param query query string false "Operation, sent in the query string of a GET"
+// @Param operationName query string false "Operation to run when the document has several"
+// @Param variables query string false "Variables, as a JSON object"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /graphql [get]
+// @Router /graphql [post]
+func Handle(c *gin.Context) {
+	request, err := readRequest(c)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	document, operation, errs := prepare(request)
+	if len(errs) > 0 {
+		c.JSON(http.StatusOK, &graphql.Result{Errors: errs})
+		return
+	}
+
+	params := graphql.ExecuteParams{
+		Schema:        Schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       withLoaders(context.WithValue(c.Request.Context(), requestIDKey{}, c.GetString("request_id"))),
+	}
+
+	if operation != nil && operation.Operation == ast.OperationTypeSubscription {
+		subscribe(c, params)
+		return
+	}
+
+	c.JSON(http.StatusOK, format(graphql.Execute(params)))
+}
+
+// readRequest reads the request from the query string of a GET or the body of a POST
+func readRequest(c *gin.Context) (Request, error) {
+	var request Request
+	if c.Request.Method != http.MethodGet {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			return request, problem.Binding(err)
+		}
+		return request, nil
+	}
+
+	request.Query = c.Query("query")
+	request.OperationName = c.Query("operationName")
+	if request.Query == "" {
+		return request, problem.Invalid("query", "query is required")
+	}
+	if variables := c.Query("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return request, problem.Invalid("variables", "variables must be a JSON object")
+		}
+	}
+
+	return request, nil
+}
+
+// prepare parses and validates the document of request, and checks the operation it runs is within the limits
+func prepare(request Request) (*ast.Document, *ast.OperationDefinition, []gqlerrors.FormattedError) {
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(request.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		return nil, nil, gqlerrors.FormatErrors(err)
+	}
+
+	if result := graphql.ValidateDocument(&Schema, document, nil); !result.IsValid {
+		return nil, nil, result.Errors
+	}
+
+	// An operation that can't be found is left for Execute to answer
+	operation := findOperation(document, request.OperationName)
+	if operation == nil {
+		return document, nil, nil
+	}
+
+	complexity, depth := measure(Schema, document, operation, request.Variables)
+	if depth > maxDepth {
+		return nil, nil, []gqlerrors.FormattedError{limitError("query_too_deep",
+			fmt.Sprintf("The query is nested %d levels deep, more than the maximum of %d", depth, maxDepth))}
+	}
+	if complexity > maxComplexity {
+		return nil, nil, []gqlerrors.FormattedError{limitError("query_too_complex",
+			fmt.Sprintf("The query has a complexity of %d, more than the maximum of %d", complexity, maxComplexity))}
+	}
+
+	return document, operation, nil
+}
+
+// findOperation returns the operation named name, or the only one of document when name is empty
+func findOperation(document *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = operation
+		} else if operation.Name != nil && operation.Name.Value == name {
+			return operation
+		}
+	}
+
+	return found
+}
+
+func limitError(code, message string) gqlerrors.FormattedError {
+	return gqlerrors.FormattedError{Message: message, Extensions: map[string]interface{}{"code": code}}
+}
+
+// subscribe answers a subscription with a server-sent event per result, until it completes or the client leaves
+func subscribe(c *gin.Context, params graphql.ExecuteParams) {
+	ctx, cancel := context.WithCancel(params.Context)
+	defer cancel()
+	params.Context = ctx
+
+	results := graphql.ExecuteSubscription(params)
+	defer func() {
+		// graphql-go blocks sending a result until it is read, so drain the ones left once the client is gone
+		go func() {
+			for range results {
+			}
+		}()
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Ask proxies such as nginx not to buffer events
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := io.WriteString(c.Writer, ":\n\n"); err != nil {
+				return
+			}
+		case result, ok := <-results:
+			if !ok {
+				c.SSEvent("complete", "")
+				c.Writer.Flush()
+				return
+			}
+			c.SSEvent("next", format(result))
+		}
+		c.Writer.Flush()
+	}
+}
+
+// format restores the extensions of resolver errors returned by thunks, which graphql-go wraps without them
+func format(result *graphql.Result) *graphql.Result {
+	for i, err := range result.Errors {
+		if err.Extensions != nil {
+			continue
+		}
+		if extended := extendedError(err); extended != nil {
+			result.Errors[i].Extensions = extended.Extensions()
+		}
+	}
+
+	return result
+}
+
+func extendedError(err error) gqlerrors.ExtendedError {
+	for err != nil {
+		if extended, ok := err.(gqlerrors.ExtendedError); ok {
+			return extended
+		}
+
+		switch wrapped := err.(type) {
+		case gqlerrors.FormattedError:
+			err = wrapped.OriginalError()
+		case *gqlerrors.Error:
+			err = wrapped.OriginalError
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// resolverError is a problem answered as a GraphQL error, with its code and field errors in its extensions
+type resolverError struct {
+	problem *problem.Error
+}
+
+func (e resolverError) Error() string {
+	return e.problem.Detail
+}
+
+func (e resolverError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.problem.Code}
+	if len(e.problem.Fields) > 0 {
+		extensions["errors"] = e.problem.Fields
+	}
+
+	return extensions
+}
+
+// fail turns err into a resolver error, converted with problem.From. Internal errors are logged with the request ID
+// and answered without their cause.
+func fail(ctx context.Context, err error) error {
+	e := problem.From(err)
+
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("Error resolving GraphQL request_id=%s: %s\n", requestID(ctx), e)
+	}
+
+	return resolverError{problem: e}
+}
+
+type requestIDKey struct{}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,225 @@
+package gql
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+const (
+	currenciesQuery = `SELECT \* FROM "currencies" ORDER BY code`
+	latestQuery     = `SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+WHERE c.code IN \((.+)\)\s+ORDER BY c.code`
+	allLatestQuery  = `SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+ORDER BY c.code`
+	historyQuery    = `SELECT code, created_at, value FROM currency`
+)
+
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func performQuery(t *testing.T, query string, variables map[string]interface{}) response {
+	r := gin.Default()
+	r.POST("/graphql", Handle)
+
+	w := helper.PerformRequest(r, "POST", "/graphql", helper.ToJSON(Request{Query: query, Variables: variables}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	return result
+}
+
+func TestHandle_BatchesLatestRates(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(currenciesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name", "minor_units"}).
+			AddRow("EUR", "Euro", 2).
+			AddRow("MXN", "Mexican Peso", 2))
+	dbMock.ExpectQuery(latestQuery).
+		WithArgs("EUR", "MXN", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", at, "0.92").
+			AddRow("MXN", at, "17.05"))
+
+	// When
+	result := performQuery(t, `{ currencies(symbols: ["EUR", "MXN"]) { code latest { value date } } }`, nil)
+
+	// Then
+	require.Empty(t, result.Errors)
+	require.JSONEq(t, `{"currencies": [
+		{"code": "EUR", "latest": {"value": 0.92, "date": "2024-03-01T19:15:00"}},
+		{"code": "MXN", "latest": {"value": 17.05, "date": "2024-03-01T19:15:00"}}
+	]}`, string(result.Data))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestHandle_HistoryOneQueryPerSeries(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(currenciesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name"}).
+			AddRow("EUR", "Euro").
+			AddRow("MXN", "Mexican Peso"))
+	dbMock.ExpectQuery(historyQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", at, "0.92").
+			AddRow("MXN", at, "17.05"))
+
+	// When
+	result := performQuery(t, `{ currencies(symbols: ["EUR", "MXN"]) {
+		code
+		history(finit: "2024-03-01T19:00:00", fend: "2024-03-01T20:00:00") { value }
+	} }`, nil)
+
+	// Then
+	require.Empty(t, result.Errors)
+	require.JSONEq(t, `{"currencies": [
+		{"code": "EUR", "history": [{"value": 0.92}]},
+		{"code": "MXN", "history": [{"value": 17.05}]}
+	]}`, string(result.Data))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestHandle_Convert(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(currenciesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name", "minor_units"}).
+			AddRow("MXN", "Mexican Peso", 2))
+	dbMock.ExpectQuery(latestQuery).
+		WithArgs("EUR", "MXN").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", at, "0.92").
+			AddRow("MXN", at, "17.05"))
+
+	// When
+	result := performQuery(t, `query ($amount: Decimal!) { convert(amount: $amount, from: "EUR", to: "MXN") { result } }`,
+		map[string]interface{}{"amount": "100"})
+
+	// Then
+	require.Empty(t, result.Errors)
+	require.JSONEq(t, `{"convert": {"result": 1853.26}}`, string(result.Data))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestHandle_InvalidArgument(t *testing.T) {
+	// When
+	result := performQuery(t, `{ currencies(symbols: ["US-D"]) { code } }`, nil)
+
+	// Then
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "Invalid symbols", result.Errors[0].Message)
+	require.Equal(t, "validation_failed", result.Errors[0].Extensions["code"])
+}
+
+func TestHandle_TooComplex(t *testing.T) {
+	// When
+	result := performQuery(t, `{ currencies { history { value } } }`, nil)
+
+	// Then
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "query_too_complex", result.Errors[0].Extensions["code"])
+}
+
+func TestHandle_MissingQuery(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/graphql", Handle)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/graphql", []byte(`{}`))
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "validation_failed", helper.DecodeProblem(t, w).Code)
+}
+
+func TestHandle_Subscription(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	first := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(allLatestQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).AddRow("MXN", first, "17.05"))
+	dbMock.ExpectQuery(allLatestQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).AddRow("MXN", first.Add(time.Hour), "17.10"))
+
+	r := gin.Default()
+	r.GET("/graphql", Handle)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := url.Values{"query": {`subscription { rates(symbols: ["MXN"]) { code value } }`}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/graphql?"+query.Encode(), nil)
+	require.NoError(t, err)
+
+	// When
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	// Then
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	events := bufio.NewScanner(res.Body)
+	require.Equal(t, `{"data":{"rates":[{"code":"MXN","value":17.05}]}}`, nextEvent(t, events))
+
+	require.NoError(t, cache.Latest.Invalidate(ctx))
+	require.Equal(t, `{"data":{"rates":[{"code":"MXN","value":17.1}]}}`, nextEvent(t, events))
+}
+
+// nextEvent reads the data of the next event of a subscription
+func nextEvent(t *testing.T, events *bufio.Scanner) string {
+	event := ""
+	for events.Scan() {
+		line := events.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:") && event == "next":
+			return strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+
+	require.NoError(t, events.Err())
+	t.Fatal("subscription ended without an event")
+	return ""
+}
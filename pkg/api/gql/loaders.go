@@ -0,0 +1,197 @@
+package gql
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/api/currencies"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+// loader batches the keys loaded while a level of the query resolves into a single fetch.
+// Resolvers return a thunk calling the func load returns, and graphql-go only calls thunks once every field of the
+// level was resolved, so the first one fetches the keys of all the others.
+type loader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	results map[K]loaded[V]
+}
+
+// loaded is the outcome of fetching a key, found unless the fetch had no value for it
+type loaded[V any] struct {
+	value V
+	found bool
+	err   error
+}
+
+func newLoader[K comparable, V any](fetch func(ctx context.Context, keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, queued: make(map[K]bool), results: make(map[K]loaded[V])}
+}
+
+// load queues key for the next fetch, and returns a func waiting for its value
+func (l *loader[K, V]) load(ctx context.Context, key K) func() (V, bool, error) {
+	l.mu.Lock()
+	if _, done := l.results[key]; !done && !l.queued[key] {
+		l.queued[key] = true
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, bool, error) {
+		return l.get(ctx, key)
+	}
+}
+
+func (l *loader[K, V]) get(ctx context.Context, key K) (V, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if result, done := l.results[key]; done {
+		return result.value, result.found, result.err
+	}
+
+	keys := l.pending
+	l.pending = nil
+	clear(l.queued)
+
+	values, err := l.fetch(ctx, keys)
+	for _, k := range keys {
+		value, found := values[k]
+		l.results[k] = loaded[V]{value: value, found: found, err: err}
+	}
+
+	result := l.results[key]
+	return result.value, result.found, result.err
+}
+
+// series is the history asked for by a history field, shared by the currencies it is asked on
+type series struct {
+	Base  string
+	Range daterange.Range
+	rates.Resampling
+}
+
+// seriesKey identifies the history of a currency
+type seriesKey struct {
+	Code   string
+	Series series
+}
+
+// loaders batch the database reads of a request
+type loaders struct {
+	metadata *loader[string, models.CurrencyMetadata]
+	latest   *loader[string, rates.Sample]
+	history  *loader[seriesKey, []rates.Sample]
+}
+
+type loadersKey struct{}
+
+// withLoaders returns a context carrying new loaders, which cache what they read for as long as the request lasts
+func withLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		metadata: newLoader(fetchMetadata),
+		latest:   newLoader(fetchLatest),
+		history:  newLoader(fetchHistory),
+	})
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// fetchMetadata reads the currencies in codes from the metadata cache, which holds every currency
+func fetchMetadata(ctx context.Context, codes []string) (map[string]models.CurrencyMetadata, error) {
+	metadata, err := currencies.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byCode := make(map[string]models.CurrencyMetadata, len(codes))
+	for _, currency := range metadata {
+		if slices.Contains(codes, currency.Code) {
+			byCode[currency.Code] = currency
+		}
+	}
+
+	return byCode, nil
+}
+
+// fetchLatest reads the last rate of the currencies in codes with one query, cached in the latest namespace
+func fetchLatest(ctx context.Context, codes []string) (map[string]rates.Sample, error) {
+	codes = sortedCodes(codes)
+	latest, err := cache.GetOrLoad(ctx, cache.Latest, "graphql_"+strings.Join(codes, ","), func(ctx context.Context) ([]rates.Sample, error) {
+		return rates.Latest(ctx, database.DB, codes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	byCode := make(map[string]rates.Sample, len(latest))
+	for _, rate := range latest {
+		byCode[rate.Code] = rate
+	}
+
+	return byCode, nil
+}
+
+// fetchHistory reads the history of every currency asked for the same series with one query, resampled and rebased
+// like /history, and cached in the history namespace
+func fetchHistory(ctx context.Context, keys []seriesKey) (map[seriesKey][]rates.Sample, error) {
+	codesBySeries := make(map[series][]string)
+	for _, key := range keys {
+		codesBySeries[key.Series] = append(codesBySeries[key.Series], key.Code)
+	}
+
+	history := make(map[seriesKey][]rates.Sample, len(keys))
+	for series, codes := range codesBySeries {
+		codes = sortedCodes(codes)
+		cacheKey := "graphql_" + strings.Join(codes, ",") + "_" + series.Base + "_" + series.Range.Key() + series.Resampling.Key()
+		samples, err := cache.GetOrLoad(ctx, cache.History, cacheKey, func(ctx context.Context) ([]rates.Sample, error) {
+			return loadHistory(ctx, codes, series)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sample := range samples {
+			key := seriesKey{Code: sample.Code, Series: series}
+			history[key] = append(history[key], sample)
+		}
+	}
+
+	return history, nil
+}
+
+// loadHistory reads the history of the currencies in codes for series from the database
+func loadHistory(ctx context.Context, codes []string, series series) ([]rates.Sample, error) {
+	if series.Base != rates.Base && !slices.Contains(codes, series.Base) {
+		codes = append(slices.Clone(codes), series.Base)
+	}
+
+	samples, err := rates.History(ctx, database.DB, codes, series.Range.Start, series.Range.End)
+	if err != nil {
+		return nil, err
+	}
+	if series.Step > 0 {
+		grid := rates.NewGrid(series.Range.Start, series.Range.End, series.Step, series.Range.Location)
+		samples = rates.Resample(samples, grid, series.Fill)
+	}
+
+	return rates.Rebase(samples, series.Base), nil
+}
+
+// sortedCodes returns a sorted copy of codes, so the same currencies share a cache key whatever order they were asked in
+func sortedCodes(codes []string) []string {
+	codes = slices.Clone(codes)
+	slices.Sort(codes)
+	return codes
+}
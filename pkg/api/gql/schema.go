@@ -0,0 +1,475 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/api/currencies"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/money"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+var (
+	// maxSymbols is the most currencies a field can ask for, like a history request
+	maxSymbols = config.Int("HISTORY_MAX_SYMBOLS", 20)
+)
+
+// symbolPattern matches a currency code
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9]{1,16}$`)
+
+// Errors answered by the resolvers
+var (
+	errUnknownCurrency = problem.New(http.StatusNotFound, "unknown_currency", "Currency is not valid")
+	errNoRates         = problem.New(http.StatusNotFound, "no_rates", "No currencies found")
+)
+
+// rate is a rate as answered, with its date rendered for the client
+type rate struct {
+	Code   string          `json:"code"`
+	Date   string          `json:"date"`
+	Value  decimal.Decimal `json:"value"`
+	Filled bool            `json:"filled"`
+}
+
+func newRate(sample rates.Sample, loc *time.Location, zoned bool) rate {
+	return rate{Code: sample.Code, Date: daterange.FormatIn(sample.Time, loc, zoned), Value: sample.Value, Filled: sample.Filled}
+}
+
+// conversion is an amount converted at the last rates
+type conversion struct {
+	From   string          `json:"from"`
+	To     string          `json:"to"`
+	Amount decimal.Decimal `json:"amount"`
+	Result decimal.Decimal `json:"result"`
+	Rate   decimal.Decimal `json:"rate"`
+	Date   string          `json:"date"`
+}
+
+var decimalType = graphql.NewScalar(graphql.ScalarConfig{
+	Name: "Decimal",
+	Description: "An arbitrary-precision decimal, answered as a JSON number with every digit. " +
+		"Send it as a string to keep every digit.",
+	Serialize: func(value interface{}) interface{} {
+		if value, ok := value.(decimal.Decimal); ok {
+			return value
+		}
+		return nil
+	},
+	ParseValue: parseDecimal,
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch valueAST := valueAST.(type) {
+		case *ast.IntValue:
+			return parseDecimal(valueAST.Value)
+		case *ast.FloatValue:
+			return parseDecimal(valueAST.Value)
+		case *ast.StringValue:
+			return parseDecimal(valueAST.Value)
+		}
+		return nil
+	},
+})
+
+// parseDecimal parses a decimal variable or literal, nil when it is not one
+func parseDecimal(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if parsed, err := decimal.NewFromString(value); err == nil {
+			return parsed
+		}
+	case float64:
+		return decimal.NewFromFloat(value)
+	}
+	return nil
+}
+
+var fillType = graphql.NewEnum(graphql.EnumConfig{
+	Name:        "Fill",
+	Description: "How buckets without rates are filled",
+	Values: graphql.EnumValueConfigMap{
+		"NONE":     {Value: rates.FillNone, Description: "Buckets without rates are left out"},
+		"PREVIOUS": {Value: rates.FillPrevious, Description: "The last known rate is repeated"},
+		"LINEAR":   {Value: rates.FillLinear, Description: "Rates are interpolated between the known rates around the gap"},
+	},
+})
+
+var rateType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "Rate",
+	Description: "The rate of a currency at a time",
+	Fields: graphql.Fields{
+		"code":   {Type: graphql.NewNonNull(graphql.String), Description: "ISO 4217 code of the currency"},
+		"date":   {Type: graphql.NewNonNull(graphql.String), Description: "Time of the rate, with its offset when a tz was given"},
+		"value":  {Type: graphql.NewNonNull(decimalType), Description: "Rate against the base currency"},
+		"filled": {Type: graphql.NewNonNull(graphql.Boolean), Description: "Whether the rate fills a gap of a resampled series"},
+	},
+})
+
+var conversionType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "Conversion",
+	Description: "An amount converted at the last rates",
+	Fields: graphql.Fields{
+		"from":   {Type: graphql.NewNonNull(graphql.String)},
+		"to":     {Type: graphql.NewNonNull(graphql.String)},
+		"amount": {Type: graphql.NewNonNull(decimalType)},
+		"result": {Type: graphql.NewNonNull(decimalType), Description: "The amount in to, rounded to its minor units"},
+		"rate":   {Type: graphql.NewNonNull(decimalType), Description: "Units of to per unit of from"},
+		"date":   {Type: graphql.NewNonNull(graphql.String), Description: "Time of the latest of the two rates"},
+	},
+})
+
+var baseArg = &graphql.ArgumentConfig{Type: graphql.String, Description: "Currency the rates are quoted against, USD by default"}
+
+var currencyType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "Currency",
+	Description: "A currency, identified by its ISO 4217 code",
+	Fields: graphql.Fields{
+		"code":   {Type: graphql.NewNonNull(graphql.String)},
+		"name":   {Type: graphql.NewNonNull(graphql.String)},
+		"symbol": {Type: graphql.NewNonNull(graphql.String)},
+		"minorUnits": {
+			Type:        graphql.NewNonNull(graphql.Int),
+			Description: "Number of decimals amounts are displayed with",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(models.CurrencyMetadata).MinorUnits, nil
+			},
+		},
+		"active": {Type: graphql.NewNonNull(graphql.Boolean)},
+		"latest": {
+			Type:        rateType,
+			Description: "The last rate of the currency, null when it has none",
+			Args:        graphql.FieldConfigArgument{"base": baseArg},
+			Resolve:     resolveLatest,
+		},
+		"history": {
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(rateType))),
+			Description: "The rates of the currency within a date range, oldest first",
+			Args: graphql.FieldConfigArgument{
+				"finit": {Type: graphql.String, Description: "Start date, as RFC 3339, a date and time in tz, a date, or relative like now-7d. Defaults to a week before fend"},
+				"fend":  {Type: graphql.String, Description: "End date, in the same formats as finit. Defaults to now"},
+				"tz":    {Type: graphql.String, Description: "IANA time zone dates are read and rendered in, UTC by default"},
+				"step":  {Type: graphql.String, Description: "Bucket length the series is aligned on, in minutes, hours or days, like 5m, 1h or 1d"},
+				"fill":  {Type: fillType, DefaultValue: rates.FillNone, Description: "How buckets without rates are filled, requires a step"},
+				"base":  baseArg,
+			},
+			Resolve: resolveHistory,
+		},
+	},
+})
+
+var symbolsArg = &graphql.ArgumentConfig{
+	Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+	Description: "ISO 4217 codes of the currencies",
+}
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"currencies": {
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(currencyType))),
+			Description: "The currencies in symbols, or every currency, ordered by code",
+			Args:        graphql.FieldConfigArgument{"symbols": symbolsArg},
+			Resolve:     resolveCurrencies,
+		},
+		"currency": {
+			Type:        currencyType,
+			Description: "The currency with code, null when it is unknown",
+			Args:        graphql.FieldConfigArgument{"code": {Type: graphql.NewNonNull(graphql.String)}},
+			Resolve:     resolveCurrency,
+		},
+		"convert": {
+			Type:        graphql.NewNonNull(conversionType),
+			Description: "Converts an amount between two currencies at their last rates",
+			Args: graphql.FieldConfigArgument{
+				"amount": {Type: graphql.NewNonNull(decimalType)},
+				"from":   {Type: graphql.NewNonNull(graphql.String)},
+				"to":     {Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: resolveConvert,
+		},
+	},
+})
+
+var subscriptionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Subscription",
+	Fields: graphql.Fields{
+		"rates": {
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(rateType))),
+			Description: "The last rates of the currencies in symbols, sent on subscribe and whenever new rates are stored",
+			Args: graphql.FieldConfigArgument{
+				"symbols": {Type: graphql.NewNonNull(symbolsArg.Type), Description: symbolsArg.Description},
+				"base":    baseArg,
+			},
+			Subscribe: subscribeRates,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source, nil
+			},
+		},
+	},
+})
+
+// Schema is the GraphQL schema served at /graphql
+var Schema = mustSchema()
+
+func mustSchema() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Subscription: subscriptionType})
+	if err != nil {
+		panic(fmt.Sprintf("Invalid GraphQL schema: %s", err))
+	}
+
+	return schema
+}
+
+// parseCode reads a currency code argument
+func parseCode(args map[string]interface{}, name string) (string, error) {
+	code, _ := args[name].(string)
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !symbolPattern.MatchString(code) {
+		return "", problem.Invalid(name, "Invalid "+name)
+	}
+
+	return code, nil
+}
+
+// parseBase reads the base argument, Base when it is missing
+func parseBase(args map[string]interface{}) (string, error) {
+	if base, _ := args["base"].(string); base == "" {
+		return rates.Base, nil
+	}
+
+	return parseCode(args, "base")
+}
+
+// parseSymbols reads the symbols argument as sorted unique codes, nil when it is missing
+func parseSymbols(args map[string]interface{}) ([]string, error) {
+	values, ok := args["symbols"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	symbols := []string{}
+	for _, value := range values {
+		symbol, _ := value.(string)
+		symbol = strings.ToUpper(strings.TrimSpace(symbol))
+		if !symbolPattern.MatchString(symbol) {
+			return nil, problem.Invalid("symbols", "Invalid symbols")
+		}
+		if !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+
+	if len(symbols) > maxSymbols {
+		return nil, problem.Invalid("symbols", fmt.Sprintf("At most %d symbols can be requested", maxSymbols))
+	}
+
+	return symbols, nil
+}
+
+// parseSeries reads the date range and resampling arguments of a history field, validated like the /history query
+// params
+func parseSeries(args map[string]interface{}) (series, error) {
+	base, err := parseBase(args)
+	if err != nil {
+		return series{}, err
+	}
+
+	finit, _ := args["finit"].(string)
+	fend, _ := args["fend"].(string)
+	tz, _ := args["tz"].(string)
+	dateRange, err := daterange.Parse(finit, fend, tz, time.Now().Truncate(time.Second))
+	if err != nil {
+		return series{}, err
+	}
+
+	step, _ := args["step"].(string)
+	fill, _ := args["fill"].(rates.Fill)
+	resampling, err := rates.ParseResampling(step, string(fill), dateRange)
+	if err != nil {
+		return series{}, err
+	}
+
+	return series{Base: base, Range: dateRange, Resampling: resampling}, nil
+}
+
+func resolveCurrencies(p graphql.ResolveParams) (interface{}, error) {
+	symbols, err := parseSymbols(p.Args)
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+
+	metadata, err := currencies.Metadata(p.Context)
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+	if symbols == nil {
+		return metadata, nil
+	}
+
+	return slices.DeleteFunc(slices.Clone(metadata), func(currency models.CurrencyMetadata) bool {
+		return !slices.Contains(symbols, currency.Code)
+	}), nil
+}
+
+func resolveCurrency(p graphql.ResolveParams) (interface{}, error) {
+	code, err := parseCode(p.Args, "code")
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+
+	metadata := loadersFrom(p.Context).metadata.load(p.Context, code)
+	return func() (interface{}, error) {
+		currency, found, err := metadata()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		if !found {
+			return nil, nil
+		}
+
+		return currency, nil
+	}, nil
+}
+
+// loadLatest returns a func waiting for the last rate of code. Rates are quoted against Base, which is taken as 1
+// when it is not stored.
+func loadLatest(ctx context.Context, code string) func() (rates.Sample, bool, error) {
+	latest := loadersFrom(ctx).latest.load(ctx, code)
+
+	return func() (rates.Sample, bool, error) {
+		sample, found, err := latest()
+		if err == nil && !found && code == rates.Base {
+			return rates.Sample{Code: code, Value: decimal.NewFromInt(1)}, true, nil
+		}
+
+		return sample, found, err
+	}
+}
+
+func resolveLatest(p graphql.ResolveParams) (interface{}, error) {
+	currency := p.Source.(models.CurrencyMetadata)
+	base, err := parseBase(p.Args)
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+
+	latest := loadLatest(p.Context, currency.Code)
+	baseLatest := loadLatest(p.Context, base)
+
+	return func() (interface{}, error) {
+		sample, found, err := latest()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		baseSample, baseFound, err := baseLatest()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		if !found || !baseFound || baseSample.Value.IsZero() {
+			return nil, nil
+		}
+
+		if sample.Value, err = money.CrossRate(baseSample.Value, sample.Value); err != nil {
+			return nil, fail(p.Context, err)
+		}
+		return newRate(sample, time.UTC, false), nil
+	}, nil
+}
+
+func resolveHistory(p graphql.ResolveParams) (interface{}, error) {
+	currency := p.Source.(models.CurrencyMetadata)
+	series, err := parseSeries(p.Args)
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+
+	history := loadersFrom(p.Context).history.load(p.Context, seriesKey{Code: currency.Code, Series: series})
+	return func() (interface{}, error) {
+		samples, _, err := history()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+
+		history := make([]rate, len(samples))
+		for i, sample := range samples {
+			history[i] = newRate(sample, series.Range.Location, series.Range.Zoned)
+		}
+
+		return history, nil
+	}, nil
+}
+
+func resolveConvert(p graphql.ResolveParams) (interface{}, error) {
+	amount, _ := p.Args["amount"].(decimal.Decimal)
+	from, err := parseCode(p.Args, "from")
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+	to, err := parseCode(p.Args, "to")
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+
+	target := loadersFrom(p.Context).metadata.load(p.Context, to)
+	fromLatest := loadLatest(p.Context, from)
+	toLatest := loadLatest(p.Context, to)
+
+	return func() (interface{}, error) {
+		currency, found, err := target()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		if !found {
+			return nil, fail(p.Context, errUnknownCurrency)
+		}
+
+		fromRate, fromFound, err := fromLatest()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		toRate, toFound, err := toLatest()
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		if !fromFound || !toFound {
+			return nil, fail(p.Context, errNoRates)
+		}
+
+		result, err := money.Convert(amount, fromRate.Value, toRate.Value, int32(currency.MinorUnits))
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+		rate, err := money.CrossRate(fromRate.Value, toRate.Value)
+		if err != nil {
+			return nil, fail(p.Context, err)
+		}
+
+		at := fromRate.Time
+		if toRate.Time.After(at) {
+			at = toRate.Time
+		}
+
+		return conversion{
+			From:   from,
+			To:     to,
+			Amount: amount,
+			Result: result,
+			Rate:   rate,
+			Date:   daterange.FormatIn(at, time.UTC, false),
+		}, nil
+	}, nil
+}
@@ -0,0 +1,191 @@
+package gql
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/money"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+)
+
+// subscribeRates sends the last rates of the symbols on subscribe, then again whenever the daemon stores new ones,
+// until the request is done. Rates are only sent when one of the symbols or the base changed since the last ones sent.
+func subscribeRates(p graphql.ResolveParams) (interface{}, error) {
+	symbols, err := parseSymbols(p.Args)
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+	if len(symbols) == 0 {
+		return nil, fail(p.Context, problem.Invalid("symbols", "Invalid symbols"))
+	}
+	base, err := parseBase(p.Args)
+	if err != nil {
+		return nil, fail(p.Context, err)
+	}
+
+	snapshots, unsubscribe := latestFeed.subscribe()
+	events := make(chan interface{})
+
+	go func() {
+		defer close(events)
+		defer unsubscribe()
+
+		var sent time.Time
+		for {
+			select {
+			case latest := <-snapshots:
+				quoted, updatedAt := latest.quote(symbols, base)
+				if updatedAt.Equal(sent) {
+					continue
+				}
+
+				select {
+				case events <- quoted:
+					sent = updatedAt
+				case <-p.Context.Done():
+					return
+				}
+			case <-p.Context.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// snapshot is the last rate of every currency
+type snapshot map[string]rates.Sample
+
+// quote returns the rates of the currencies in symbols against base, along with the time of the newest of them or of
+// the rate of base
+func (s snapshot) quote(symbols []string, base string) ([]rate, time.Time) {
+	baseSample, found := s[base]
+	if !found && base == rates.Base {
+		baseSample, found = rates.Sample{Code: base, Value: decimal.NewFromInt(1)}, true
+	}
+
+	updatedAt := baseSample.Time
+	quoted := []rate{}
+	for _, code := range symbols {
+		sample, ok := s[code]
+		if !ok {
+			continue
+		}
+		if sample.Time.After(updatedAt) {
+			updatedAt = sample.Time
+		}
+		if !found {
+			continue
+		}
+		if value, err := money.CrossRate(baseSample.Value, sample.Value); err == nil {
+			sample.Value = value
+			quoted = append(quoted, newRate(sample, time.UTC, false))
+		}
+	}
+
+	return quoted, updatedAt
+}
+
+// feed reads the latest rates once whenever the daemon invalidates them, and hands them to every subscription of this
+// replica, so the database sees one query per invalidation however many clients subscribed.
+// Rates are read from the database rather than the cache, which keeps serving the previous version right after an
+// invalidation.
+type feed struct {
+	mu          sync.Mutex
+	subscribers map[chan snapshot]struct{}
+	// current is the last snapshot read, handed to new subscriptions
+	current snapshot
+	// stop stops reading, nil while there are no subscriptions
+	stop context.CancelFunc
+}
+
+var latestFeed = &feed{subscribers: make(map[chan snapshot]struct{})}
+
+// subscribe returns a channel receiving the latest rates now and whenever they change, and a func to unsubscribe.
+// A subscription that falls behind only gets the newest rates.
+func (f *feed) subscribe() (<-chan snapshot, func()) {
+	snapshots := make(chan snapshot, 1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subscribers[snapshots] = struct{}{}
+	if f.current != nil {
+		snapshots <- f.current
+	}
+	if f.stop == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		f.stop = cancel
+		go f.run(ctx)
+	}
+
+	return snapshots, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		delete(f.subscribers, snapshots)
+		if len(f.subscribers) == 0 && f.stop != nil {
+			f.stop()
+			f.stop = nil
+			f.current = nil
+		}
+	}
+}
+
+// run reads the latest rates, then again on every invalidation, until ctx is done
+func (f *feed) run(ctx context.Context) {
+	// Watch before the first read, so rates stored in between are not missed
+	updates, stop := cache.Latest.Watch()
+	defer stop()
+
+	for {
+		f.read(ctx)
+
+		select {
+		case <-updates:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (f *feed) read(ctx context.Context) {
+	latest, err := rates.Latest(ctx, database.DB, nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Error reading the latest rates for subscriptions: %s\n", err)
+		}
+		return
+	}
+
+	current := make(snapshot, len(latest))
+	for _, sample := range latest {
+		current[sample.Code] = sample
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The feed may have been stopped, and started again, while reading
+	if ctx.Err() != nil {
+		return
+	}
+
+	f.current = current
+	for snapshots := range f.subscribers {
+		// Replace the snapshot a subscription did not read yet
+		select {
+		case <-snapshots:
+		default:
+		}
+		snapshots <- current
+	}
+}
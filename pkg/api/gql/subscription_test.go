@@ -0,0 +1,45 @@
+package gql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestFeed_SharesLatestRates(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(allLatestQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", at, "0.92").
+			AddRow("MXN", at, "17.05"))
+
+	// When
+	first, unsubscribeFirst := latestFeed.subscribe()
+	defer unsubscribeFirst()
+	second, unsubscribeSecond := latestFeed.subscribe()
+	defer unsubscribeSecond()
+
+	// Then both subscriptions get the rates of a single read
+	for _, snapshots := range []<-chan snapshot{first, second} {
+		select {
+		case latest := <-snapshots:
+			quoted, updatedAt := latest.quote([]string{"MXN"}, "EUR")
+			require.Len(t, quoted, 1)
+			require.Equal(t, "18.5326", quoted[0].Value.StringFixed(4))
+			require.True(t, at.Equal(updatedAt))
+		case <-time.After(time.Second):
+			t.Fatal("no rates received")
+		}
+	}
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
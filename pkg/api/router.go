@@ -1,40 +1,88 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/wjoseperez20/boletia-currency-api/docs"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/api/currencies"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/api/gql"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/api/healtcheck"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/api/sso"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/api/users"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/middleware"
-	"time"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
 
 	"github.com/gin-gonic/gin"
 	swaggerfiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
-	"golang.org/x/time/rate"
 )
 
 func InitRouter() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.HandleMethodNotAllowed = true
 
+	// The request ID comes first, so every log line and error of the request carries it
+	r.Use(middleware.RequestID())
 	r.Use(gin.Logger())
+	r.Use(middleware.Recovery())
 	if gin.Mode() == gin.ReleaseMode {
 		r.Use(middleware.Security())
 		r.Use(middleware.Xss())
 	}
 	r.Use(middleware.Cors())
-	r.Use(middleware.RateLimiter(rate.Every(1*time.Minute), 60)) // 60 requests per minute
+	r.Use(middleware.RateLimiter(middleware.DefaultRateLimitPolicy())) // 60 requests per minute by default
+
+	r.NoRoute(func(c *gin.Context) {
+		problem.Write(c, problem.New(http.StatusNotFound, problem.CodeNotFound, "Not Found"))
+	})
+	r.NoMethod(func(c *gin.Context) {
+		problem.Write(c, problem.New(http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Method Not Allowed"))
+	})
 
 	// api routes
 	docs.SwaggerInfo.BasePath = "/api/v1"
 	v1 := r.Group("/api/v1")
 	{
 		v1.GET("/_", healtcheck.Healthcheck)
-		v1.POST("/login", middleware.APIKeyAuth(), users.LoginUser)
+		v1.POST("/login", middleware.RateLimiter(middleware.LoginRateLimitPolicy()), middleware.APIKeyAuth(), users.LoginUser)
+		v1.POST("/login/2fa", middleware.RateLimiter(middleware.LoginRateLimitPolicy()), middleware.APIKeyAuth(), users.LoginTwoFactor)
+		v1.GET("/oidc/login", sso.Login)
+		v1.GET("/oidc/callback", middleware.RateLimiter(middleware.LoginRateLimitPolicy()), sso.Callback)
 		v1.POST("/register", middleware.APIKeyAuth(), users.RegisterUser)
 
+		// Current user
+		me := v1.Group("/me", middleware.JWTAuth())
+		me.GET("", users.GetMe)
+		me.DELETE("", users.DeleteMe)
+		me.PUT("/password", users.ChangePassword)
+		me.POST("/2fa", users.EnrollTwoFactor)
+		me.POST("/2fa/confirm", users.ConfirmTwoFactor)
+		me.DELETE("/2fa", users.DisableTwoFactor)
+
+		// Admin
+		admin := v1.Group("/admin", middleware.JWTAuth(), middleware.RequireRole(models.RoleAdmin))
+		admin.DELETE("/lockouts/:username", users.UnlockUser)
+
 		// Currencies
+		v1.GET("/currencies", middleware.JWTAuth(), currencies.ListCurrencies)
+		v1.GET("/currencies/meta", middleware.JWTAuth(), currencies.GetCurrencyMetadata)
+		v1.GET("/currencies/all", middleware.Deprecated(currencies.LegacyDeprecatedAt, currencies.LegacySunset, "/api/v1/currencies"),
+			middleware.JWTAuth(), currencies.ListCurrenciesLegacy)
 		v1.GET("/currencies/:name", middleware.JWTAuth(), currencies.HandleCurrencyRequest)
+		v1.GET("/currencies/:name/stats", middleware.JWTAuth(), currencies.GetCurrencyStats)
+		v1.GET("/history", middleware.JWTAuth(), currencies.GetHistory)
+		v1.GET("/analytics/correlation", middleware.JWTAuth(), currencies.GetCorrelation)
+
+		// Exports
+		exports := v1.Group("/exports", middleware.JWTAuth())
+		exports.POST("", currencies.CreateExport)
+		exports.GET("/:id", currencies.GetExport)
+		exports.GET("/:id/download", currencies.DownloadExport)
+
+		// GraphQL
+		v1.GET("/graphql", middleware.JWTAuth(), gql.Handle)
+		v1.POST("/graphql", middleware.JWTAuth(), gql.Handle)
 	}
 
 	// Swagger
@@ -0,0 +1,153 @@
+package sso
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/audit"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"gorm.io/gorm"
+)
+
+// errNotConfigured is answered when no identity provider is configured
+var errNotConfigured = problem.New(http.StatusNotFound, "sso_not_configured", "Single sign-on is not configured")
+
+// @BasePath /api/v1
+
+// Login godoc
+// @Summary Start a single sign-on login
+// @Schemes
+// @Description Redirects to the OIDC identity provider using the authorization code flow with PKCE
+// @Tags User
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 404 {string} string "Single sign-on is not configured"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /oidc/login [get]
+func Login(c *gin.Context) {
+	if auth.OIDC == nil {
+		problem.Write(c, errNotConfigured)
+		return
+	}
+
+	redirectURL, err := auth.OIDC.AuthCodeURL(c.Request.Context())
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// Callback godoc
+// @Summary Complete a single sign-on login
+// @Schemes
+// @Description Handles the identity provider redirect, provisioning the user on first login, and returns a JWT token,
+// @Description or a challenge token when 2FA is enabled
+// @Tags User
+// @Produce  json
+// @Param   code  query string true "Authorization code"
+// @Param   state query string true "Login state"
+// @Success 200 {string} string "JWT Token, or a challenge token when 2FA is enabled"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Single sign-on is not configured"
+// @Failure 409 {string} string "Username already taken"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /oidc/callback [get]
+func Callback(c *gin.Context) {
+	if auth.OIDC == nil {
+		problem.Write(c, errNotConfigured)
+		return
+	}
+
+	// The provider error is logged rather than answered, so the redirect can't be used to show arbitrary text
+	if providerError := c.Query("error"); providerError != "" {
+		log.Printf("OIDC login failed at the identity provider: %q\n", providerError)
+		problem.Write(c, problem.New(http.StatusUnauthorized, "login_failed", "Login failed"))
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		problem.Write(c, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "state and code are required"))
+		return
+	}
+
+	identity, err := auth.OIDC.Exchange(c.Request.Context(), state, code)
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCState) {
+			problem.Write(c, problem.New(http.StatusBadRequest, "invalid_login_state", "Invalid or expired login state"))
+			return
+		}
+		log.Printf("Error completing OIDC login: %s\n", err)
+		problem.Write(c, problem.New(http.StatusUnauthorized, "login_failed", "Login failed"))
+		return
+	}
+
+	user, err := provisionUser(c, identity)
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			problem.Write(c, problem.New(http.StatusConflict, "username_taken", "Username already taken"))
+			return
+		}
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	// Accounts with 2FA get a challenge to exchange for a token at /login/2fa, like after a password login
+	if user.TOTPEnabled {
+		challenge, err := auth.GenerateChallengeToken(user)
+		if err != nil {
+			problem.Write(c, problem.Internal(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge_token": challenge})
+		return
+	}
+
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+// provisionUser finds the user signed in as identity, creating it on first login.
+// The role is synced from the IdP groups on every login.
+func provisionUser(c *gin.Context, identity *auth.OIDCIdentity) (models.User, error) {
+	var user models.User
+	role := auth.OIDC.RoleFor(identity.Groups)
+
+	err := database.DB.Where("oidc_subject = ?", identity.Subject).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = models.User{Username: identity.Username, Role: role, OIDCSubject: &identity.Subject}
+		if err := database.DB.Create(&user).Error; err != nil {
+			return user, err
+		}
+
+		audit.Record(audit.EventUserProvision, user.Username, c.ClientIP(), "provisioned through single sign-on as "+role)
+		return user, nil
+	}
+	if err != nil {
+		return user, err
+	}
+
+	// A new role revokes the tokens issued with the old one
+	if user.Role != role {
+		user.Role = role
+		user.TokenVersion++
+		if err := database.DB.Model(&user).Updates(map[string]interface{}{"role": role, "token_version": user.TokenVersion}).Error; err != nil {
+			return user, err
+		}
+	}
+
+	return user, nil
+}
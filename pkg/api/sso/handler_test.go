@@ -0,0 +1,369 @@
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"gorm.io/gorm"
+)
+
+// mockOIDCServer is a minimal OIDC provider issuing ID tokens for a single user.
+type mockOIDCServer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// authorizations maps issued codes to the nonce and PKCE challenge of their request
+	authorizations map[string]url.Values
+
+	Subject  string
+	Username string
+	Groups   []string
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	server := &mockOIDCServer{
+		key:            key,
+		authorizations: map[string]url.Values{},
+		Subject:        "subject-1",
+		Username:       "jane",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", server.discovery)
+	mux.HandleFunc("/keys", server.keys)
+	mux.HandleFunc("/token", server.token)
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *mockOIDCServer) discovery(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (s *mockOIDCServer) keys(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// Authorize plays the user signing in at the provider, returning the callback query.
+func (s *mockOIDCServer) Authorize(t *testing.T, authURL string) url.Values {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+
+	query := parsed.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code := "code-" + query.Get("state")
+	s.authorizations[code] = query
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func (s *mockOIDCServer) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	authorization, found := s.authorizations[r.FormValue("code")]
+	delete(s.authorizations, r.FormValue("code"))
+	s.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !found || base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.Get("code_challenge") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                s.URL,
+		"sub":                s.Subject,
+		"aud":                authorization.Get("client_id"),
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              authorization.Get("nonce"),
+		"preferred_username": s.Username,
+		"groups":             s.Groups,
+	})
+	idToken.Header["kid"] = "test"
+	signed, _ := idToken.SignedString(s.key)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func setupOIDC(t *testing.T) *mockOIDCServer {
+	server := newMockOIDCServer(t)
+
+	t.Setenv("OIDC_CLIENT_ID", "currency-api")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	t.Setenv("OIDC_ROLE_MAPPING", "currency-admins=admin")
+
+	provider, err := auth.NewOIDCProvider(context.Background(), server.URL)
+	require.NoError(t, err)
+
+	auth.OIDC = provider
+	t.Cleanup(func() { auth.OIDC = nil })
+
+	return server
+}
+
+func newSSORouter() *gin.Engine {
+	r := gin.Default()
+	r.GET("/oidc/login", Login)
+	r.GET("/oidc/callback", Callback)
+	return r
+}
+
+// startLogin follows /oidc/login through the mock provider and returns the callback query.
+func startLogin(t *testing.T, r *gin.Engine, server *mockOIDCServer) url.Values {
+	w := helper.PerformRequest(r, "GET", "/oidc/login", nil)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	return server.Authorize(t, w.Header().Get("Location"))
+}
+
+func TestLogin_NotConfigured(t *testing.T) {
+	// Given
+	r := newSSORouter()
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/oidc/login", nil)
+
+	// Then
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCallback_ProvisionsUser(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	server := setupOIDC(t)
+	server.Groups = []string{"staff", "currency-admins"}
+	r := newSSORouter()
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE oidc_subject = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("subject-1", 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "user" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	dbMock.ExpectCommit()
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectCommit()
+
+	// When
+	callback := startLogin(t, r, server)
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	claims, err := auth.ParseToken(response["token"])
+	require.NoError(t, err)
+	require.Equal(t, "jane", claims.Username)
+	require.Equal(t, models.RoleAdmin, claims.Role)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestCallback_ExistingUser(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	server := setupOIDC(t)
+	r := newSSORouter()
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE oidc_subject = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("subject-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "oidc_subject"}).
+			AddRow(7, "jane", models.RoleUser, "subject-1"))
+
+	// When
+	callback := startLogin(t, r, server)
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	claims, err := auth.ParseToken(response["token"])
+	require.NoError(t, err)
+	require.Equal(t, models.RoleUser, claims.Role)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestCallback_RoleChanged(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	server := setupOIDC(t)
+	server.Groups = []string{"currency-admins"}
+	r := newSSORouter()
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE oidc_subject = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("subject-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "oidc_subject", "token_version"}).
+			AddRow(7, "jane", models.RoleUser, "subject-1", 3))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "user" SET "role"=\$1,"token_version"=\$2,"updated_at"=\$3 WHERE "id" = \$4`).
+		WithArgs(models.RoleAdmin, 4, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	callback := startLogin(t, r, server)
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then the tokens issued with the old role are revoked
+	claims, err := auth.ParseToken(response["token"])
+	require.NoError(t, err)
+	require.Equal(t, models.RoleAdmin, claims.Role)
+	require.Equal(t, 4, claims.TokenVersion)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestCallback_ReusedState(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	server := setupOIDC(t)
+	r := newSSORouter()
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE oidc_subject = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "oidc_subject"}).
+			AddRow(7, "jane", models.RoleUser, "subject-1"))
+
+	callback := startLogin(t, r, server)
+	helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCallback_InvalidCode(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	server := setupOIDC(t)
+	r := newSSORouter()
+
+	callback := startLogin(t, r, server)
+	callback.Set("code", "forged")
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+
+	// Then
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCallback_TwoFactorEnabled(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	server := setupOIDC(t)
+	r := newSSORouter()
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE oidc_subject = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("subject-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "oidc_subject", "totp_enabled"}).
+			AddRow(7, "jane", models.RoleUser, "subject-1", true))
+
+	// When
+	callback := startLogin(t, r, server)
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?"+callback.Encode(), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then only a challenge is issued, to exchange at /login/2fa
+	require.Equal(t, true, response["two_factor_required"])
+	require.NotContains(t, response, "token")
+	_, err := auth.ParseChallengeToken(response["challenge_token"].(string))
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestCallback_ProviderError(t *testing.T) {
+	// Given
+	setupOIDC(t)
+	r := newSSORouter()
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/oidc/callback?error=<script>alert(1)</script>", nil)
+
+	// Then the provider value is not echoed back
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "Login failed", helper.DecodeProblem(t, w).Detail)
+}
@@ -2,15 +2,36 @@ package users
 
 import (
 	"errors"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/audit"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// Errors answered by the user endpoints
+var (
+	errInvalidCredentials = problem.New(http.StatusUnauthorized, "invalid_credentials", "Invalid username or password")
+	errLoginLocked        = problem.New(http.StatusTooManyRequests, "login_locked", "Too many failed login attempts, try again later")
+	errUsernameTaken      = problem.New(http.StatusConflict, "username_taken", "Username already taken")
+	errUserNotLocked      = problem.New(http.StatusNotFound, "user_not_locked", "User is not locked")
+	errUserNotFound       = problem.New(http.StatusNotFound, "user_not_found", "User not found")
+	errWrongPassword      = problem.New(http.StatusUnauthorized, "wrong_password", "Current password is incorrect")
+	errTwoFactorEnabled   = problem.New(http.StatusConflict, "two_factor_enabled", "2FA is already enabled")
+	errTwoFactorDisabled  = problem.New(http.StatusBadRequest, "two_factor_disabled", "2FA is not enabled")
+	errTwoFactorPending   = problem.New(http.StatusBadRequest, "two_factor_not_started", "2FA enrollment has not been started")
+	errInvalidCode        = problem.New(http.StatusUnauthorized, "invalid_code", "Invalid code")
+	errInvalidChallenge   = problem.New(http.StatusUnauthorized, "invalid_challenge_token", "Invalid challenge token")
+)
+
 // @BasePath /api/v1
 
 // LoginUser godoc
@@ -22,9 +43,10 @@ import (
 // @Accept  json
 // @Produce  json
 // @Param   user     body    models.LoginUser     true        "User login object"
-// @Success 200 {string} string "JWT Token"
+// @Success 200 {string} string "JWT Token, or a challenge token when 2FA is enabled"
 // @Failure 400 {string} string "Bad Request"
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 429 {string} string "Too Many Requests"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /login [post]
 func LoginUser(c *gin.Context) {
@@ -32,7 +54,13 @@ func LoginUser(c *gin.Context) {
 
 	// Get JSON body
 	if err := c.ShouldBindJSON(&incomingUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	// Reject attempts while the username or IP is delayed or locked out
+	ip := c.ClientIP()
+	if loginBlocked(c, incomingUser.Username, ip) {
 		return
 	}
 
@@ -40,9 +68,11 @@ func LoginUser(c *gin.Context) {
 	// Fetch the user from the database
 	if err := database.DB.Where("username = ?", incomingUser.Username).First(&dbUser).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
+			// Spend the same time as a password check so unknown usernames can't be told apart
+			_ = auth.ComparePassword(auth.DummyHash(), incomingUser.Password)
+			loginFailed(c, incomingUser.Username, ip)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			problem.Write(c, problem.Internal(err))
 		}
 		return
 	}
@@ -50,20 +80,103 @@ func LoginUser(c *gin.Context) {
 	// Verify password
 	err := auth.ComparePassword(dbUser.Password, incomingUser.Password)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		loginFailed(c, incomingUser.Username, ip)
 		return
 	}
 
+	// Upgrade the stored hash when the bcrypt cost configuration changed
+	if auth.NeedsRehash(dbUser.Password) {
+		rehashPassword(dbUser, incomingUser.Password)
+	}
+
+	// Accounts with 2FA get a challenge to exchange for a token at /login/2fa
+	if dbUser.TOTPEnabled {
+		challenge, err := auth.GenerateChallengeToken(dbUser)
+		if err != nil {
+			problem.Write(c, problem.Internal(err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge_token": challenge})
+		return
+	}
+
+	loginSucceeded(c, dbUser)
+}
+
+// loginSucceeded clears the failed login count of user and responds with a new JWT token.
+func loginSucceeded(c *gin.Context, user models.User) {
+	resetLoginFailures(user.Username)
+
 	// Generate JWT token
-	token, err := auth.GenerateToken(dbUser.Username)
+	token, err := auth.GenerateToken(user)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		problem.Write(c, problem.Internal(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"token": token})
 }
 
+// rehashPassword stores a new hash of password made with the configured bcrypt cost.
+func rehashPassword(user models.User, password string) {
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error rehashing password: %s\n", err)
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		log.Printf("Error saving rehashed password: %s\n", err)
+	}
+}
+
+// loginBlocked rejects the request while username or ip is delayed or locked out.
+func loginBlocked(c *gin.Context, username, ip string) bool {
+	wait, err := auth.LoginBlocked(username, ip)
+	if err != nil {
+		log.Printf("Error checking login lockout: %s\n", err)
+		return false
+	}
+
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		problem.Write(c, errLoginLocked)
+		return true
+	}
+
+	return false
+}
+
+// loginFailed records a failed login, audits any lockout it causes and rejects the request.
+func loginFailed(c *gin.Context, username, ip string) {
+	recordLoginFailure(username, ip)
+	problem.Write(c, errInvalidCredentials)
+}
+
+// resetLoginFailures clears the failed login count of username once it proved who it is.
+func resetLoginFailures(username string) {
+	if err := auth.ResetLoginFailures(username); err != nil {
+		log.Printf("Error resetting login failures: %s\n", err)
+	}
+}
+
+// recordLoginFailure counts a failed password check towards the lockout of username and ip, and audits any lockout
+// it causes.
+func recordLoginFailure(username, ip string) {
+	lockout, err := auth.RecordLoginFailure(auth.DefaultLoginPolicy(), username, ip)
+	if err != nil {
+		log.Printf("Error recording login failure: %s\n", err)
+	}
+
+	if lockout.Username {
+		audit.Record(audit.EventLoginLockout, username, ip, "username locked out after repeated failed logins")
+	}
+	if lockout.IP {
+		audit.Record(audit.EventLoginLockout, username, ip, "IP locked out after repeated failed logins")
+	}
+}
+
 // RegisterUser godoc
 // @Summary Register a new user
 // @Schemes http
@@ -75,31 +188,225 @@ func LoginUser(c *gin.Context) {
 // @Param   user     body    models.LoginUser     true        "User registration object"
 // @Success 200 {string} string	"Successfully registered"
 // @Failure 400 {string} string "Bad Request"
+// @Failure 409 {string} string "Username already taken"
 // @Failure 500 {string} string "Internal Server Error"
 // @Router /register [post]
 func RegisterUser(c *gin.Context) {
 	var internalUser models.LoginUser
 
 	if err := c.ShouldBindJSON(&internalUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	// Enforce the password policy
+	if err := auth.ValidatePassword(internalUser.Username, internalUser.Password); err != nil {
+		problem.Write(c, problem.Invalid("password", err.Error()))
 		return
 	}
 
 	// Hash the password
 	hashedPassword, err := auth.HashPassword(internalUser.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not hash password"})
+		problem.Write(c, problem.Internal(err))
 		return
 	}
 
 	// Create new user
-	newUser := models.User{Username: internalUser.Username, Password: hashedPassword}
+	newUser := models.User{Username: internalUser.Username, Password: hashedPassword, Role: models.RoleUser}
 
 	// Save the user to the database
 	if err := database.DB.Create(&newUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save user to database"})
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			problem.Write(c, errUsernameTaken)
+			return
+		}
+		problem.Write(c, problem.Internal(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Registration successful"})
 }
+
+// UnlockUser godoc
+// @Summary Unlock a user
+// @Schemes
+// @Description Lifts the login lockout of a user and, optionally, of an IP address. Requires the admin role
+// @Tags User
+// @Security JwtAuth
+// @Produce  json
+// @Param   username path  string true  "Username"
+// @Param   ip       query string false "IP address to unlock as well"
+// @Success 200 {string} string "User unlocked"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User is not locked"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /admin/lockouts/{username} [delete]
+func UnlockUser(c *gin.Context) {
+	username := c.Param("username")
+	ip := c.Query("ip")
+
+	unlocked, err := auth.UnlockLogin(username, ip)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	if !unlocked {
+		problem.Write(c, errUserNotLocked)
+		return
+	}
+
+	audit.Record(audit.EventLoginUnlock, username, ip, "unlocked by "+c.GetString("username"))
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
+
+// GetMe godoc
+// @Summary Get the current user
+// @Schemes
+// @Description Returns the profile of the authenticated user
+// @Tags User
+// @Security JwtAuth
+// @Produce  json
+// @Success 200 {object} models.UserProfile
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /me [get]
+func GetMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, user.Profile())
+}
+
+// ChangePassword godoc
+// @Summary Change the current user's password
+// @Schemes
+// @Description Replaces the password of the authenticated user after verifying the current one, throttled like logins.
+// @Description Every token issued before is revoked, and a new one is returned
+// @Tags User
+// @Security JwtAuth
+// @Accept  json
+// @Produce  json
+// @Param   passwords body    models.ChangePassword true "Current and new password"
+// @Success 200 {string} string "Password changed, with a new JWT token"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 429 {string} string "Too Many Requests"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /me/password [put]
+func ChangePassword(c *gin.Context) {
+	var passwords models.ChangePassword
+
+	if err := c.ShouldBindJSON(&passwords); err != nil {
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	// A stolen token must not allow guessing the password faster than logins do
+	ip := c.ClientIP()
+	if loginBlocked(c, user.Username, ip) {
+		return
+	}
+
+	if err := auth.ComparePassword(user.Password, passwords.CurrentPassword); err != nil {
+		recordLoginFailure(user.Username, ip)
+		problem.Write(c, errWrongPassword)
+		return
+	}
+
+	if err := auth.ValidatePassword(user.Username, passwords.NewPassword); err != nil {
+		problem.Write(c, problem.Invalid("new_password", err.Error()))
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(passwords.NewPassword)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	// Bumping the token version revokes every token issued with the old password
+	user.TokenVersion++
+	update := map[string]interface{}{"password": hashedPassword, "token_version": user.TokenVersion}
+	if err := database.DB.Model(&user).Updates(update).Error; err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	resetLoginFailures(user.Username)
+
+	audit.Record(audit.EventPasswordChange, user.Username, ip, "password changed by the user")
+
+	token, err := auth.GenerateToken(user)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "token": token})
+}
+
+// DeleteMe godoc
+// @Summary Delete the current user
+// @Schemes
+// @Description Deletes the account of the authenticated user, along with their exports
+// @Tags User
+// @Security JwtAuth
+// @Produce  json
+// @Success 200 {string} string "Account deleted"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /me [delete]
+func DeleteMe(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		// Exports hold the rates the user asked for, so they go with the account
+		if err := export.DeleteOwnedBy(c.Request.Context(), tx, export.Store, user.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	audit.Record(audit.EventAccountDelete, user.Username, c.ClientIP(), "account deleted by the user")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted"})
+}
+
+// currentUser loads the user authenticated by JWTAuth, writing an error response when it can't.
+func currentUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	if err := database.DB.Where("username = ?", c.GetString("username")).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			problem.Write(c, errUserNotFound)
+		} else {
+			problem.Write(c, problem.Internal(err))
+		}
+		return user, false
+	}
+
+	return user, true
+}
@@ -1,19 +1,25 @@
 package users
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"gorm.io/gorm"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/export"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/storage"
 )
 
 func TestLoginUser_BadRequest(t *testing.T) {
@@ -42,6 +48,7 @@ func TestLoginUser_Unauthorized(t *testing.T) {
 		Password: "test",
 	}
 
+	helper.SetupTestCache(t)
 	dbMock, gormDB := helper.SetupTestDatabase(t)
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
@@ -71,6 +78,7 @@ func TestLoginUser_InternalServerError(t *testing.T) {
 		Password: "Test",
 	}
 
+	helper.SetupTestCache(t)
 	dbMock, gormDB := helper.SetupTestDatabase(t)
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
@@ -103,6 +111,7 @@ func TestLoginUser_Success(t *testing.T) {
 		Password: "test",
 	}
 
+	helper.SetupTestCache(t)
 	dbMock, gormDB := helper.SetupTestDatabase(t)
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
@@ -149,21 +158,23 @@ func TestRegisterUser_BadRequest(t *testing.T) {
 
 func TestRegisterUser_InternalServerError(t *testing.T) {
 	// Given
+	t.Setenv("BCRYPT_COST", "4")
 	r := gin.Default()
 	r.POST("/register", RegisterUser)
 
 	incomingUser := models.User{
 		Username: "test",
-		Password: "test",
+		Password: "correct-horse-battery",
 	}
 
 	dbMock, gormDB := helper.SetupTestDatabase(t)
 	defer dbMock.ExpectClose()
 	database.DB = gormDB
 
-	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
-		WithArgs("test", 1).
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "user" (.+) RETURNING "id"`).
 		WillReturnError(errors.New("internal error"))
+	dbMock.ExpectRollback()
 
 	// When
 	w := helper.PerformRequest(r, "POST", "/register", helper.ToJSON(incomingUser))
@@ -175,3 +186,322 @@ func TestRegisterUser_InternalServerError(t *testing.T) {
 	// Then
 	require.NotNil(t, response)
 }
+
+func TestRegisterUser_WeakPassword(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/register", RegisterUser)
+
+	incomingUser := models.User{
+		Username: "test",
+		Password: "short",
+	}
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/register", helper.ToJSON(incomingUser))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	require.Equal(t, "Password must be at least 8 characters long", response["detail"])
+}
+
+func TestRegisterUser_Conflict(t *testing.T) {
+	// Given
+	t.Setenv("BCRYPT_COST", "4")
+	r := gin.Default()
+	r.POST("/register", RegisterUser)
+
+	incomingUser := models.User{
+		Username: "test",
+		Password: "correct-horse-battery",
+	}
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "user" (.+) RETURNING "id"`).
+		WillReturnError(&pgconn.PgError{Code: "23505"})
+	dbMock.ExpectRollback()
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/register", helper.ToJSON(incomingUser))
+	require.Equal(t, http.StatusConflict, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	require.Equal(t, "Username already taken", response["detail"])
+}
+
+func TestLoginUser_LockedOut(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/login", LoginUser)
+
+	incomingUser := models.User{
+		Username: "test",
+		Password: "test",
+	}
+
+	server := helper.SetupTestCache(t)
+	require.NoError(t, server.Set("login:lock:user:test", "5"))
+	server.SetTTL("login:lock:user:test", 10*time.Minute)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/login", helper.ToJSON(incomingUser))
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Then
+	require.Equal(t, "600", w.Header().Get("Retry-After"))
+}
+
+func TestUnlockUser_Success(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.DELETE("/admin/lockouts/:username", UnlockUser)
+
+	server := helper.SetupTestCache(t)
+	require.NoError(t, server.Set("login:lock:user:test", "5"))
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectCommit()
+
+	// When
+	w := helper.PerformRequest(r, "DELETE", "/admin/lockouts/test", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Then
+	require.False(t, server.Exists("login:lock:user:test"))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestUnlockUser_NotLocked(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.DELETE("/admin/lockouts/:username", UnlockUser)
+
+	helper.SetupTestCache(t)
+
+	// When
+	w := helper.PerformRequest(r, "DELETE", "/admin/lockouts/test", nil)
+
+	// Then
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// authenticatedAs stands in for JWTAuth, authenticating every request as username.
+func authenticatedAs(username string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("username", username)
+		c.Next()
+	}
+}
+
+func mockUserRows(t *testing.T, password string) *sqlmock.Rows {
+	parseTime, err := time.Parse(time.RFC3339Nano, "2024-02-19T15:30:45.123456Z")
+	require.NoError(t, err)
+
+	return sqlmock.NewRows([]string{"id", "username", "password", "role", "created_at", "updated_at"}).
+		AddRow(1, "test", password, models.RoleUser, parseTime, parseTime)
+}
+
+func TestGetMe_Success(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/me", authenticatedAs("test"), GetMe)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockUserRows(t, "hash"))
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/me", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	require.Equal(t, "test", response["username"])
+	require.Equal(t, models.RoleUser, response["role"])
+	require.NotContains(t, response, "password")
+}
+
+func TestGetMe_NotFound(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.GET("/me", authenticatedAs("test"), GetMe)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/me", nil)
+
+	// Then
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestChangePassword_Success(t *testing.T) {
+	// Given
+	t.Setenv("BCRYPT_COST", "4")
+	r := gin.Default()
+	r.PUT("/me/password", authenticatedAs("test"), ChangePassword)
+
+	currentHash, err := auth.HashPassword("old-password")
+	require.NoError(t, err)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockUserRows(t, currentHash))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "user" SET "password"=(.+),"token_version"=(.+),"updated_at"=(.+) WHERE "id" = (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectCommit()
+
+	body := helper.ToJSON(models.ChangePassword{CurrentPassword: "old-password", NewPassword: "new-password"})
+
+	// When
+	w := helper.PerformRequest(r, "PUT", "/me/password", body)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Then the new token carries the bumped version, so the tokens issued before are revoked
+	var response map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	claims, err := auth.ParseToken(response["token"])
+	require.NoError(t, err)
+	require.Equal(t, 1, claims.TokenVersion)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestChangePassword_WrongCurrentPassword(t *testing.T) {
+	// Given
+	t.Setenv("BCRYPT_COST", "4")
+	r := gin.Default()
+	r.PUT("/me/password", authenticatedAs("test"), ChangePassword)
+
+	currentHash, err := auth.HashPassword("old-password")
+	require.NoError(t, err)
+
+	server := helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockUserRows(t, currentHash))
+
+	body := helper.ToJSON(models.ChangePassword{CurrentPassword: "wrong-password", NewPassword: "new-password"})
+
+	// When
+	w := helper.PerformRequest(r, "PUT", "/me/password", body)
+
+	// Then the failure counts towards the login lockout
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.True(t, server.Exists("login:delay:user:test"))
+}
+
+func TestChangePassword_LockedOut(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.PUT("/me/password", authenticatedAs("test"), ChangePassword)
+
+	server := helper.SetupTestCache(t)
+	require.NoError(t, server.Set("login:lock:user:test", "5"))
+	server.SetTTL("login:lock:user:test", 10*time.Minute)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockUserRows(t, "hash"))
+
+	body := helper.ToJSON(models.ChangePassword{CurrentPassword: "old-password", NewPassword: "new-password"})
+
+	// When
+	w := helper.PerformRequest(r, "PUT", "/me/password", body)
+
+	// Then
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "600", w.Header().Get("Retry-After"))
+}
+
+func TestDeleteMe_Success(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.DELETE("/me", authenticatedAs("test"), DeleteMe)
+
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	export.Store = store
+	t.Cleanup(func() { export.Store = nil })
+	require.NoError(t, store.Put(context.Background(), "exports/job.csv", strings.NewReader(""), 0, "text/csv"))
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockUserRows(t, "hash"))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`DELETE FROM "recovery_code" WHERE user_id = (.+)`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job" WHERE user_id = (.+)`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "storage_key"}).AddRow("job", 1, "exports/job.csv"))
+	dbMock.ExpectExec(`DELETE FROM "export_job" WHERE user_id = (.+)`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(`DELETE FROM "user" WHERE "user"."id" = (.+)`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectCommit()
+
+	// When
+	w := helper.PerformRequest(r, "DELETE", "/me", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Then the exports of the user are deleted with the account
+	_, err = store.Open(context.Background(), "exports/job.csv")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,280 @@
+package users
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/audit"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"gorm.io/gorm"
+)
+
+// EnrollTwoFactor godoc
+// @Summary Start 2FA enrollment
+// @Schemes
+// @Description Generates a TOTP secret for the authenticated user. 2FA is enabled once a code is confirmed
+// @Tags User
+// @Security JwtAuth
+// @Produce  json
+// @Success 200 {object} models.TwoFactorEnrollment
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "2FA is already enabled"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /me/2fa [post]
+func EnrollTwoFactor(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		problem.Write(c, errTwoFactorEnabled)
+		return
+	}
+
+	key, err := auth.GenerateTOTP(user.Username)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("totp_secret", key.Secret()).Error; err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TwoFactorEnrollment{Secret: key.Secret(), OtpauthURI: key.URL()})
+}
+
+// ConfirmTwoFactor godoc
+// @Summary Confirm 2FA enrollment
+// @Schemes
+// @Description Enables 2FA once the user proves their authenticator works, and returns single-use recovery codes
+// @Tags User
+// @Security JwtAuth
+// @Accept  json
+// @Produce  json
+// @Param   code body    models.TwoFactorCode true "TOTP code"
+// @Success 200 {string} string "Recovery codes"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Invalid code"
+// @Failure 409 {string} string "2FA is already enabled"
+// @Failure 429 {string} string "Too Many Requests"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /me/2fa/confirm [post]
+func ConfirmTwoFactor(c *gin.Context) {
+	var incomingCode models.TwoFactorCode
+
+	if err := c.ShouldBindJSON(&incomingCode); err != nil {
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		problem.Write(c, errTwoFactorEnabled)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		problem.Write(c, errTwoFactorPending)
+		return
+	}
+
+	// Codes are throttled like passwords, so a stolen token can't guess them
+	ip := c.ClientIP()
+	if loginBlocked(c, user.Username, ip) {
+		return
+	}
+
+	if !auth.ValidateTOTP(user.Username, user.TOTPSecret, incomingCode.Code) {
+		recordLoginFailure(user.Username, ip)
+		problem.Write(c, errInvalidCode)
+		return
+	}
+	resetLoginFailures(user.Username)
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	recoveryCodes := make([]models.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		recoveryCodes[i] = models.RecoveryCode{UserID: user.ID, CodeHash: hash}
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&recoveryCodes).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("totp_enabled", true).Error
+	})
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	audit.Record(audit.EventTwoFactorOn, user.Username, ip, "2FA enabled by the user")
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactor godoc
+// @Summary Disable 2FA
+// @Schemes
+// @Description Disables 2FA for the authenticated user, given a valid TOTP or recovery code
+// @Tags User
+// @Security JwtAuth
+// @Accept  json
+// @Produce  json
+// @Param   code body    models.TwoFactorCode true "TOTP or recovery code"
+// @Success 200 {string} string "2FA disabled"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Invalid code"
+// @Failure 429 {string} string "Too Many Requests"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /me/2fa [delete]
+func DisableTwoFactor(c *gin.Context) {
+	var incomingCode models.TwoFactorCode
+
+	if err := c.ShouldBindJSON(&incomingCode); err != nil {
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		problem.Write(c, errTwoFactorDisabled)
+		return
+	}
+
+	// Codes are throttled like passwords, so a stolen token can't guess them to turn 2FA off
+	ip := c.ClientIP()
+	if loginBlocked(c, user.Username, ip) {
+		return
+	}
+
+	valid, err := verifySecondFactor(c, user, incomingCode.Code)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	if !valid {
+		recordLoginFailure(user.Username, ip)
+		problem.Write(c, errInvalidCode)
+		return
+	}
+	resetLoginFailures(user.Username)
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": ""}).Error
+	})
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+
+	audit.Record(audit.EventTwoFactorOff, user.Username, ip, "2FA disabled by the user")
+
+	c.JSON(http.StatusOK, gin.H{"message": "2FA disabled"})
+}
+
+// LoginTwoFactor godoc
+// @Summary Complete a 2FA login
+// @Schemes
+// @Description Exchanges the challenge token returned by /login and a TOTP or recovery code for a JWT token
+// @Tags User
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce  json
+// @Param   login body    models.TwoFactorLogin true "Challenge token and code"
+// @Success 200 {string} string "JWT Token"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 429 {string} string "Too Many Requests"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /login/2fa [post]
+func LoginTwoFactor(c *gin.Context) {
+	var incomingLogin models.TwoFactorLogin
+
+	if err := c.ShouldBindJSON(&incomingLogin); err != nil {
+		problem.Write(c, problem.Binding(err))
+		return
+	}
+
+	claims, err := auth.ParseChallengeToken(incomingLogin.ChallengeToken)
+	if err != nil {
+		problem.Write(c, errInvalidChallenge)
+		return
+	}
+
+	ip := c.ClientIP()
+	if loginBlocked(c, claims.Username, ip) {
+		return
+	}
+
+	c.Set("username", claims.Username)
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	// The challenge is void once 2FA was turned off or the password changed since it was issued
+	if !user.TOTPEnabled || !claims.Current(user) {
+		problem.Write(c, errInvalidChallenge)
+		return
+	}
+
+	valid, err := verifySecondFactor(c, user, incomingLogin.Code)
+	if err != nil {
+		problem.Write(c, problem.Internal(err))
+		return
+	}
+	if !valid {
+		loginFailed(c, user.Username, ip)
+		return
+	}
+
+	loginSucceeded(c, user)
+}
+
+// verifySecondFactor checks code as a TOTP code, then as a recovery code, which it uses up.
+func verifySecondFactor(c *gin.Context, user models.User, code string) (bool, error) {
+	if auth.ValidateTOTP(user.Username, user.TOTPSecret, code) {
+		return true, nil
+	}
+
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, auth.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected == 1 {
+		audit.Record(audit.EventRecoveryUsed, user.Username, c.ClientIP(), "recovery code used")
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,332 @@
+package users
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// testUser is the user mockTwoFactorUserRows returns
+var testUser = models.User{ID: 1, Username: "test", Role: models.RoleUser}
+
+func mockTwoFactorUserRows(password, secret string, enabled bool) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "username", "password", "role", "totp_secret", "totp_enabled", "created_at", "updated_at"}).
+		AddRow(1, "test", password, models.RoleUser, secret, enabled, now, now)
+}
+
+func TestLoginUser_TwoFactorRequired(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/login", LoginUser)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("$2a$14$q6TbZ6LL71UjKldZheALMu5jS6AA3/BbFyB6AviKCO9B5LQJ4WMcq", testTOTPSecret, true))
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/login", helper.ToJSON(models.LoginUser{Username: "test", Password: "test"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	require.Nil(t, response["token"])
+	require.Equal(t, true, response["two_factor_required"])
+
+	claims, err := auth.ParseChallengeToken(response["challenge_token"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "test", claims.Username)
+}
+
+func TestLoginTwoFactor_Success(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/login/2fa", LoginTwoFactor)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, true))
+
+	challenge, err := auth.GenerateChallengeToken(testUser)
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/login/2fa", helper.ToJSON(models.TwoFactorLogin{ChallengeToken: challenge, Code: code}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	claims, err := auth.ParseToken(response["token"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "test", claims.Username)
+}
+
+func TestLoginTwoFactor_ReusedCode(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/login/2fa", LoginTwoFactor)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	code, err := totp.GenerateCode(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+	require.True(t, auth.ValidateTOTP("test", testTOTPSecret, code))
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, true))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "recovery_code" SET "used_at"=(.+) WHERE (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+
+	challenge, err := auth.GenerateChallengeToken(testUser)
+	require.NoError(t, err)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/login/2fa", helper.ToJSON(models.TwoFactorLogin{ChallengeToken: challenge, Code: code}))
+
+	// Then
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestLoginTwoFactor_RecoveryCode(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/login/2fa", LoginTwoFactor)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, true))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "recovery_code" SET "used_at"=(.+) WHERE (.+)`).
+		WithArgs(sqlmock.AnyArg(), 1, auth.HashRecoveryCode("ABCDEFGH-IJKLMNOP")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectCommit()
+
+	challenge, err := auth.GenerateChallengeToken(testUser)
+	require.NoError(t, err)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/login/2fa", helper.ToJSON(models.TwoFactorLogin{ChallengeToken: challenge, Code: "abcdefgh-ijklmnop"}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Then
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestLoginTwoFactor_AccessTokenRejected(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/login/2fa", LoginTwoFactor)
+
+	token, err := auth.GenerateToken(testUser)
+	require.NoError(t, err)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/login/2fa", helper.ToJSON(models.TwoFactorLogin{ChallengeToken: token, Code: "123456"}))
+
+	// Then
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestEnrollTwoFactor_Success(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/me/2fa", authenticatedAs("test"), EnrollTwoFactor)
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", "", false))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "user" SET "totp_secret"=(.+),"updated_at"=(.+) WHERE "id" = (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/me/2fa", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.TwoFactorEnrollment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	require.True(t, strings.HasPrefix(response.OtpauthURI, "otpauth://totp/"))
+	require.Contains(t, response.OtpauthURI, "secret="+response.Secret)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestConfirmTwoFactor_Success(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/me/2fa/confirm", authenticatedAs("test"), ConfirmTwoFactor)
+
+	helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, false))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`DELETE FROM "recovery_code" WHERE user_id = (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectQuery(`INSERT INTO "recovery_code" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectExec(`UPDATE "user" SET "totp_enabled"=(.+),"updated_at"=(.+) WHERE "id" = (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`INSERT INTO "audit_log" (.+) RETURNING "id"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	dbMock.ExpectCommit()
+
+	code, err := totp.GenerateCode(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/me/2fa/confirm", helper.ToJSON(models.TwoFactorCode{Code: code}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string][]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	// Then
+	require.Len(t, response["recovery_codes"], auth.RecoveryCodeCount)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestLoginTwoFactor_VoidChallenge(t *testing.T) {
+	tests := map[string]*sqlmock.Rows{
+		"2FA disabled": mockTwoFactorUserRows("hash", "", false),
+		"password changed": sqlmock.NewRows([]string{"id", "username", "totp_secret", "totp_enabled", "token_version"}).
+			AddRow(1, "test", testTOTPSecret, true, 1),
+	}
+
+	for name, rows := range tests {
+		// Given
+		r := gin.Default()
+		r.POST("/login/2fa", LoginTwoFactor)
+
+		helper.SetupTestCache(t)
+		dbMock, gormDB := helper.SetupTestDatabase(t)
+		database.DB = gormDB
+
+		dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+			WithArgs("test", 1).
+			WillReturnRows(rows)
+
+		challenge, err := auth.GenerateChallengeToken(testUser)
+		require.NoError(t, err)
+
+		// When
+		w := helper.PerformRequest(r, "POST", "/login/2fa", helper.ToJSON(models.TwoFactorLogin{ChallengeToken: challenge, Code: "123456"}))
+
+		// Then
+		require.Equal(t, http.StatusUnauthorized, w.Code, name)
+		require.Equal(t, "invalid_challenge_token", helper.DecodeProblem(t, w).Code, name)
+		require.NoError(t, dbMock.ExpectationsWereMet(), name)
+	}
+}
+
+func TestDisableTwoFactor_Throttled(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.DELETE("/me/2fa", authenticatedAs("test"), DisableTwoFactor)
+
+	server := helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, true))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "recovery_code" SET "used_at"=(.+) WHERE (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, true))
+
+	// When
+	wrong := helper.PerformRequest(r, "DELETE", "/me/2fa", helper.ToJSON(models.TwoFactorCode{Code: "000000"}))
+	retried := helper.PerformRequest(r, "DELETE", "/me/2fa", helper.ToJSON(models.TwoFactorCode{Code: "000000"}))
+
+	// Then the wrong code delays the next attempt like a failed login
+	require.Equal(t, http.StatusUnauthorized, wrong.Code)
+	require.True(t, server.Exists("login:delay:user:test"))
+	require.Equal(t, http.StatusTooManyRequests, retried.Code)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestConfirmTwoFactor_Throttled(t *testing.T) {
+	// Given
+	r := gin.Default()
+	r.POST("/me/2fa/confirm", authenticatedAs("test"), ConfirmTwoFactor)
+
+	server := helper.SetupTestCache(t)
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT \* FROM "user" WHERE username = (.+) ORDER BY "user"."id" LIMIT (.+)`).
+		WithArgs("test", 1).
+		WillReturnRows(mockTwoFactorUserRows("hash", testTOTPSecret, false))
+
+	// When
+	w := helper.PerformRequest(r, "POST", "/me/2fa/confirm", helper.ToJSON(models.TwoFactorCode{Code: "000000"}))
+
+	// Then
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.True(t, server.Exists("login:delay:user:test"))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"log"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+)
+
+const (
+	EventLoginLockout   = "login_lockout"
+	EventLoginUnlock    = "login_unlock"
+	EventPasswordChange = "password_change"
+	EventAccountDelete  = "account_delete"
+	EventTwoFactorOn    = "two_factor_enabled"
+	EventTwoFactorOff   = "two_factor_disabled"
+	EventRecoveryUsed   = "recovery_code_used"
+	EventUserProvision  = "user_provisioned"
+)
+
+// Record writes a security relevant event to the log and the audit table.
+func Record(event, username, ip, detail string) {
+	log.Printf("AUDIT event=%s username=%q ip=%s detail=%q\n", event, username, ip, detail)
+
+	entry := models.AuditLog{
+		Event:    event,
+		Username: username,
+		IP:       ip,
+		Detail:   detail,
+	}
+
+	if err := database.DB.Create(&entry).Error; err != nil {
+		log.Printf("Error inserting audit log: %s\n", err)
+	}
+}
@@ -3,31 +3,56 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
 	"golang.org/x/crypto/bcrypt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
+// ScopeTwoFactor marks a token that can only be exchanged for an access token with a TOTP code
+const ScopeTwoFactor = "2fa"
+
 // Claims struct to be encoded to JWT
 type Claims struct {
 	Username string `json:"username"`
+	Role     string `json:"role,omitempty"`
+	// Scope is empty for access tokens and ScopeTwoFactor for login challenges
+	Scope string `json:"scope,omitempty"`
+	// UserID and TokenVersion tie an access token to the account it was issued to, see Current
+	UserID       int `json:"uid,omitempty"`
+	TokenVersion int `json:"ver,omitempty"`
 	jwt.StandardClaims
 }
 
+// Current reports whether the claims were issued to user since its tokens were last revoked.
+// A user deleted and registered again under the same username gets a new ID, so the old tokens don't carry over.
+func (c *Claims) Current(user models.User) bool {
+	return c.UserID == user.ID && c.Username == user.Username && c.TokenVersion == user.TokenVersion
+}
+
 var JwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
 
-// GenerateToken generates a JWT token for a given username
-func GenerateToken(username string) (string, error) {
+// GenerateToken generates a JWT token for a given user
+func GenerateToken(user models.User) (string, error) {
 	// The expiration time after which the token will be invalid.
 	expirationTime := time.Now().Add(24 * time.Hour).Unix()
 
 	// Create the JWT claims, which includes the username and expiration time
-	claims := &jwt.StandardClaims{
-		// In JWT, the expiry time is expressed as unix milliseconds
-		ExpiresAt: expirationTime,
-		Issuer:    username,
+	claims := &Claims{
+		Username:     user.Username,
+		Role:         user.Role,
+		UserID:       user.ID,
+		TokenVersion: user.TokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			// In JWT, the expiry time is expressed as unix milliseconds
+			ExpiresAt: expirationTime,
+			Issuer:    user.Username,
+		},
 	}
 
 	// Declare the token with the algorithm used for signing, and the claims
@@ -43,6 +68,76 @@ func GenerateToken(username string) (string, error) {
 	return tokenString, nil
 }
 
+// GenerateChallengeToken generates a short-lived token proving user passed the password check.
+// Like access tokens, it is bound to the user ID and token version, see Current.
+func GenerateChallengeToken(user models.User) (string, error) {
+	claims := &Claims{
+		Username:     user.Username,
+		Scope:        ScopeTwoFactor,
+		UserID:       user.ID,
+		TokenVersion: user.TokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(config.Seconds("TOTP_CHALLENGE_TTL", 5*time.Minute)).Unix(),
+			Issuer:    user.Username,
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(JwtKey)
+}
+
+// ParseToken validates an access token string and returns its claims
+func ParseToken(tokenStr string) (*Claims, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Scope != "" {
+		return nil, errors.New("not an access token")
+	}
+
+	return claims, nil
+}
+
+// ParseChallengeToken validates a two-factor challenge token string and returns its claims
+func ParseChallengeToken(tokenStr string) (*Claims, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Scope != ScopeTwoFactor {
+		return nil, errors.New("not a two-factor challenge token")
+	}
+
+	// Challenges issued before they were bound to a user can't be checked against it
+	if claims.UserID == 0 {
+		return nil, errors.New("challenge token not bound to a user")
+	}
+
+	return claims, nil
+}
+
+func parseClaims(tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return JwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
 // GenerateRandomKey generates a random key for JWT signing
 func GenerateRandomKey() string {
 	key := make([]byte, 32) // generate a 256-bit key
@@ -56,10 +151,29 @@ func GenerateRandomKey() string {
 
 // HashPassword hashes a password using bcrypt
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost())
 	return string(bytes), err
 }
 
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// DummyHash returns a hash of a random password, used to spend the same time
+// verifying a login for an unknown username as for a known one
+func DummyHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := HashPassword(GenerateRandomKey())
+		if err != nil {
+			panic("Failed to generate dummy hash: " + err.Error())
+		}
+		dummyHash = hash
+	})
+
+	return dummyHash
+}
+
 func ComparePassword(dbPassword string, incomingPassword string) error {
 	err := bcrypt.CompareHashAndPassword([]byte(dbPassword), []byte(incomingPassword))
 	if err != nil {
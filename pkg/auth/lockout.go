@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// LoginPolicy controls how failed logins are throttled.
+// Every failure delays the next attempt exponentially, from DelayBase up to DelayMax,
+// and reaching the attempt limit within Window locks the username or IP for Lockout.
+type LoginPolicy struct {
+	MaxUserAttempts int
+	MaxIPAttempts   int
+	Window          time.Duration
+	Lockout         time.Duration
+	DelayBase       time.Duration
+	DelayMax        time.Duration
+}
+
+// DefaultLoginPolicy reads the login throttling settings from the environment.
+func DefaultLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxUserAttempts: config.Int("LOGIN_MAX_ATTEMPTS", 5),
+		MaxIPAttempts:   config.Int("LOGIN_MAX_IP_ATTEMPTS", 20),
+		Window:          config.Seconds("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		Lockout:         config.Seconds("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		DelayBase:       config.Seconds("LOGIN_DELAY_BASE", time.Second),
+		DelayMax:        config.Seconds("LOGIN_DELAY_MAX", 30*time.Second),
+	}
+}
+
+// LoginLockout reports which subjects a failed login locked out.
+type LoginLockout struct {
+	Username bool
+	IP       bool
+}
+
+func loginKey(kind, subject, id string) string {
+	return fmt.Sprintf("login:%s:%s:%s", kind, subject, id)
+}
+
+// LoginBlocked reports how long a login for username from ip must wait before it may be attempted.
+func LoginBlocked(username, ip string) (time.Duration, error) {
+	keys := []string{
+		loginKey("lock", "user", username),
+		loginKey("lock", "ip", ip),
+		loginKey("delay", "user", username),
+		loginKey("delay", "ip", ip),
+	}
+
+	pipe := cache.Rdb.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.PTTL(cache.Ctx, key)
+	}
+	if _, err := pipe.Exec(cache.Ctx); err != nil {
+		return 0, fmt.Errorf("error checking login lockout: %v", err)
+	}
+
+	var wait time.Duration
+	for _, ttl := range ttls {
+		if ttl.Val() > wait {
+			wait = ttl.Val()
+		}
+	}
+
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed login for username from ip and applies delays and lockouts.
+func RecordLoginFailure(policy LoginPolicy, username, ip string) (LoginLockout, error) {
+	var lockout LoginLockout
+	var err error
+
+	lockout.Username, err = recordFailure(policy, "user", username, policy.MaxUserAttempts)
+	if err != nil {
+		return lockout, err
+	}
+
+	lockout.IP, err = recordFailure(policy, "ip", ip, policy.MaxIPAttempts)
+	return lockout, err
+}
+
+// countFailure increments a failure counter and starts its window atomically, so a crash between the two can't leave
+// a counter that never expires. The window is only set when the counter has none, like EXPIRE NX.
+var countFailure = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+
+return failures
+`)
+
+func recordFailure(policy LoginPolicy, subject, id string, maxAttempts int) (bool, error) {
+	failKey := loginKey("fail", subject, id)
+
+	failures, err := countFailure.Run(cache.Ctx, cache.Rdb, []string{failKey}, policy.Window.Milliseconds()).Int64()
+	if err != nil {
+		return false, fmt.Errorf("error counting login failure: %v", err)
+	}
+
+	if failures >= int64(maxAttempts) {
+		if err := cache.Rdb.Set(cache.Ctx, loginKey("lock", subject, id), failures, policy.Lockout).Err(); err != nil {
+			return false, fmt.Errorf("error locking login: %v", err)
+		}
+		cache.Rdb.Del(cache.Ctx, failKey)
+		return true, nil
+	}
+
+	delay := policy.DelayBase << (failures - 1)
+	if delay > policy.DelayMax || delay <= 0 {
+		delay = policy.DelayMax
+	}
+	if err := cache.Rdb.Set(cache.Ctx, loginKey("delay", subject, id), failures, delay).Err(); err != nil {
+		return false, fmt.Errorf("error delaying login: %v", err)
+	}
+
+	return false, nil
+}
+
+// ResetLoginFailures clears the failure count of username after a successful login.
+func ResetLoginFailures(username string) error {
+	return deleteKeys(loginKey("fail", "user", username), loginKey("delay", "user", username))
+}
+
+// deleteKeys deletes keys one by one in a pipeline, since in Redis Cluster they may live in different slots.
+func deleteKeys(keys ...string) error {
+	pipe := cache.Rdb.Pipeline()
+	for _, key := range keys {
+		pipe.Del(cache.Ctx, key)
+	}
+
+	_, err := pipe.Exec(cache.Ctx)
+	return err
+}
+
+// UnlockLogin lifts the lockout of username and, when given, of ip.
+// It returns false when neither was locked.
+func UnlockLogin(username, ip string) (bool, error) {
+	subjects := map[string]string{"user": username}
+	if ip != "" {
+		subjects["ip"] = ip
+	}
+
+	var lockKeys, keys []string
+	for subject, id := range subjects {
+		lockKeys = append(lockKeys, loginKey("lock", subject, id))
+		keys = append(keys, loginKey("lock", subject, id), loginKey("fail", subject, id), loginKey("delay", subject, id))
+	}
+
+	pipe := cache.Rdb.Pipeline()
+	exists := make([]*redis.IntCmd, len(lockKeys))
+	for i, key := range lockKeys {
+		exists[i] = pipe.Exists(cache.Ctx, key)
+	}
+	if _, err := pipe.Exec(cache.Ctx); err != nil {
+		return false, fmt.Errorf("error checking login lockout: %v", err)
+	}
+
+	if err := deleteKeys(keys...); err != nil {
+		return false, fmt.Errorf("error unlocking login: %v", err)
+	}
+
+	var locked bool
+	for _, lockExists := range exists {
+		locked = locked || lockExists.Val() > 0
+	}
+
+	return locked, nil
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func testLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxUserAttempts: 3,
+		MaxIPAttempts:   10,
+		Window:          time.Minute,
+		Lockout:         15 * time.Minute,
+		DelayBase:       time.Second,
+		DelayMax:        30 * time.Second,
+	}
+}
+
+func TestRecordLoginFailure_ProgressiveDelay(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+
+	// When
+	_, err := RecordLoginFailure(testLoginPolicy(), "test", "10.0.0.1")
+	require.NoError(t, err)
+	_, err = RecordLoginFailure(testLoginPolicy(), "test", "10.0.0.1")
+	require.NoError(t, err)
+
+	// Then
+	wait, err := LoginBlocked("test", "10.0.0.1")
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, wait)
+}
+
+func TestRecordLoginFailure_Lockout(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+
+	// When
+	var lockout LoginLockout
+	var err error
+	for i := 0; i < 3; i++ {
+		lockout, err = RecordLoginFailure(testLoginPolicy(), "test", "10.0.0.1")
+		require.NoError(t, err)
+	}
+
+	// Then
+	require.True(t, lockout.Username)
+	require.False(t, lockout.IP)
+
+	wait, err := LoginBlocked("test", "10.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Minute, wait)
+}
+
+func TestUnlockLogin(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	for i := 0; i < 3; i++ {
+		_, err := RecordLoginFailure(testLoginPolicy(), "test", "10.0.0.1")
+		require.NoError(t, err)
+	}
+
+	// When
+	unlocked, err := UnlockLogin("test", "10.0.0.1")
+	require.NoError(t, err)
+
+	// Then
+	require.True(t, unlocked)
+
+	wait, err := LoginBlocked("test", "10.0.0.1")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+}
+
+func TestResetLoginFailures(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	_, err := RecordLoginFailure(testLoginPolicy(), "test", "10.0.0.1")
+	require.NoError(t, err)
+
+	// When
+	require.NoError(t, ResetLoginFailures("test"))
+
+	// Then
+	wait, err := LoginBlocked("test", "10.0.0.2")
+	require.NoError(t, err)
+	require.Zero(t, wait)
+}
+
+func TestRecordLoginFailure_Window(t *testing.T) {
+	// Given a counter left without a window
+	server := helper.SetupTestCache(t)
+	require.NoError(t, server.Set("login:fail:user:test", "1"))
+
+	// When
+	_, err := RecordLoginFailure(testLoginPolicy(), "test", "10.0.0.1")
+	require.NoError(t, err)
+	_, err = RecordLoginFailure(testLoginPolicy(), "other", "10.0.0.1")
+	require.NoError(t, err)
+
+	// Then every counter expires with the window
+	require.Equal(t, time.Minute, server.TTL("login:fail:user:test"))
+	require.Equal(t, time.Minute, server.TTL("login:fail:user:other"))
+	require.Equal(t, time.Minute, server.TTL("login:fail:ip:10.0.0.1"))
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-redis/redis/v8"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProvider is the identity provider used for single sign-on
+type OIDCProvider struct {
+	OAuth2   oauth2.Config
+	Verifier *oidc.IDTokenVerifier
+	// UsernameClaim and GroupsClaim name the ID token claims holding the username and the user's groups
+	UsernameClaim string
+	GroupsClaim   string
+	// RoleMapping maps IdP groups to roles, DefaultRole applies when no group matches
+	RoleMapping map[string]string
+	DefaultRole string
+}
+
+// OIDCIdentity is the verified identity of a user signed in through the provider
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Groups   []string
+}
+
+// oidcLogin is the state kept between redirecting to the provider and its callback
+type oidcLogin struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+// OIDC is nil unless single sign-on is configured
+var OIDC *OIDCProvider
+
+var ErrOIDCState = errors.New("invalid or expired login state")
+
+// InitOIDC discovers the provider at OIDC_ISSUER. Single sign-on stays disabled when it is unset.
+func InitOIDC() {
+	issuer := config.String("OIDC_ISSUER", "")
+	if issuer == "" {
+		return
+	}
+
+	provider, err := NewOIDCProvider(context.Background(), issuer)
+	if err != nil {
+		log.Printf("Failed to initialize OIDC provider, single sign-on is disabled: %v", err)
+		return
+	}
+
+	OIDC = provider
+}
+
+// NewOIDCProvider discovers issuer and configures the client from the environment
+func NewOIDCProvider(ctx context.Context, issuer string) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering OIDC provider: %v", err)
+	}
+
+	clientID := config.String("OIDC_CLIENT_ID", "")
+	scopes := config.List("OIDC_SCOPES")
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+
+	// OIDC_ROLE_MAPPING looks like "currency-admins=admin,analysts=user"
+	roleMapping := map[string]string{}
+	for _, mapping := range config.List("OIDC_ROLE_MAPPING") {
+		group, role, found := strings.Cut(mapping, "=")
+		if !found {
+			log.Printf("Ignoring invalid OIDC_ROLE_MAPPING entry %q", mapping)
+			continue
+		}
+		roleMapping[strings.TrimSpace(group)] = strings.TrimSpace(role)
+	}
+
+	return &OIDCProvider{
+		OAuth2: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: config.String("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:  config.String("OIDC_REDIRECT_URL", "http://localhost:8001/api/v1/oidc/callback"),
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		Verifier:      provider.Verifier(&oidc.Config{ClientID: clientID}),
+		UsernameClaim: config.String("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   config.String("OIDC_GROUPS_CLAIM", "groups"),
+		RoleMapping:   roleMapping,
+		DefaultRole:   config.String("OIDC_DEFAULT_ROLE", models.RoleUser),
+	}, nil
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func oidcStateKey(state string) string {
+	return "oidc:state:" + state
+}
+
+// AuthCodeURL starts an authorization code flow with PKCE and returns the provider URL to redirect to
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context) (string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating OIDC state: %v", err)
+	}
+
+	nonce, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("error generating OIDC nonce: %v", err)
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	login, err := json.Marshal(oidcLogin{Verifier: verifier, Nonce: nonce})
+	if err != nil {
+		return "", err
+	}
+
+	if err := cache.Rdb.Set(ctx, oidcStateKey(state), login, 10*time.Minute).Err(); err != nil {
+		return "", fmt.Errorf("error storing OIDC state: %v", err)
+	}
+
+	return p.OAuth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange completes the flow started with state, verifying the returned ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (*OIDCIdentity, error) {
+	storedLogin, err := cache.Rdb.GetDel(ctx, oidcStateKey(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrOIDCState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading OIDC state: %v", err)
+	}
+
+	var login oidcLogin
+	if err := json.Unmarshal(storedLogin, &login); err != nil {
+		return nil, ErrOIDCState
+	}
+
+	token, err := p.OAuth2.Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("error exchanging authorization code: %v", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := p.Verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying ID token: %v", err)
+	}
+
+	if idToken.Nonce != login.Nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("error reading ID token claims: %v", err)
+	}
+
+	identity := &OIDCIdentity{Subject: idToken.Subject}
+	for _, claim := range []string{p.UsernameClaim, "email"} {
+		if username, ok := claims[claim].(string); ok && username != "" {
+			identity.Username = username
+			break
+		}
+	}
+	if identity.Username == "" {
+		identity.Username = idToken.Subject
+	}
+
+	switch groups := claims[p.GroupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+
+	return identity, nil
+}
+
+// RoleFor maps the IdP groups of a user to a role, preferring admin when several groups match
+func (p *OIDCProvider) RoleFor(groups []string) string {
+	role := p.DefaultRole
+	for _, group := range groups {
+		mapped, found := p.RoleMapping[group]
+		if !found {
+			continue
+		}
+		if mapped == models.RoleAdmin {
+			return mapped
+		}
+		role = mapped
+	}
+
+	return role
+}
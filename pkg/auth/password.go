@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordError describes why a password was rejected by the password policy.
+type PasswordError struct {
+	Reason string
+}
+
+func (e *PasswordError) Error() string {
+	return e.Reason
+}
+
+// PasswordPolicy holds the rules new passwords must follow.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// Breached holds known leaked passwords, lower-cased
+	Breached map[string]struct{}
+}
+
+// LoadPasswordPolicy reads the password policy from the environment.
+// PASSWORD_BREACHED_LIST may point to a local file with one leaked password per line.
+func LoadPasswordPolicy() *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength: config.Int("PASSWORD_MIN_LENGTH", 8),
+		// bcrypt ignores everything past 72 bytes
+		MaxLength: config.Int("PASSWORD_MAX_LENGTH", 72),
+		Breached:  map[string]struct{}{},
+	}
+
+	path := os.Getenv("PASSWORD_BREACHED_LIST")
+	if path == "" {
+		return policy
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error opening breached password list: %s\n", err)
+		return policy
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			policy.Breached[strings.ToLower(password)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading breached password list: %s\n", err)
+	}
+
+	return policy
+}
+
+// Validate checks password against the policy for the account username.
+func (p *PasswordPolicy) Validate(username, password string) error {
+	if len(password) < p.MinLength {
+		return &PasswordError{Reason: fmt.Sprintf("Password must be at least %d characters long", p.MinLength)}
+	}
+
+	if len(password) > p.MaxLength {
+		return &PasswordError{Reason: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength)}
+	}
+
+	if strings.EqualFold(password, username) {
+		return &PasswordError{Reason: "Password must not match the username"}
+	}
+
+	if _, found := p.Breached[strings.ToLower(password)]; found {
+		return &PasswordError{Reason: "Password has appeared in a data breach, choose a different one"}
+	}
+
+	return nil
+}
+
+var passwordPolicy = sync.OnceValue(LoadPasswordPolicy)
+
+// ValidatePassword checks password against the configured password policy.
+func ValidatePassword(username, password string) error {
+	return passwordPolicy().Validate(username, password)
+}
+
+// PasswordCost returns the configured bcrypt cost for new password hashes.
+func PasswordCost() int {
+	cost := config.Int("BCRYPT_COST", 14)
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		log.Printf("BCRYPT_COST %d is out of range, using 14\n", cost)
+		return 14
+	}
+
+	return cost
+}
+
+// NeedsRehash reports whether hash was created with a different cost than the configured one.
+func NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != PasswordCost()
+}
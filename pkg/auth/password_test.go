@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	// Given
+	list := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(list, []byte("password123\nqwertyuiop\n"), 0o600))
+	t.Setenv("PASSWORD_BREACHED_LIST", list)
+
+	policy := LoadPasswordPolicy()
+
+	// When / Then
+	require.NoError(t, policy.Validate("test", "correct-horse-battery"))
+	require.EqualError(t, policy.Validate("test", "short"), "Password must be at least 8 characters long")
+	require.EqualError(t, policy.Validate("username", "USERNAME"), "Password must not match the username")
+	require.EqualError(t, policy.Validate("test", "Password123"), "Password has appeared in a data breach, choose a different one")
+}
+
+func TestNeedsRehash(t *testing.T) {
+	// Given
+	t.Setenv("BCRYPT_COST", "4")
+	hash, err := HashPassword("correct-horse-battery")
+	require.NoError(t, err)
+
+	// When / Then
+	require.False(t, NeedsRehash(hash))
+
+	t.Setenv("BCRYPT_COST", "5")
+	require.True(t, NeedsRehash(hash))
+}
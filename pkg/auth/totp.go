@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// RecoveryCodeCount is the number of single-use recovery codes issued when enabling 2FA
+const RecoveryCodeCount = 10
+
+// GenerateTOTP creates a new TOTP secret for username
+func GenerateTOTP(username string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      config.String("TOTP_ISSUER", "Boletia Currency API"),
+		AccountName: username,
+	})
+}
+
+// ValidateTOTP checks code against secret, refusing codes already used by username
+func ValidateTOTP(username, secret, code string) bool {
+	// Codes can be computed from an empty key, so users without a secret never validate one
+	if secret == "" {
+		return false
+	}
+
+	code = strings.TrimSpace(code)
+	if !totp.Validate(code, secret) {
+		return false
+	}
+
+	// A code stays valid for up to three periods with the default skew, so remember it that long
+	used, err := cache.Rdb.SetNX(cache.Ctx, fmt.Sprintf("totp:used:%s:%s", username, code), 1, 90*time.Second).Result()
+	if err != nil {
+		log.Printf("Error checking TOTP code reuse: %s\n", err)
+		return true
+	}
+
+	return used
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount random codes along with the hashes to store
+func GenerateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+
+		code := base32.StdEncoding.EncodeToString(raw)
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code for storage, ignoring case and separators.
+// Recovery codes carry 80 random bits, so a fast hash is enough.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
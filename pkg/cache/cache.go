@@ -2,17 +2,29 @@ package cache
 
 import (
 	"context"
+	"log"
 
 	"github.com/go-redis/redis/v8"
 )
 
-var Rdb *redis.Client
+var Rdb redis.UniversalClient
 var Ctx = context.Background()
 
+// InitRedis connects to the Redis configured in the environment.
+// The API keeps running when Redis cannot be reached, reading through to the database.
 func InitRedis() {
-	Rdb = redis.NewClient(&redis.Options{
-		Addr:     "dockerRedis:6379", // Redis server address
-		Password: "",                 // Password, leave empty if none
-		DB:       0,                  // Default DB
-	})
+	cfg, err := LoadRedisConfig()
+	if err != nil {
+		log.Fatalf("Invalid Redis configuration: %v", err)
+	}
+
+	Rdb = cfg.NewClient()
+	Default = NewRedisStore(Rdb)
+
+	if err := cfg.Ping(Ctx, Rdb); err != nil {
+		log.Printf("Redis is not reachable, starting without it: %v", err)
+	}
+
+	// Keep the local tier of this replica coherent with invalidations from the others
+	go SubscribeInvalidations(Ctx, Rdb)
 }
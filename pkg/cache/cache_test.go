@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+type cachedRates struct {
+	Code  string  `json:"code"`
+	Value float64 `json:"value"`
+}
+
+func testStores(t *testing.T) map[string]Store {
+	server := miniredis.RunT(t)
+
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"redis":  NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr()})),
+	}
+}
+
+func TestNamespace_GetSet(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store}
+
+			// When
+			require.NoError(t, Set(ctx, namespace, "usd", cachedRates{Code: "USD", Value: 1}))
+			value, err := Get[cachedRates](ctx, namespace, "usd")
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, cachedRates{Code: "USD", Value: 1}, value)
+		})
+	}
+}
+
+func TestNamespace_Miss(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store}
+
+			// When
+			_, err := Get[cachedRates](context.Background(), namespace, "missing")
+
+			// Then
+			require.ErrorIs(t, err, ErrMiss)
+		})
+	}
+}
+
+func TestNamespace_Invalidate(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store}
+			other := &Namespace{Name: "other", TTL: time.Minute, Store: store}
+			require.NoError(t, Set(ctx, namespace, "usd", cachedRates{Code: "USD", Value: 1}))
+			require.NoError(t, Set(ctx, other, "usd", cachedRates{Code: "USD", Value: 1}))
+
+			// When
+			require.NoError(t, namespace.Invalidate(ctx))
+
+			// Then
+			_, err := Get[cachedRates](ctx, namespace, "usd")
+			require.ErrorIs(t, err, ErrMiss)
+
+			_, err = Get[cachedRates](ctx, other, "usd")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestNamespace_Watch(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	namespace := &Namespace{Name: "test", TTL: time.Minute, Store: NewMemoryStore()}
+	updates, stop := namespace.Watch()
+
+	// When invalidated twice before the watcher reads
+	require.NoError(t, namespace.Invalidate(ctx))
+	require.NoError(t, namespace.Invalidate(ctx))
+
+	// Then the signals are coalesced
+	require.Len(t, updates, 1)
+	<-updates
+
+	// And stopped watchers are not signalled
+	stop()
+	require.NoError(t, namespace.Invalidate(ctx))
+	require.Len(t, updates, 0)
+}
+
+func TestMemoryStore_Expiry(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	store := NewMemoryStore()
+	require.NoError(t, store.Set(ctx, "key", []byte("value"), time.Millisecond))
+
+	// When
+	time.Sleep(5 * time.Millisecond)
+	_, err := store.Get(ctx, "key")
+
+	// Then
+	require.ErrorIs(t, err, ErrMiss)
+}
+
+func TestNewNamespace_TTLFromEnvironment(t *testing.T) {
+	// Given
+	t.Setenv("CACHE_TTL_CURRENCY_TEST", "30")
+
+	// When
+	namespace := NewNamespace("currency_test", time.Hour, time.Hour)
+
+	// Then
+	require.Equal(t, 30*time.Second, namespace.TTL)
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// lockPollInterval is how often a replica waiting on another one's lock checks for the value
+const lockPollInterval = 50 * time.Millisecond
+
+var (
+	// LockTTL bounds how long a replica may hold the lock to recompute a key
+	LockTTL = config.Seconds("CACHE_LOCK_TTL", 10*time.Second)
+	// LockWait is how long a replica waits for another one to recompute a key before loading it itself
+	LockWait = config.Seconds("CACHE_LOCK_WAIT", 3*time.Second)
+)
+
+// loads coalesces concurrent loads of the same key within this process
+var loads singleflight.Group
+
+// GetOrLoad returns the value cached under key, calling load to compute it on a miss.
+//
+// Hot values are served from the local tier without reaching the store. Concurrent misses
+// are coalesced, in process and across replicas through a lock in the store, so a key is
+// computed once. Stale entries, and entries of the previous namespace version right after
+// an invalidation, are served while being refreshed in the background.
+// When the store is unavailable values are loaded from the database and only cached locally.
+func GetOrLoad[T any](ctx context.Context, n *Namespace, key string, load func(context.Context) (T, error)) (T, error) {
+	generation := n.Local.Generation()
+	if value, found := getLocal[T](n, generation, key); found {
+		return value, nil
+	}
+
+	store := n.store()
+	if !storeAvailable(store) {
+		return loadLocal(ctx, n, generation, key, load)
+	}
+
+	version, err := n.Version(ctx)
+	if err != nil {
+		storeFailed(store, err)
+		return loadLocal(ctx, n, generation, key, load)
+	}
+	storeKey := n.versionedKey(version, key)
+
+	cached, err := getEntry[T](ctx, n, storeKey)
+	if err == nil {
+		if cached.fresh() {
+			n.Local.Add(generation, key, cached.Value)
+		} else {
+			refresh(n, generation, key, storeKey, load)
+		}
+		return cached.Value, nil
+	}
+	if !errors.Is(err, ErrMiss) {
+		storeFailed(store, err)
+		return loadLocal(ctx, n, generation, key, load)
+	}
+
+	// Right after an invalidation keep serving the previous version until the new one is computed
+	if version > 0 {
+		if previous, err := getEntry[T](ctx, n, n.versionedKey(version-1, key)); err == nil {
+			refresh(n, generation, key, storeKey, load)
+			return previous.Value, nil
+		}
+	}
+
+	// The load is shared by every caller, so it must not be cancelled along with the first one
+	value, err, _ := loads.Do(storeKey, func() (interface{}, error) {
+		return loadLocked(context.WithoutCancel(ctx), n, storeKey, load)
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	n.Local.Add(generation, key, value)
+	return value.(T), nil
+}
+
+// loadLocal computes key without the store, caching it in the local tier only
+func loadLocal[T any](ctx context.Context, n *Namespace, generation int64, key string, load func(context.Context) (T, error)) (T, error) {
+	value, err, _ := loads.Do("local:"+n.Name+":"+key, func() (interface{}, error) {
+		return load(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	n.Local.Add(generation, key, value)
+	return value.(T), nil
+}
+
+// loadLocked computes storeKey under the store lock, or waits for the replica holding it
+func loadLocked[T any](ctx context.Context, n *Namespace, storeKey string, load func(context.Context) (T, error)) (T, error) {
+	release, acquired, err := n.store().TryLock(ctx, lockKey(storeKey), LockTTL)
+	if err != nil {
+		log.Printf("Error locking cache %s: %s\n", storeKey, err)
+		return loadAndStore(ctx, n, storeKey, load)
+	}
+
+	if !acquired {
+		if cached, found := waitForEntry[T](ctx, n, storeKey); found {
+			return cached.Value, nil
+		}
+		return loadAndStore(ctx, n, storeKey, load)
+	}
+	defer release()
+
+	// Another replica may have stored the value between our miss and taking the lock
+	if cached, err := getEntry[T](ctx, n, storeKey); err == nil && cached.fresh() {
+		return cached.Value, nil
+	}
+
+	return loadAndStore(ctx, n, storeKey, load)
+}
+
+// waitForEntry polls storeKey until the replica holding its lock stores it, for up to LockWait
+func waitForEntry[T any](ctx context.Context, n *Namespace, storeKey string) (entry[T], bool) {
+	ctx, cancel := context.WithTimeout(ctx, LockWait)
+	defer cancel()
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return entry[T]{}, false
+		case <-ticker.C:
+			cached, err := getEntry[T](ctx, n, storeKey)
+			if err == nil && cached.fresh() {
+				return cached, true
+			}
+		}
+	}
+}
+
+// refresh recomputes storeKey in the background, unless this process or another replica already is
+func refresh[T any](n *Namespace, generation int64, key, storeKey string, load func(context.Context) (T, error)) {
+	// Nobody waits on the result, DoChan only starts a goroutine for the first caller
+	loads.DoChan("refresh:"+storeKey, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), LockTTL)
+		defer cancel()
+
+		release, acquired, err := n.store().TryLock(ctx, lockKey(storeKey), LockTTL)
+		if err != nil || !acquired {
+			return nil, err
+		}
+		defer release()
+
+		value, err := loadAndStore(ctx, n, storeKey, load)
+		if err != nil {
+			log.Printf("Error refreshing cache %s: %s\n", storeKey, err)
+			return nil, err
+		}
+
+		n.Local.Add(generation, key, value)
+		return value, nil
+	})
+}
+
+func loadAndStore[T any](ctx context.Context, n *Namespace, storeKey string, load func(context.Context) (T, error)) (T, error) {
+	value, err := load(ctx)
+	if err != nil {
+		return value, err
+	}
+
+	if err := setEntry(ctx, n, storeKey, value); err != nil {
+		log.Printf("Error caching %s: %s\n", storeKey, err)
+	}
+
+	return value, nil
+}
+
+func lockKey(storeKey string) string {
+	return "lock:" + storeKey
+}
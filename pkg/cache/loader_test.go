@@ -0,0 +1,252 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+)
+
+// countingLoader returns value after delay, counting its calls
+func countingLoader(calls *int32, value cachedRates, delay time.Duration) func(context.Context) (cachedRates, error) {
+	return func(context.Context) (cachedRates, error) {
+		atomic.AddInt32(calls, 1)
+		time.Sleep(delay)
+		return value, nil
+	}
+}
+
+func TestGetOrLoad_CoalescesMisses(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store}
+			var calls int32
+			load := countingLoader(&calls, cachedRates{Code: "USD", Value: 1}, 50*time.Millisecond)
+
+			// When
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					value, err := GetOrLoad(ctx, namespace, "usd", load)
+					require.NoError(t, err)
+					require.Equal(t, "USD", value.Code)
+				}()
+			}
+			wg.Wait()
+
+			// Then
+			require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestGetOrLoad_ServesStaleWhileRefreshing(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			namespace := &Namespace{Name: "test", TTL: 10 * time.Millisecond, Stale: time.Minute, Store: store}
+			require.NoError(t, Set(ctx, namespace, "usd", cachedRates{Code: "USD", Value: 1}))
+			time.Sleep(20 * time.Millisecond)
+
+			var calls int32
+			load := countingLoader(&calls, cachedRates{Code: "USD", Value: 2}, 0)
+
+			// When
+			value, err := GetOrLoad(ctx, namespace, "usd", load)
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, float64(1), value.Value)
+
+			storeKey, err := namespace.Key(ctx, "usd")
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				refreshed, err := getEntry[cachedRates](ctx, namespace, storeKey)
+				return err == nil && refreshed.Value.Value == 2
+			}, time.Second, 10*time.Millisecond)
+			require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestGetOrLoad_ServesPreviousVersionAfterInvalidate(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store}
+			require.NoError(t, Set(ctx, namespace, "usd", cachedRates{Code: "USD", Value: 1}))
+			require.NoError(t, namespace.Invalidate(ctx))
+
+			var calls int32
+			load := countingLoader(&calls, cachedRates{Code: "USD", Value: 2}, 0)
+
+			// When
+			value, err := GetOrLoad(ctx, namespace, "usd", load)
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, float64(1), value.Value)
+			require.Eventually(t, func() bool {
+				refreshed, err := Get[cachedRates](ctx, namespace, "usd")
+				return err == nil && refreshed.Value == 2
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestGetOrLoad_WaitsForLockHolder(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given another replica is recomputing the key
+			ctx := context.Background()
+			namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store}
+			storeKey, err := namespace.Key(ctx, "usd")
+			require.NoError(t, err)
+
+			release, acquired, err := store.TryLock(ctx, lockKey(storeKey), time.Minute)
+			require.NoError(t, err)
+			require.True(t, acquired)
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				_ = Set(ctx, namespace, "usd", cachedRates{Code: "USD", Value: 1})
+				release()
+			}()
+
+			var calls int32
+			load := countingLoader(&calls, cachedRates{Code: "USD", Value: 2}, 0)
+
+			// When
+			value, err := GetOrLoad(ctx, namespace, "usd", load)
+
+			// Then
+			require.NoError(t, err)
+			require.Equal(t, float64(1), value.Value)
+			require.Equal(t, int32(0), atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestGetOrLoad_LoadError(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	namespace := &Namespace{Name: "test", TTL: time.Minute, Store: NewMemoryStore()}
+	loadErr := errors.New("database unavailable")
+
+	// When
+	_, err := GetOrLoad(ctx, namespace, "usd", func(context.Context) (cachedRates, error) {
+		return cachedRates{}, loadErr
+	})
+
+	// Then
+	require.ErrorIs(t, err, loadErr)
+	_, err = Get[cachedRates](ctx, namespace, "usd")
+	require.ErrorIs(t, err, ErrMiss)
+}
+
+func TestStore_TryLock(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			// Given
+			ctx := context.Background()
+			release, acquired, err := store.TryLock(ctx, "lock:test", time.Minute)
+			require.NoError(t, err)
+			require.True(t, acquired)
+
+			// When
+			_, acquiredAgain, err := store.TryLock(ctx, "lock:test", time.Minute)
+			require.NoError(t, err)
+			release()
+			_, acquiredAfterRelease, err := store.TryLock(ctx, "lock:test", time.Minute)
+			require.NoError(t, err)
+
+			// Then
+			require.False(t, acquiredAgain)
+			require.True(t, acquiredAfterRelease)
+		})
+	}
+}
+
+func TestGetOrLoad_LocalTier(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	store := NewMemoryStore()
+	namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store, Local: NewLocalCache(16, time.Minute)}
+	var calls int32
+	load := countingLoader(&calls, cachedRates{Code: "USD", Value: 1}, 0)
+	_, err := GetOrLoad(ctx, namespace, "usd", load)
+	require.NoError(t, err)
+
+	// When the store loses the entry
+	storeKey, err := namespace.Key(ctx, "usd")
+	require.NoError(t, err)
+	require.NoError(t, store.Del(ctx, storeKey))
+	value, err := GetOrLoad(ctx, namespace, "usd", load)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, float64(1), value.Value)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestGetOrLoad_StoreUnavailable(t *testing.T) {
+	// Given
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1}))
+	namespace := &Namespace{Name: "test", TTL: time.Minute, Store: store, Local: NewLocalCache(16, time.Minute)}
+	server.Close()
+
+	var calls int32
+	load := countingLoader(&calls, cachedRates{Code: "USD", Value: 1}, 0)
+
+	// When
+	value, err := GetOrLoad(ctx, namespace, "usd", load)
+	require.NoError(t, err)
+	_, err = GetOrLoad(ctx, namespace, "usd", load)
+	require.NoError(t, err)
+
+	// Then
+	require.Equal(t, float64(1), value.Value)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.False(t, storeAvailable(store))
+}
+
+func TestSubscribeInvalidations(t *testing.T) {
+	// Given a value cached locally
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	namespace := NewNamespace("test_subscribe", time.Minute, time.Minute)
+
+	// Subscribing purges every local entry once
+	go SubscribeInvalidations(ctx, client)
+	require.Eventually(t, func() bool {
+		return namespace.Local.Generation() > 0
+	}, time.Second, 10*time.Millisecond)
+
+	namespace.Local.Add(namespace.Local.Generation(), "usd", cachedRates{Code: "USD", Value: 1})
+
+	// When another replica invalidates the namespace
+	require.NoError(t, client.Publish(ctx, InvalidationChannel, namespace.Name).Err())
+
+	// Then
+	require.Eventually(t, func() bool {
+		_, found := getLocal[cachedRates](namespace, namespace.Local.Generation(), "usd")
+		return !found
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// InvalidationChannel carries the name of every namespace invalidated by a replica
+const InvalidationChannel = "cache:invalidations"
+
+var (
+	// LocalSize is the number of entries each namespace keeps in process, 0 disables the local tier
+	LocalSize = config.Int("CACHE_LOCAL_SIZE", 1024)
+	// LocalTTL bounds how long a replica may serve a local entry after missing an invalidation
+	LocalTTL = config.Seconds("CACHE_LOCAL_TTL", 10*time.Second)
+	// StoreRetry is how long the store is bypassed after it fails, instead of waiting on its timeouts
+	StoreRetry = config.Seconds("CACHE_STORE_RETRY", 5*time.Second)
+)
+
+// LocalCache is the in-process tier in front of the store, holding decoded values of hot keys.
+// Values are shared between requests and must not be modified.
+type LocalCache struct {
+	entries *expirable.LRU[string, any]
+	// generation is part of every key, so values loaded before a purge are never read after it
+	generation atomic.Int64
+}
+
+func NewLocalCache(size int, ttl time.Duration) *LocalCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &LocalCache{entries: expirable.NewLRU[string, any](size, nil, ttl)}
+}
+
+// Generation returns the current generation, to be passed back to Get and Add
+func (l *LocalCache) Generation() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.generation.Load()
+}
+
+func (l *LocalCache) key(generation int64, key string) string {
+	return strconv.FormatInt(generation, 10) + ":" + key
+}
+
+func (l *LocalCache) Get(generation int64, key string) (any, bool) {
+	if l == nil {
+		return nil, false
+	}
+	return l.entries.Get(l.key(generation, key))
+}
+
+func (l *LocalCache) Add(generation int64, key string, value any) {
+	if l == nil {
+		return
+	}
+	l.entries.Add(l.key(generation, key), value)
+}
+
+// Purge drops every entry, including those being loaded
+func (l *LocalCache) Purge() {
+	if l == nil {
+		return
+	}
+	l.generation.Add(1)
+	l.entries.Purge()
+}
+
+func getLocal[T any](n *Namespace, generation int64, key string) (T, bool) {
+	cached, found := n.Local.Get(generation, key)
+	if !found {
+		var zero T
+		return zero, false
+	}
+
+	value, ok := cached.(T)
+	return value, ok
+}
+
+// namespaces registers namespaces by name, so invalidations from other replicas can be applied
+var namespaces sync.Map
+
+// PurgeLocal drops the local entries of every namespace
+func PurgeLocal() {
+	namespaces.Range(func(_, value any) bool {
+		value.(*Namespace).Local.Purge()
+		return true
+	})
+}
+
+func purgeLocal(name string) {
+	if namespace, found := namespaces.Load(name); found {
+		namespace.(*Namespace).Local.Purge()
+		namespace.(*Namespace).notify()
+	}
+}
+
+// SubscribeInvalidations purges local entries of namespaces invalidated by other replicas, until ctx is done
+func SubscribeInvalidations(ctx context.Context, client redis.UniversalClient) {
+	pubsub := client.Subscribe(ctx, InvalidationChannel)
+	defer func() {
+		if err := pubsub.Close(); err != nil {
+			log.Printf("Error closing cache invalidation subscription: %s\n", err)
+		}
+	}()
+
+	messages := pubsub.ChannelWithSubscriptions(ctx, 100)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-messages:
+			switch message := message.(type) {
+			case *redis.Subscription:
+				// Invalidations published while we were disconnected are lost, so start over
+				PurgeLocal()
+			case *redis.Message:
+				purgeLocal(message.Payload)
+			}
+		}
+	}
+}
+
+// unavailable holds the time until which a store that failed is bypassed
+var unavailable sync.Map
+
+func storeAvailable(store Store) bool {
+	until, found := unavailable.Load(store)
+	return !found || time.Now().After(until.(time.Time))
+}
+
+// storeFailed bypasses the store for StoreRetry, so reads go straight to the database meanwhile
+func storeFailed(store Store, err error) {
+	log.Printf("Cache unavailable, reading through to the database for %s: %s\n", StoreRetry, err)
+	unavailable.Store(store, time.Now().Add(StoreRetry))
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// Namespace is a family of keys sharing a TTL.
+// Keys embed the namespace version, so bumping it invalidates the whole family in O(1)
+// and the orphaned entries are left to expire.
+type Namespace struct {
+	Name string
+	// TTL is how long entries are fresh, Stale how much longer they may be served while being recomputed
+	TTL   time.Duration
+	Stale time.Duration
+	Store Store
+	// Local is the in-process tier in front of Store, nil to always read the store
+	Local *LocalCache
+	// watchers holds the channels signalled when the namespace is invalidated
+	watchers sync.Map
+}
+
+var (
+	// Latest holds the complete set of current rates
+	Latest = NewNamespace("currency_latest", time.Minute, 5*time.Minute)
+	// History holds rate history for a currency and date range
+	History = NewNamespace("currency_history", time.Hour, time.Hour)
+	// Metadata holds the names and symbols of the currencies
+	Metadata = NewNamespace("currency_metadata", time.Hour, 24*time.Hour)
+)
+
+// NewNamespace creates a namespace whose TTL and stale window can be overridden
+// by CACHE_TTL_<NAME> and CACHE_STALE_<NAME>, in seconds
+func NewNamespace(name string, ttl, stale time.Duration) *Namespace {
+	ttl = config.Seconds("CACHE_TTL_"+strings.ToUpper(name), ttl)
+
+	namespace := &Namespace{
+		Name:  name,
+		TTL:   ttl,
+		Stale: config.Seconds("CACHE_STALE_"+strings.ToUpper(name), stale),
+		Local: NewLocalCache(LocalSize, min(LocalTTL, ttl)),
+	}
+	namespaces.Store(name, namespace)
+
+	return namespace
+}
+
+func (n *Namespace) store() Store {
+	if n.Store != nil {
+		return n.Store
+	}
+	return Default
+}
+
+func (n *Namespace) versionKey() string {
+	return "cache:" + n.Name + ":version"
+}
+
+// Version returns the current version of the namespace
+func (n *Namespace) Version(ctx context.Context) (int64, error) {
+	value, err := n.store().Get(ctx, n.versionKey())
+	if errors.Is(err, ErrMiss) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return decodeCounter(value), nil
+}
+
+// Key returns the store key of key under the current namespace version
+func (n *Namespace) Key(ctx context.Context, key string) (string, error) {
+	version, err := n.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return n.versionedKey(version, key), nil
+}
+
+func (n *Namespace) versionedKey(version int64, key string) string {
+	return fmt.Sprintf("cache:%s:v%d:%s", n.Name, version, key)
+}
+
+// Invalidate drops every key of the namespace by moving to a new version,
+// and tells the other replicas to drop their local entries
+func (n *Namespace) Invalidate(ctx context.Context) error {
+	n.Local.Purge()
+
+	if _, err := n.store().Incr(ctx, n.versionKey()); err != nil {
+		return err
+	}
+	n.notify()
+
+	if publisher, ok := n.store().(Publisher); ok {
+		return publisher.Publish(ctx, InvalidationChannel, n.Name)
+	}
+
+	return nil
+}
+
+// Watch returns a channel signalled after every invalidation of the namespace, by this replica or another one,
+// and a func to stop watching. Signals are coalesced, so a slow reader sees one for several invalidations.
+func (n *Namespace) Watch() (<-chan struct{}, func()) {
+	signal := make(chan struct{}, 1)
+	n.watchers.Store(signal, struct{}{})
+
+	return signal, func() { n.watchers.Delete(signal) }
+}
+
+func (n *Namespace) notify() {
+	n.watchers.Range(func(watcher, _ any) bool {
+		select {
+		case watcher.(chan struct{}) <- struct{}{}:
+		default:
+		}
+		return true
+	})
+}
+
+// entry is a cached value along with the time it stops being fresh
+type entry[T any] struct {
+	Value      T         `json:"value"`
+	FreshUntil time.Time `json:"fresh_until"`
+}
+
+func (e entry[T]) fresh() bool {
+	return time.Now().Before(e.FreshUntil)
+}
+
+func getEntry[T any](ctx context.Context, n *Namespace, storeKey string) (entry[T], error) {
+	var cached entry[T]
+
+	raw, err := n.store().Get(ctx, storeKey)
+	if err != nil {
+		return cached, err
+	}
+
+	// Values cached before entries carried their freshness are treated as missing
+	if err := json.Unmarshal(raw, &cached); err != nil || cached.FreshUntil.IsZero() {
+		return cached, ErrMiss
+	}
+
+	return cached, nil
+}
+
+func setEntry[T any](ctx context.Context, n *Namespace, storeKey string, value T) error {
+	raw, err := json.Marshal(entry[T]{Value: value, FreshUntil: time.Now().Add(n.TTL)})
+	if err != nil {
+		return err
+	}
+
+	return n.store().Set(ctx, storeKey, raw, n.TTL+n.Stale)
+}
+
+// Get returns the fresh value cached under key, or ErrMiss
+func Get[T any](ctx context.Context, n *Namespace, key string) (T, error) {
+	var value T
+
+	storeKey, err := n.Key(ctx, key)
+	if err != nil {
+		return value, err
+	}
+
+	cached, err := getEntry[T](ctx, n, storeKey)
+	if err != nil {
+		return value, err
+	}
+
+	if !cached.fresh() {
+		return value, ErrMiss
+	}
+
+	return cached.Value, nil
+}
+
+// Set caches value under key, fresh for the namespace TTL
+func Set[T any](ctx context.Context, n *Namespace, key string, value T) error {
+	storeKey, err := n.Key(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return setEntry(ctx, n, storeKey, value)
+}
+
+func encodeCounter(counter int64) []byte {
+	return []byte(strconv.FormatInt(counter, 10))
+}
+
+func decodeCounter(value []byte) int64 {
+	counter, _ := strconv.ParseInt(string(value), 10, 64)
+	return counter
+}
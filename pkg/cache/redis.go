@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// Redis deployment modes selected with REDIS_MODE
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// RedisConfig is the Redis connection read from the environment
+type RedisConfig struct {
+	Mode    string
+	Options redis.UniversalOptions
+	// ConnectRetries and ConnectBackoff control the connectivity check at startup, the backoff doubling on every retry
+	ConnectRetries int
+	ConnectBackoff time.Duration
+}
+
+// LoadRedisConfig reads the Redis connection from the environment.
+// REDIS_URL, when set, provides the address, credentials, DB and TLS, and the other variables override it.
+func LoadRedisConfig() (RedisConfig, error) {
+	cfg := RedisConfig{
+		Mode: config.String("REDIS_MODE", ModeStandalone),
+		Options: redis.UniversalOptions{
+			Addrs: []string{"dockerRedis:6379"},
+		},
+		ConnectRetries: config.Int("REDIS_CONNECT_RETRIES", 5),
+		ConnectBackoff: config.Seconds("REDIS_CONNECT_BACKOFF", time.Second),
+	}
+	options := &cfg.Options
+
+	if redisURL := config.String("REDIS_URL", ""); redisURL != "" {
+		parsed, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return cfg, fmt.Errorf("error parsing REDIS_URL: %v", err)
+		}
+
+		options.Addrs = []string{parsed.Addr}
+		options.Username = parsed.Username
+		options.Password = parsed.Password
+		options.DB = parsed.DB
+		options.TLSConfig = parsed.TLSConfig
+	}
+
+	if addrs := config.List("REDIS_ADDRS"); len(addrs) > 0 {
+		options.Addrs = addrs
+	}
+	options.Username = config.String("REDIS_USERNAME", options.Username)
+	options.Password = config.String("REDIS_PASSWORD", options.Password)
+	options.DB = config.Int("REDIS_DB", options.DB)
+
+	options.MasterName = config.String("REDIS_MASTER_NAME", "")
+	options.SentinelUsername = config.String("REDIS_SENTINEL_USERNAME", "")
+	options.SentinelPassword = config.String("REDIS_SENTINEL_PASSWORD", "")
+
+	options.PoolSize = config.Int("REDIS_POOL_SIZE", 0)
+	options.MinIdleConns = config.Int("REDIS_MIN_IDLE_CONNS", 0)
+	options.DialTimeout = config.Seconds("REDIS_DIAL_TIMEOUT", 5*time.Second)
+	options.ReadTimeout = config.Seconds("REDIS_READ_TIMEOUT", 3*time.Second)
+	options.WriteTimeout = config.Seconds("REDIS_WRITE_TIMEOUT", 3*time.Second)
+	options.PoolTimeout = config.Seconds("REDIS_POOL_TIMEOUT", 4*time.Second)
+
+	tlsConfig, err := loadRedisTLS(options.TLSConfig)
+	if err != nil {
+		return cfg, err
+	}
+	options.TLSConfig = tlsConfig
+
+	switch cfg.Mode {
+	case ModeStandalone, ModeCluster:
+	case ModeSentinel:
+		if options.MasterName == "" {
+			return cfg, fmt.Errorf("REDIS_MASTER_NAME is required in %s mode", ModeSentinel)
+		}
+	default:
+		return cfg, fmt.Errorf("unknown REDIS_MODE %q", cfg.Mode)
+	}
+
+	return cfg, nil
+}
+
+// loadRedisTLS enables TLS when REDIS_TLS is set, trusting REDIS_TLS_CA_FILE on top of the system roots
+func loadRedisTLS(tlsConfig *tls.Config) (*tls.Config, error) {
+	if !config.Bool("REDIS_TLS", tlsConfig != nil) {
+		return nil, nil
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	tlsConfig.ServerName = config.String("REDIS_TLS_SERVER_NAME", tlsConfig.ServerName)
+
+	if caFile := config.String("REDIS_TLS_CA_FILE", ""); caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading REDIS_TLS_CA_FILE: %v", err)
+		}
+
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in REDIS_TLS_CA_FILE %s", caFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	return tlsConfig, nil
+}
+
+// NewClient creates the client for the configured mode
+func (c RedisConfig) NewClient() redis.UniversalClient {
+	switch c.Mode {
+	case ModeSentinel:
+		return redis.NewFailoverClient(c.Options.Failover())
+	case ModeCluster:
+		return redis.NewClusterClient(c.Options.Cluster())
+	default:
+		return redis.NewClient(c.Options.Simple())
+	}
+}
+
+// Ping checks client can reach Redis, retrying with exponential backoff
+func (c RedisConfig) Ping(ctx context.Context, client redis.UniversalClient) error {
+	backoff := c.ConnectBackoff
+
+	var err error
+	for attempt := 0; attempt <= c.ConnectRetries; attempt++ {
+		if err = client.Ping(ctx).Err(); err == nil {
+			return nil
+		}
+
+		if attempt < c.ConnectRetries {
+			log.Printf("Redis is not reachable, retrying in %s: %s\n", backoff, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrMiss is returned when a key is not cached
+var ErrMiss = errors.New("cache miss")
+
+// Store is a key/value backend for cached values
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Incr(ctx context.Context, key string) (int64, error)
+	Del(ctx context.Context, keys ...string) error
+	// TryLock takes the lock key for ttl unless it is already held.
+	// The returned release func frees it, as long as it has not expired and been taken by someone else.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (release func(), acquired bool, err error)
+}
+
+// Publisher is implemented by stores shared between replicas, to notify them of invalidations
+type Publisher interface {
+	Publish(ctx context.Context, channel, message string) error
+}
+
+// Default is the store used by namespaces without their own
+var Default Store
+
+// RedisStore keeps cached values in Redis, shared by every replica
+type RedisStore struct {
+	Client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{Client: client}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.Client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrMiss
+	}
+
+	return value, err
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.Client.Set(ctx, key, value, ttl).Err()
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string) (int64, error) {
+	return s.Client.Incr(ctx, key).Result()
+}
+
+func (s *RedisStore) Del(ctx context.Context, keys ...string) error {
+	return s.Client.Del(ctx, keys...).Err()
+}
+
+func (s *RedisStore) Publish(ctx context.Context, channel, message string) error {
+	return s.Client.Publish(ctx, channel, message).Err()
+}
+
+// unlock deletes a lock only while it still holds the token of its owner
+var unlock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *RedisStore) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	token, err := lockToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	acquired, err := s.Client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+
+	release := func() {
+		if err := unlock.Run(context.Background(), s.Client, []string{key}, token).Err(); err != nil {
+			log.Printf("Error releasing cache lock %s: %s\n", key, err)
+		}
+	}
+
+	return release, true, nil
+}
+
+func lockToken() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(raw), nil
+}
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// MemoryStore keeps cached values in process, for tests and single instance deployments
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	sets    int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.entries[key]
+	if !found {
+		return nil, ErrMiss
+	}
+
+	if entry.expired(time.Now()) {
+		delete(s.entries, key)
+		return nil, ErrMiss
+	}
+
+	return entry.value, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	s.entries[key] = entry
+
+	// Sweep expired entries every now and then so keys that are never read again don't pile up
+	s.sets++
+	if s.sets%1024 == 0 {
+		now := time.Now()
+		for key, entry := range s.entries {
+			if entry.expired(now) {
+				delete(s.entries, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Incr(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var counter int64
+	if entry, found := s.entries[key]; found && !entry.expired(time.Now()) {
+		counter = decodeCounter(entry.value)
+	}
+	counter++
+
+	s.entries[key] = memoryEntry{value: encodeCounter(counter)}
+	return counter, nil
+}
+
+func (s *MemoryStore) Del(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) TryLock(_ context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	token, err := lockToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, found := s.entries[key]; found && !entry.expired(time.Now()) {
+		return nil, false, nil
+	}
+	s.entries[key] = memoryEntry{value: []byte(token), expiresAt: time.Now().Add(ttl)}
+
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if entry, found := s.entries[key]; found && string(entry.value) == token {
+			delete(s.entries, key)
+		}
+	}
+
+	return release, true, nil
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// String returns the value of the environment variable key, or def when it is unset.
+func String(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+
+	return def
+}
+
+// Int returns the environment variable key parsed as an integer, or def when it is unset or invalid.
+func Int(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Error parsing %s: %s, using default %d\n", key, err, def)
+		return def
+	}
+
+	return parsed
+}
+
+// Bool returns the environment variable key parsed as a boolean, or def when it is unset or invalid.
+func Bool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Error parsing %s: %s, using default %t\n", key, err, def)
+		return def
+	}
+
+	return parsed
+}
+
+// Seconds returns the environment variable key, expressed in whole seconds, as a duration.
+func Seconds(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Error parsing %s: %s, using default %s\n", key, err, def)
+		return def
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// List returns the comma separated values of the environment variable key, without blanks.
+func List(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daemon/schedule"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
 )
@@ -113,6 +114,18 @@ func getCurrencyData() (models.CurrencyAPIResponse, error) {
 func insertCurrencies(currencyResponse models.CurrencyAPIResponse) error {
 	db := database.DB
 
+	if err := database.EnsureCurrencyPartitions(db, currencyResponse.Meta.LastUpdatedAt); err != nil {
+		return err
+	}
+
+	codes := make([]string, 0, len(currencyResponse.Data))
+	for code := range currencyResponse.Data {
+		codes = append(codes, code)
+	}
+	if err := database.EnsureCurrencies(db, codes); err != nil {
+		return err
+	}
+
 	tx := db.Begin()
 	if tx.Error != nil {
 		return fmt.Errorf("error starting database transaction: %v", tx.Error)
@@ -126,7 +139,6 @@ func insertCurrencies(currencyResponse models.CurrencyAPIResponse) error {
 
 	for code, data := range currencyResponse.Data {
 		currency := models.Currency{
-			Name:      data.Code,
 			Code:      code,
 			Value:     data.Value,
 			CreatedAt: currencyResponse.Meta.LastUpdatedAt,
@@ -149,17 +161,11 @@ func insertCurrencies(currencyResponse models.CurrencyAPIResponse) error {
 	return nil
 }
 
-// invalidateCache deletes cached currency data.
+// invalidateCache drops cached currency data by moving its namespaces to a new version.
 func invalidateCache() error {
-	keysPattern := "currency_*"
-	keys, err := cache.Rdb.Keys(cache.Ctx, keysPattern).Result()
-	if err != nil {
-		return fmt.Errorf("error retrieving cache keys: %v", err)
-	}
-
-	for _, key := range keys {
-		if err := cache.Rdb.Del(cache.Ctx, key).Err(); err != nil {
-			return fmt.Errorf("error deleting cache key %s: %v", key, err)
+	for _, namespace := range []*cache.Namespace{cache.Latest, cache.History} {
+		if err := namespace.Invalidate(cache.Ctx); err != nil {
+			return fmt.Errorf("error invalidating %s cache: %v", namespace.Name, err)
 		}
 	}
 
@@ -189,10 +195,14 @@ func InitDaemon() {
 		return
 	}
 
-	ticker := time.NewTicker(time.Duration(wakeup) * time.Second)
+	interval := time.Duration(wakeup) * time.Second
+	ticker := time.NewTicker(interval)
+	schedule.SetNextRun(time.Now().Add(interval))
 
 	go func() {
 		for range ticker.C {
+			schedule.SetNextRun(time.Now().Add(interval))
+
 			currencyResponse, err := getCurrencyData()
 			if err != nil {
 				log.Printf("Error getting currency data: %s\n", err)
@@ -0,0 +1,30 @@
+// Package schedule shares when the daemon fetches rates next with the rest of the API,
+// without importing the daemon itself.
+package schedule
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+var nextRun atomic.Int64
+
+// SetNextRun records when the daemon is expected to fetch rates next
+func SetNextRun(next time.Time) {
+	nextRun.Store(next.UnixNano())
+}
+
+// UntilNextRun returns how long until the next daemon run, and false when it is not known
+func UntilNextRun() (time.Duration, bool) {
+	next := nextRun.Load()
+	if next == 0 {
+		return 0, false
+	}
+
+	until := time.Until(time.Unix(0, next))
+	if until < 0 {
+		return 0, false
+	}
+
+	return until, true
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// Config is the database connection read from the environment
+type Config struct {
+	Host     string
+	Port     string
+	Name     string
+	User     string
+	Password string
+	// SSLMode is the libpq sslmode, the certificate paths are only needed for verify-ca, verify-full or client certificates
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// ReplicaHosts are read replicas, as host or host:port, serving currency reads
+	ReplicaHosts []string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// ConnectRetries and ConnectBackoff control connecting at startup, the backoff doubling on every retry
+	ConnectRetries int
+	ConnectBackoff time.Duration
+}
+
+// LoadConfig reads the database connection from the environment
+func LoadConfig() Config {
+	return Config{
+		Host:         config.String("POSTGRES_HOST", "localhost"),
+		Port:         config.String("POSTGRES_PORT", "5432"),
+		Name:         config.String("POSTGRES_DB", ""),
+		User:         config.String("POSTGRES_USER", ""),
+		Password:     config.String("POSTGRES_PASSWORD", ""),
+		SSLMode:      config.String("POSTGRES_SSLMODE", "prefer"),
+		SSLRootCert:  config.String("POSTGRES_SSLROOTCERT", ""),
+		SSLCert:      config.String("POSTGRES_SSLCERT", ""),
+		SSLKey:       config.String("POSTGRES_SSLKEY", ""),
+		ReplicaHosts: config.List("POSTGRES_REPLICA_HOSTS"),
+
+		MaxOpenConns:    config.Int("POSTGRES_MAX_OPEN_CONNS", 25),
+		MaxIdleConns:    config.Int("POSTGRES_MAX_IDLE_CONNS", 10),
+		ConnMaxLifetime: config.Seconds("POSTGRES_CONN_MAX_LIFETIME", 30*time.Minute),
+		ConnMaxIdleTime: config.Seconds("POSTGRES_CONN_MAX_IDLE_TIME", 5*time.Minute),
+
+		ConnectRetries: config.Int("POSTGRES_CONNECT_RETRIES", 5),
+		ConnectBackoff: config.Seconds("POSTGRES_CONNECT_BACKOFF", time.Second),
+	}
+}
+
+// DSN returns the connection URL of the primary
+func (c Config) DSN() string {
+	return c.dsn(net.JoinHostPort(c.Host, c.Port))
+}
+
+// ReplicaDSNs returns the connection URLs of the read replicas, which default to the primary port
+func (c Config) ReplicaDSNs() []string {
+	dsns := make([]string, len(c.ReplicaHosts))
+	for i, host := range c.ReplicaHosts {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, c.Port)
+		}
+		dsns[i] = c.dsn(host)
+	}
+
+	return dsns
+}
+
+func (c Config) dsn(host string) string {
+	query := url.Values{"sslmode": {c.SSLMode}}
+	for key, value := range map[string]string{"sslrootcert": c.SSLRootCert, "sslcert": c.SSLCert, "sslkey": c.SSLKey} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     host,
+		Path:     "/" + c.Name,
+		RawQuery: query.Encode(),
+	}
+
+	return dsn.String()
+}
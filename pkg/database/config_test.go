@@ -0,0 +1,52 @@
+package database
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_DSN(t *testing.T) {
+	// Given
+	t.Setenv("POSTGRES_HOST", "db.example.com")
+	t.Setenv("POSTGRES_PORT", "5433")
+	t.Setenv("POSTGRES_DB", "boletia_currency")
+	t.Setenv("POSTGRES_USER", "app")
+	t.Setenv("POSTGRES_PASSWORD", "p@ss/word")
+	t.Setenv("POSTGRES_SSLMODE", "verify-full")
+	t.Setenv("POSTGRES_SSLROOTCERT", "/etc/ssl/rds-ca.pem")
+
+	// When
+	dsn, err := url.Parse(LoadConfig().DSN())
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "db.example.com:5433", dsn.Host)
+	require.Equal(t, "/boletia_currency", dsn.Path)
+	password, _ := dsn.User.Password()
+	require.Equal(t, "p@ss/word", password)
+	require.Equal(t, "verify-full", dsn.Query().Get("sslmode"))
+	require.Equal(t, "/etc/ssl/rds-ca.pem", dsn.Query().Get("sslrootcert"))
+	require.False(t, dsn.Query().Has("sslcert"))
+}
+
+func TestConfig_ReplicaDSNs(t *testing.T) {
+	// Given
+	t.Setenv("POSTGRES_PORT", "5433")
+	t.Setenv("POSTGRES_REPLICA_HOSTS", "replica-1, replica-2:6432")
+
+	// When
+	dsns := LoadConfig().ReplicaDSNs()
+
+	// Then
+	require.Len(t, dsns, 2)
+
+	first, err := url.Parse(dsns[0])
+	require.NoError(t, err)
+	require.Equal(t, "replica-1:5433", first.Host)
+
+	second, err := url.Parse(dsns[1])
+	require.NoError(t, err)
+	require.Equal(t, "replica-2:6432", second.Host)
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// isoCurrencies is the ISO 4217 list the currencies table is seeded from
+//
+//go:embed seed/iso4217.json
+var isoCurrencies []byte
+
+// currencyRateTables reference the currencies table by code
+var currencyRateTables = []string{"currency", "currency_hourly", "currency_daily"}
+
+// migrateCurrencyMetadata creates the currencies table and seeds it from the ISO 4217 list
+func migrateCurrencyMetadata(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.CurrencyMetadata{}); err != nil {
+		return err
+	}
+
+	return seedCurrencies(db)
+}
+
+// seedCurrencies adds the ISO 4217 currencies. Names and symbols edited since are kept,
+// only the placeholders of currencies the daemon met before they were seeded are replaced.
+func seedCurrencies(db *gorm.DB) error {
+	var currencies []models.CurrencyMetadata
+	if err := json.Unmarshal(isoCurrencies, &currencies); err != nil {
+		return fmt.Errorf("error decoding the ISO 4217 list: %v", err)
+	}
+	for i := range currencies {
+		currencies[i].Active = true
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "minor_units", "symbol"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: `"currencies"."name" = "currencies"."code"`}}},
+	}).Create(&currencies).Error
+}
+
+// migrateCurrencyCodes moves the rate tables from the former name column to a code referencing the currencies table
+func migrateCurrencyCodes(db *gorm.DB) error {
+	// Samples stored the code in both name and code
+	if err := db.Exec(`ALTER TABLE currency DROP COLUMN IF EXISTS name`).Error; err != nil {
+		return err
+	}
+
+	migrator := db.Migrator()
+	for _, table := range currencyRateTables[1:] {
+		if migrator.HasTable(table) && migrator.HasColumn(table, "name") {
+			if err := migrator.RenameColumn(table, "name", "code"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := db.AutoMigrate(&models.CurrencyHourly{}, &models.CurrencyDaily{}); err != nil {
+		return err
+	}
+
+	for _, table := range currencyRateTables {
+		// Codes stored before the currencies table existed get a placeholder, so they can be referenced
+		if err := db.Exec(fmt.Sprintf(`INSERT INTO currencies (code, name) SELECT DISTINCT code, code FROM %s ON CONFLICT (code) DO NOTHING`, table)).Error; err != nil {
+			return err
+		}
+
+		constraint := "fk_" + table + "_code"
+		var exists bool
+		if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = ?)`, constraint).Scan(&exists).Error; err != nil {
+			return err
+		}
+		if !exists {
+			if err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (code) REFERENCES currencies (code)`, table, constraint)).Error; err != nil {
+				return fmt.Errorf("error referencing currencies from %s: %v", table, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// knownCurrencies holds the codes known to be in the currencies table, so they are only checked once per process
+var knownCurrencies sync.Map
+
+// EnsureCurrencies adds the codes missing from the currencies table, named after their code,
+// so rates of currencies outside the ISO 4217 list can be stored
+func EnsureCurrencies(db *gorm.DB, codes []string) error {
+	var missing []models.CurrencyMetadata
+	for _, code := range codes {
+		if _, known := knownCurrencies.Load(code); !known {
+			missing = append(missing, models.CurrencyMetadata{Code: code, Name: code, MinorUnits: 2, Active: true})
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&missing).Error; err != nil {
+		return fmt.Errorf("error adding currencies: %v", err)
+	}
+
+	for _, currency := range missing {
+		knownCurrencies.Store(currency.Code, true)
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+)
+
+func TestISOCurrencies(t *testing.T) {
+	// When
+	var currencies []models.CurrencyMetadata
+	err := json.Unmarshal(isoCurrencies, &currencies)
+
+	// Then
+	require.NoError(t, err)
+	codes := map[string]models.CurrencyMetadata{}
+	for _, currency := range currencies {
+		require.Len(t, currency.Code, 3)
+		require.NotEmpty(t, currency.Name)
+		require.NotContains(t, codes, currency.Code)
+		codes[currency.Code] = currency
+	}
+	require.Equal(t, 0, codes["JPY"].MinorUnits)
+	require.Equal(t, 3, codes["KWD"].MinorUnits)
+	require.Equal(t, "€", codes["EUR"].Symbol)
+}
+
+func TestSeedCurrencies_KeepsEditedNames(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT INTO "currencies" (.+) ON CONFLICT \("code"\) DO UPDATE SET (.+) WHERE "currencies"."name" = "currencies"."code"`).
+		WillReturnResult(sqlmock.NewResult(0, 156))
+	dbMock.ExpectCommit()
+
+	// When
+	err := seedCurrencies(gormDB)
+
+	// Then
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestEnsureCurrencies(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT INTO "currencies" (.+) ON CONFLICT DO NOTHING`).
+		WithArgs("BTC", "BTC", 2, "", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	require.NoError(t, EnsureCurrencies(gormDB, []string{"BTC"}))
+	require.NoError(t, EnsureCurrencies(gormDB, []string{"BTC"}))
+
+	// Then the currency is only added once
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
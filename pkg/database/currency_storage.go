@@ -0,0 +1,278 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Layouts of the currency table, selected with CURRENCY_STORAGE
+const (
+	StoragePlain       = "plain"
+	StoragePartitioned = "partitioned"
+	StorageTimescale   = "timescale"
+)
+
+// CurrencyStorage is the layout of the currency table, set when connecting
+var CurrencyStorage = StoragePlain
+
+// createCurrencyTable matches models.Currency. Partitioned tables and hypertables
+// need created_at in the primary key, so the table is not created by AutoMigrate.
+const createCurrencyTable = `CREATE TABLE IF NOT EXISTS currency (
+	id bigint GENERATED BY DEFAULT AS IDENTITY,
+	code text NOT NULL,
+	value decimal NOT NULL,
+	created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id, created_at)
+)`
+
+// currencyIndexes serve the history of a currency, and the time of the latest rates without scanning every partition
+var currencyIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_currency_code_created_at ON currency (code, created_at)`,
+	`CREATE INDEX IF NOT EXISTS idx_currency_created_at ON currency (created_at)`,
+}
+
+func createCurrencyIndexes(db *gorm.DB) error {
+	for _, index := range currencyIndexes {
+		if err := db.Exec(index).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateCurrency creates or upgrades the currency table for the layout in CURRENCY_STORAGE,
+// returning the layout actually in use.
+func migrateCurrency(db *gorm.DB) (string, error) {
+	storage := config.String("CURRENCY_STORAGE", StoragePartitioned)
+
+	switch storage {
+	case StoragePlain:
+		return StoragePlain, db.AutoMigrate(&models.Currency{})
+	case StoragePartitioned:
+		return migratePartitionedCurrency(db)
+	case StorageTimescale:
+		return StorageTimescale, migrateTimescaleCurrency(db)
+	default:
+		return "", fmt.Errorf("unknown CURRENCY_STORAGE %q", storage)
+	}
+}
+
+// currencyTableKind returns the relkind of the currency table: r for a plain table, p for a partitioned one,
+// or an empty string when it does not exist
+func currencyTableKind(db *gorm.DB) (string, error) {
+	var kind string
+	err := db.Raw(`SELECT c.relkind::text FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relname = 'currency' AND n.nspname = current_schema()`).Scan(&kind).Error
+
+	return kind, err
+}
+
+func migratePartitionedCurrency(db *gorm.DB) (string, error) {
+	kind, err := currencyTableKind(db)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind {
+	case "":
+		if err := db.Exec(createCurrencyTable + " PARTITION BY RANGE (created_at)").Error; err != nil {
+			return "", err
+		}
+	case "r":
+		// Copying the history can take long, so it only happens when asked for
+		if !config.Bool("CURRENCY_MIGRATE_LEGACY", false) {
+			log.Println("The currency table is not partitioned, set CURRENCY_MIGRATE_LEGACY=true to convert it")
+			return StoragePlain, db.AutoMigrate(&models.Currency{})
+		}
+		if err := db.Transaction(convertLegacyCurrency); err != nil {
+			return "", fmt.Errorf("error partitioning the currency table: %v", err)
+		}
+	}
+
+	if err := createCurrencyIndexes(db); err != nil {
+		return "", err
+	}
+
+	CurrencyStorage = StoragePartitioned
+	return StoragePartitioned, EnsureCurrencyPartitions(db, time.Now())
+}
+
+// convertLegacyCurrency moves the rows of a plain currency table into a new partitioned one.
+// The plain table is kept as currency_legacy, to be dropped once the conversion is checked.
+func convertLegacyCurrency(tx *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE currency RENAME TO currency_legacy`,
+		`ALTER TABLE currency_legacy RENAME CONSTRAINT currency_pkey TO currency_legacy_pkey`,
+		`ALTER INDEX IF EXISTS idx_currency_name RENAME TO idx_currency_legacy_name`,
+		`ALTER INDEX IF EXISTS idx_currency_name_created_at RENAME TO idx_currency_legacy_name_created_at`,
+		`ALTER INDEX IF EXISTS idx_currency_code_created_at RENAME TO idx_currency_legacy_code_created_at`,
+		`ALTER INDEX IF EXISTS idx_currency_created_at RENAME TO idx_currency_legacy_created_at`,
+		createCurrencyTable + " PARTITION BY RANGE (created_at)",
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	var bounds struct {
+		First *time.Time
+		Last  *time.Time
+	}
+	if err := tx.Raw(`SELECT MIN(created_at) AS first, MAX(created_at) AS last FROM currency_legacy`).Scan(&bounds).Error; err != nil {
+		return err
+	}
+
+	if bounds.First != nil && bounds.Last != nil {
+		for month := monthStart(*bounds.First); !month.After(*bounds.Last); month = month.AddDate(0, 1, 0) {
+			if err := createCurrencyPartition(tx, month); err != nil {
+				return err
+			}
+		}
+	}
+
+	statements = []string{
+		`INSERT INTO currency (id, code, value, created_at) SELECT id, code, value, created_at FROM currency_legacy`,
+		`SELECT setval(pg_get_serial_sequence('currency', 'id'), COALESCE((SELECT MAX(id) FROM currency), 0) + 1, false)`,
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migrateTimescaleCurrency(db *gorm.DB) error {
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS timescaledb`).Error; err != nil {
+		return fmt.Errorf("error enabling TimescaleDB: %v", err)
+	}
+
+	var hypertables int64
+	if err := db.Raw(`SELECT COUNT(*) FROM timescaledb_information.hypertables WHERE hypertable_name = 'currency'`).
+		Scan(&hypertables).Error; err != nil {
+		return err
+	}
+
+	if hypertables == 0 {
+		kind, err := currencyTableKind(db)
+		if err != nil {
+			return err
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			statements := []string{createCurrencyTable}
+			if kind == "r" {
+				// Hypertables need the time column in every unique index
+				statements = []string{`ALTER TABLE currency DROP CONSTRAINT currency_pkey, ADD PRIMARY KEY (id, created_at)`}
+			}
+
+			interval := config.Seconds("CURRENCY_CHUNK_INTERVAL", 7*24*time.Hour)
+			statements = append(statements, fmt.Sprintf(
+				`SELECT create_hypertable('currency', 'created_at', chunk_time_interval => INTERVAL '%d seconds', migrate_data => true)`,
+				int64(interval.Seconds())))
+
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error creating the currency hypertable: %v", err)
+		}
+	}
+
+	CurrencyStorage = StorageTimescale
+	return createCurrencyIndexes(db)
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func currencyPartitionName(month time.Time) string {
+	return fmt.Sprintf("currency_y%04dm%02d", month.Year(), month.Month())
+}
+
+func createCurrencyPartition(db *gorm.DB, month time.Time) error {
+	from := monthStart(month)
+	to := from.AddDate(0, 1, 0)
+
+	return db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF currency FOR VALUES FROM ('%s') TO ('%s')`,
+		currencyPartitionName(from), from.Format(time.DateOnly), to.Format(time.DateOnly))).Error
+}
+
+// ensuredPartitions holds the months whose partition exists, so they are only created once per process
+var ensuredPartitions sync.Map
+
+// EnsureCurrencyPartitions creates the partitions of the currency table for the month of at and the next one,
+// so rows stored at at, and until the month after, have somewhere to go. It does nothing unless the table is partitioned.
+func EnsureCurrencyPartitions(db *gorm.DB, at time.Time) error {
+	if CurrencyStorage != StoragePartitioned {
+		return nil
+	}
+
+	month := monthStart(at)
+	for _, month := range []time.Time{month, month.AddDate(0, 1, 0)} {
+		name := currencyPartitionName(month)
+		if _, ensured := ensuredPartitions.Load(name); ensured {
+			continue
+		}
+
+		if err := createCurrencyPartition(db, month); err != nil {
+			return fmt.Errorf("error creating partition %s: %v", name, err)
+		}
+		ensuredPartitions.Store(name, true)
+	}
+
+	return nil
+}
+
+// DropCurrencyPartitions drops the partitions, or hypertable chunks, of the currency table holding only rows older
+// than before. It does nothing on a plain table.
+func DropCurrencyPartitions(db *gorm.DB, before time.Time) error {
+	switch CurrencyStorage {
+	case StorageTimescale:
+		return db.Exec(`SELECT drop_chunks('currency', older_than => ?::timestamp)`, before).Error
+	case StoragePartitioned:
+	default:
+		return nil
+	}
+
+	var partitions []string
+	if err := db.Raw(`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'currency'::regclass`).Scan(&partitions).Error; err != nil {
+		return err
+	}
+
+	for _, name := range partitions {
+		var year, month int
+		if _, err := fmt.Sscanf(name, "currency_y%04dm%02d", &year, &month); err != nil {
+			continue
+		}
+
+		// Partitions hold a month, up to the start of the next one
+		end := time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)
+		if end.After(before) {
+			continue
+		}
+
+		if err := db.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS %s`, name)).Error; err != nil {
+			return fmt.Errorf("error dropping partition %s: %v", name, err)
+		}
+		ensuredPartitions.Delete(name)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func expectPartition(dbMock sqlmock.Sqlmock, name, from, to string) {
+	dbMock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS ` + name + ` PARTITION OF currency FOR VALUES FROM ('` + from + `') TO ('` + to + `')`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestEnsureCurrencyPartitions(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	CurrencyStorage = StoragePartitioned
+	t.Cleanup(func() { CurrencyStorage = StoragePlain })
+
+	expectPartition(dbMock, "currency_y2030m12", "2030-12-01", "2031-01-01")
+	expectPartition(dbMock, "currency_y2031m01", "2031-01-01", "2031-02-01")
+
+	// When
+	at := time.Date(2030, 12, 31, 23, 59, 59, 0, time.UTC)
+	require.NoError(t, EnsureCurrencyPartitions(gormDB, at))
+	require.NoError(t, EnsureCurrencyPartitions(gormDB, at))
+
+	// Then the partitions are only created once
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestEnsureCurrencyPartitions_NotPartitioned(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	// When
+	err := EnsureCurrencyPartitions(gormDB, time.Now())
+
+	// Then
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestMigrateCurrency_CreatesPartitionedTable(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	t.Cleanup(func() { CurrencyStorage = StoragePlain })
+
+	now := monthStart(time.Now())
+	next := now.AddDate(0, 1, 0)
+
+	dbMock.ExpectQuery(`SELECT c.relkind::text FROM pg_class c`).
+		WillReturnRows(sqlmock.NewRows([]string{"relkind"}))
+	dbMock.ExpectExec(`CREATE TABLE IF NOT EXISTS currency \((.+)\) PARTITION BY RANGE \(created_at\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, index := range currencyIndexes {
+		dbMock.ExpectExec(regexp.QuoteMeta(index)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	expectPartition(dbMock, currencyPartitionName(now), now.Format(time.DateOnly), next.Format(time.DateOnly))
+	expectPartition(dbMock, currencyPartitionName(next), next.Format(time.DateOnly), next.AddDate(0, 1, 0).Format(time.DateOnly))
+
+	// When
+	storage, err := migrateCurrency(gormDB)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, StoragePartitioned, storage)
+	require.Equal(t, StoragePartitioned, CurrencyStorage)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestDropCurrencyPartitions(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	CurrencyStorage = StoragePartitioned
+	t.Cleanup(func() { CurrencyStorage = StoragePlain })
+
+	dbMock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("currency_y2024m03").
+			AddRow("currency_y2024m04").
+			AddRow("currency_y2024m05"))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DROP TABLE IF EXISTS currency_y2024m03`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// When
+	err := DropCurrencyPartitions(gormDB, time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC))
+
+	// Then only the partitions ending before the cutoff are dropped
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestDropCurrencyPartitions_Timescale(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	CurrencyStorage = StorageTimescale
+	t.Cleanup(func() { CurrencyStorage = StoragePlain })
+
+	before := time.Date(2024, 4, 20, 0, 0, 0, 0, time.UTC)
+	dbMock.ExpectExec(regexp.QuoteMeta(`SELECT drop_chunks('currency', older_than => $1::timestamp)`)).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// When
+	err := DropCurrencyPartitions(gormDB, before)
+
+	// Then the chunks are dropped by TimescaleDB
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -1,38 +1,27 @@
 package database
 
 import (
-	"fmt"
 	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
 	"log"
-	"os"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
 var DB *gorm.DB
 
 func ConnectDatabase() {
-	var database *gorm.DB
-	var err error
+	cfg := LoadConfig()
 
-	db_hostname := os.Getenv("POSTGRES_HOST")
-	db_name := os.Getenv("POSTGRES_DB")
-	db_user := os.Getenv("POSTGRES_USER")
-	db_pass := os.Getenv("POSTGRES_PASSWORD")
-	db_port := os.Getenv("POSTGRES_PORT")
-
-	dbURl := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", db_user, db_pass, db_hostname, db_port, db_name)
+	database, err := open(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database after %d attempts: %v", cfg.ConnectRetries+1, err)
+	}
 
-	for i := 1; i <= 3; i++ {
-		database, err = gorm.Open(postgres.Open(dbURl), &gorm.Config{})
-		if err == nil {
-			break
-		} else {
-			log.Printf("Attempt %d: Failed to initialize database. Retrying...", i)
-			time.Sleep(3 * time.Second)
-		}
+	if err := configurePool(cfg, database); err != nil {
+		log.Fatalf("Failed to configure database pool: %v", err)
 	}
 
 	err = database.AutoMigrate(&models.RequestHistory{})
@@ -41,11 +30,24 @@ func ConnectDatabase() {
 		return
 	}
 
-	err = database.AutoMigrate(&models.Currency{})
+	err = migrateCurrencyMetadata(database)
+	if err != nil {
+		log.Printf("Failed to migrate currencies table: %v", err)
+		return
+	}
+
+	storage, err := migrateCurrency(database)
 	if err != nil {
 		log.Printf("Failed to migrate currency table: %v", err)
 		return
 	}
+	log.Printf("Currency history is stored in a %s table", storage)
+
+	err = migrateCurrencyCodes(database)
+	if err != nil {
+		log.Printf("Failed to migrate currency codes: %v", err)
+		return
+	}
 
 	err = database.AutoMigrate(&models.User{})
 	if err != nil {
@@ -53,5 +55,77 @@ func ConnectDatabase() {
 		return
 	}
 
+	err = database.AutoMigrate(&models.RecoveryCode{})
+	if err != nil {
+		log.Printf("Failed to migrate recovery code table: %v", err)
+		return
+	}
+
+	err = database.AutoMigrate(&models.AuditLog{})
+	if err != nil {
+		log.Printf("Failed to migrate audit log table: %v", err)
+		return
+	}
+
+	err = migrateExportJob(database)
+	if err != nil {
+		log.Printf("Failed to migrate export job table: %v", err)
+		return
+	}
+
+	// Currency reads go to the replicas, writes and transactions stay on the primary
+	if replicas := cfg.ReplicaDSNs(); len(replicas) > 0 {
+		dialectors := make([]gorm.Dialector, len(replicas))
+		for i, dsn := range replicas {
+			dialectors[i] = postgres.Open(dsn)
+		}
+
+		resolver := dbresolver.Register(dbresolver.Config{Replicas: dialectors, Policy: dbresolver.RandomPolicy{}},
+			&models.Currency{}, &models.CurrencyHourly{}, &models.CurrencyDaily{}).
+			SetMaxOpenConns(cfg.MaxOpenConns).
+			SetMaxIdleConns(cfg.MaxIdleConns).
+			SetConnMaxLifetime(cfg.ConnMaxLifetime).
+			SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+		if err := database.Use(resolver); err != nil {
+			log.Fatalf("Failed to connect to database replicas: %v", err)
+		}
+	}
+
 	DB = database
 }
+
+// open connects to the primary, retrying with exponential backoff
+func open(cfg Config) (*gorm.DB, error) {
+	backoff := cfg.ConnectBackoff
+
+	var database *gorm.DB
+	var err error
+	for attempt := 1; attempt <= cfg.ConnectRetries+1; attempt++ {
+		database, err = gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{TranslateError: true})
+		if err == nil {
+			return database, nil
+		}
+
+		if attempt <= cfg.ConnectRetries {
+			log.Printf("Attempt %d: Failed to initialize database, retrying in %s: %v", attempt, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return nil, err
+}
+
+func configurePool(cfg Config, database *gorm.DB) error {
+	sqlDB, err := database.DB()
+	if err != nil {
+		return err
+	}
+
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"gorm.io/gorm"
+)
+
+// migrateExportJob creates the export_job table, moving jobs owned by username to the ID of their user
+func migrateExportJob(db *gorm.DB) error {
+	var owners int64
+	err := db.Raw(`SELECT COUNT(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'export_job' AND column_name = 'username'`).Scan(&owners).Error
+	if err != nil {
+		return err
+	}
+
+	if owners > 0 {
+		if err := db.Transaction(convertExportJobOwners); err != nil {
+			return fmt.Errorf("error moving export jobs to user IDs: %v", err)
+		}
+	}
+
+	return db.AutoMigrate(&models.ExportJob{})
+}
+
+// convertExportJobOwners sets the user ID of every job from its username. The jobs of users that no longer exist
+// are expired, so their files are deleted with them.
+func convertExportJobOwners(tx *gorm.DB) error {
+	statements := []string{
+		`ALTER TABLE export_job ADD COLUMN IF NOT EXISTS user_id bigint`,
+		`UPDATE export_job j SET user_id = u.id FROM "user" u WHERE j.user_id IS NULL AND u.username = j.username`,
+		`UPDATE export_job SET user_id = 0, expires_at = CURRENT_TIMESTAMP WHERE user_id IS NULL`,
+		`ALTER TABLE export_job DROP COLUMN username`,
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
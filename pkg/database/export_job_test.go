@@ -0,0 +1,32 @@
+package database
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestConvertExportJobOwners(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	dbMock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE export_job ADD COLUMN IF NOT EXISTS user_id bigint`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(regexp.QuoteMeta(`UPDATE export_job j SET user_id = u.id FROM "user" u`)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	dbMock.ExpectExec(regexp.QuoteMeta(`UPDATE export_job SET user_id = 0, expires_at = CURRENT_TIMESTAMP WHERE user_id IS NULL`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE export_job DROP COLUMN username`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// When
+	err := convertExportJobOwners(gormDB)
+
+	// Then
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,238 @@
+// Package daterange parses the date ranges of history queries.
+package daterange
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// Zones resolve even where the system has no zoneinfo
+	_ "time/tzdata"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// Bound tells which end of a range a time is parsed for
+type Bound int
+
+const (
+	Start Bound = iota
+	End
+)
+
+var (
+	// DefaultSpan is the length of a range missing one or both of its bounds
+	DefaultSpan = time.Duration(config.Int("HISTORY_DEFAULT_DAYS", 7)) * 24 * time.Hour
+	// MaxSpan is the longest range that can be queried
+	MaxSpan = time.Duration(config.Int("HISTORY_MAX_DAYS", 366)) * 24 * time.Hour
+)
+
+// LegacyLayout is the layout history dates were accepted and rendered in before time zones were supported
+const LegacyLayout = "2006-01-02T15:04:05"
+
+// layouts are the absolute formats accepted, tried in order. RFC 3339 carries its own offset,
+// the others are read in the requested zone.
+var layouts = []string{time.RFC3339Nano, LegacyLayout, "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// relative matches now, optionally shifted, as in now-7d or now+1h
+var relative = regexp.MustCompile(`^now(?:([+-])(\d+)([smhdw]))?$`)
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+var (
+	ErrInverted = errors.New("finit must not be after fend")
+	ErrTooLong  = fmt.Errorf("date range must not exceed %d days", int(MaxSpan.Hours()/24))
+)
+
+// Range is a resolved date range, with the zone its times are rendered in
+type Range struct {
+	Start    time.Time
+	End      time.Time
+	Location *time.Location
+	// Zoned is set when the zone was requested, and times are rendered with their offset
+	Zoned bool
+	// startKey and endKey identify the bounds in cache keys when Parse read them, see Key
+	startKey, endKey string
+}
+
+// FieldError is a bound or zone that could not be parsed, or a range that is not valid, reported against the query
+// param it was read from, or range
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
+// InvalidField names the param the error is reported against
+func (e *FieldError) InvalidField() string {
+	return e.Field
+}
+
+// Parse parses and resolves the range requested with finit, fend and tz, as read by ParseTime and Resolve
+func Parse(finitValue, fendValue, tz string, now time.Time) (Range, error) {
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return Range{}, &FieldError{Field: "tz", Message: "Invalid tz"}
+	}
+
+	finit, err := ParseTime(finitValue, Start, loc, now)
+	if err != nil {
+		return Range{}, &FieldError{Field: "finit", Message: "Invalid finit date format"}
+	}
+	fend, err := ParseTime(fendValue, End, loc, now)
+	if err != nil {
+		return Range{}, &FieldError{Field: "fend", Message: "Invalid fend date format"}
+	}
+
+	dateRange, err := Resolve(finit, fend, loc, tz != "", now)
+	if err != nil {
+		return Range{}, &FieldError{Field: rangeField(finitValue, fendValue), Message: err.Error()}
+	}
+
+	dateRange.startKey = boundKey(finitValue, dateRange.Start)
+	dateRange.endKey = boundKey(fendValue, dateRange.End)
+	return dateRange, nil
+}
+
+// rangeField names what an invalid range is reported against: the only bound given, since the other one is derived
+// from it or now, or the range as a whole when both were given
+func rangeField(finitValue, fendValue string) string {
+	switch {
+	case strings.TrimSpace(fendValue) == "":
+		return "finit"
+	case strings.TrimSpace(finitValue) == "":
+		return "fend"
+	default:
+		return "range"
+	}
+}
+
+// boundKey identifies a bound requested as value in cache keys. A bound missing or relative to now is kept as
+// requested, since its time changes with every request.
+func boundKey(value string, t time.Time) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || relative.MatchString(value) {
+		return "~" + value
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+// LoadLocation returns the IANA zone named tz, or UTC when it is empty
+func LoadLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(tz)
+}
+
+// ParseTime parses value as the bound of a range: an RFC 3339 timestamp, a date and time in loc,
+// a date, or a time relative to now. A date stands for its first instant as a start and its last as an end.
+// An empty value returns the zero time.
+func ParseTime(value string, bound Bound, loc *time.Location, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if match := relative.FindStringSubmatch(strings.ToLower(value)); match != nil {
+		if match[1] == "" {
+			return now, nil
+		}
+
+		// Shifts longer than any range would overflow the duration long before the time
+		unit := units[match[3]]
+		amount, err := strconv.Atoi(match[2])
+		if err != nil || amount > int(MaxSpan/unit) {
+			return time.Time{}, fmt.Errorf("invalid relative date %q", value)
+		}
+		shift := time.Duration(amount) * unit
+		if match[1] == "-" {
+			shift = -shift
+		}
+		return now.Add(shift), nil
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	day, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if bound == End {
+		// Timestamps are stored with microsecond precision
+		return day.AddDate(0, 0, 1).Add(-time.Microsecond), nil
+	}
+
+	return day, nil
+}
+
+// Resolve completes a range missing its bounds, which are zero, and validates it.
+// A missing end is now, and a missing start is DefaultSpan before the end.
+func Resolve(start, end time.Time, loc *time.Location, zoned bool, now time.Time) (Range, error) {
+	if end.IsZero() {
+		end = now
+	}
+	if start.IsZero() {
+		start = end.Add(-DefaultSpan)
+	}
+
+	if start.After(end) {
+		return Range{}, ErrInverted
+	}
+	if end.Sub(start) > MaxSpan {
+		return Range{}, ErrTooLong
+	}
+
+	return Range{Start: start.UTC(), End: end.UTC(), Location: loc, Zoned: zoned}, nil
+}
+
+// Format renders t in the zone of the range, with its offset when the zone was requested
+func (r Range) Format(t time.Time) string {
+	return FormatIn(t, r.Location, r.Zoned)
+}
+
+// FormatIn renders t in loc, with its offset when zoned, or in the legacy layout otherwise
+func FormatIn(t time.Time, loc *time.Location, zoned bool) string {
+	if !zoned {
+		return t.In(loc).Format(LegacyLayout)
+	}
+
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// Key identifies the range in cache keys. The bounds of a range read by Parse that are missing or relative to now
+// are identified as requested rather than by their time, so the key doesn't change every second; responses cached
+// under it are refreshed when new rates invalidate their namespace.
+func (r Range) Key() string {
+	start, end := r.startKey, r.endKey
+	if start == "" {
+		start = r.Start.Format(time.RFC3339Nano)
+	}
+	if end == "" {
+		end = r.End.Format(time.RFC3339Nano)
+	}
+
+	key := start + "_" + end
+	if r.Zoned {
+		key += "_" + r.Location.String()
+	}
+
+	return key
+}
@@ -0,0 +1,165 @@
+package daterange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+
+func TestParseTime(t *testing.T) {
+	mexico, err := time.LoadLocation("America/Mexico_City")
+	require.NoError(t, err)
+
+	tests := []struct {
+		value    string
+		bound    Bound
+		loc      *time.Location
+		expected time.Time
+	}{
+		{"2024-03-01T19:15:00", Start, time.UTC, time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)},
+		{"2024-03-01T19:15:00", Start, mexico, time.Date(2024, 3, 2, 1, 15, 0, 0, time.UTC)},
+		{"2024-03-01T19:15:00+02:00", Start, mexico, time.Date(2024, 3, 1, 17, 15, 0, 0, time.UTC)},
+		{"2024-03-01T19:15:00.5Z", Start, time.UTC, time.Date(2024, 3, 1, 19, 15, 0, 500000000, time.UTC)},
+		{"2024-03-01 19:15", Start, time.UTC, time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)},
+		{"2024-03-01", Start, time.UTC, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2024-03-01", End, time.UTC, time.Date(2024, 3, 1, 23, 59, 59, 999999000, time.UTC)},
+		{"2024-03-01", Start, mexico, time.Date(2024, 3, 1, 6, 0, 0, 0, time.UTC)},
+		{"now", End, time.UTC, now},
+		{"NOW-7d", Start, time.UTC, now.AddDate(0, 0, -7)},
+		{"now-2w", Start, time.UTC, now.AddDate(0, 0, -14)},
+		{"now+90m", End, time.UTC, now.Add(90 * time.Minute)},
+		{"", Start, time.UTC, time.Time{}},
+	}
+
+	for _, test := range tests {
+		// When
+		parsed, err := ParseTime(test.value, test.bound, test.loc, now)
+
+		// Then
+		require.NoError(t, err, test.value)
+		require.True(t, test.expected.Equal(parsed), "%s: expected %s, got %s", test.value, test.expected, parsed)
+	}
+}
+
+func TestParseTime_Invalid(t *testing.T) {
+	for _, value := range []string{"InvalidDate", "2024-13-01", "now-7y", "now-d", "01/03/2024", "now-999999999999d"} {
+		// When
+		_, err := ParseTime(value, Start, time.UTC, now)
+
+		// Then
+		require.Error(t, err, value)
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	// When
+	utc, utcErr := LoadLocation("")
+	_, invalidErr := LoadLocation("Mars/Olympus_Mons")
+
+	// Then
+	require.NoError(t, utcErr)
+	require.Equal(t, time.UTC, utc)
+	require.Error(t, invalidErr)
+}
+
+func TestResolve_Defaults(t *testing.T) {
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// When
+	open, err := Resolve(time.Time{}, time.Time{}, time.UTC, false, now)
+	require.NoError(t, err)
+	onlyStart, err := Resolve(start, time.Time{}, time.UTC, false, now)
+	require.NoError(t, err)
+	onlyEnd, err := Resolve(time.Time{}, start, time.UTC, false, now)
+	require.NoError(t, err)
+
+	// Then
+	require.Equal(t, now.Add(-DefaultSpan), open.Start)
+	require.Equal(t, now, open.End)
+	require.Equal(t, start, onlyStart.Start)
+	require.Equal(t, now, onlyStart.End)
+	require.Equal(t, start.Add(-DefaultSpan), onlyEnd.Start)
+}
+
+func TestResolve_Inverted(t *testing.T) {
+	// When
+	_, err := Resolve(now, now.Add(-time.Second), time.UTC, false, now)
+
+	// Then
+	require.ErrorIs(t, err, ErrInverted)
+}
+
+func TestResolve_TooLong(t *testing.T) {
+	// When
+	_, err := Resolve(now.Add(-MaxSpan-time.Second), now, time.UTC, false, now)
+
+	// Then
+	require.ErrorIs(t, err, ErrTooLong)
+}
+
+func TestRange_Format(t *testing.T) {
+	// Given
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+
+	// When
+	legacy := Range{Location: time.UTC}.Format(at)
+	zoned := Range{Location: tokyo, Zoned: true}.Format(at)
+
+	// Then
+	require.Equal(t, "2024-03-01T19:15:00", legacy)
+	require.Equal(t, "2024-03-02T04:15:00+09:00", zoned)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		finit, fend, tz string
+		field           string
+	}{
+		{"InvalidDate", "", "", "finit"},
+		{"", "InvalidDate", "", "fend"},
+		{"", "", "Mars/Olympus_Mons", "tz"},
+		{"now", "now-1d", "", "range"},
+		{"now+1d", "", "", "finit"},
+		{"", "now-1000d", "", "fend"},
+		{"now-999999999999d", "", "", "finit"},
+		{"2024-01-01", "2026-01-01", "", "range"},
+	}
+
+	for _, test := range tests {
+		// When
+		_, err := Parse(test.finit, test.fend, test.tz, now)
+
+		// Then
+		var fieldErr *FieldError
+		require.ErrorAs(t, err, &fieldErr)
+		require.Equal(t, test.field, fieldErr.Field)
+	}
+}
+
+func TestRange_Key(t *testing.T) {
+	// When
+	open, err := Parse("", "", "", now)
+	require.NoError(t, err)
+	openLater, err := Parse("", "", "", now.Add(time.Second))
+	require.NoError(t, err)
+	relative, err := Parse("NOW-7d", "now", "", now)
+	require.NoError(t, err)
+	relativeLater, err := Parse("now-7d", "now", "", now.Add(time.Minute))
+	require.NoError(t, err)
+	absolute, err := Parse("2024-03-01", "2024-03-02T00:00:00Z", "", now)
+	require.NoError(t, err)
+	resolved, err := Resolve(absolute.Start, absolute.End, time.UTC, false, now)
+	require.NoError(t, err)
+
+	// Then
+	require.Equal(t, open.Key(), openLater.Key())
+	require.Equal(t, relative.Key(), relativeLater.Key())
+	require.NotEqual(t, open.Key(), relative.Key())
+	require.Equal(t, "2024-03-01T00:00:00Z_2024-03-02T00:00:00Z", absolute.Key())
+	require.Equal(t, resolved.Key(), absolute.Key())
+}
@@ -0,0 +1,111 @@
+// Package export writes rate history in the formats data warehouses load.
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Format is a representation history can be exported in
+type Format string
+
+const (
+	// JSON is the default representation, answered by the handlers themselves
+	JSON    Format = ""
+	CSV     Format = "csv"
+	NDJSON  Format = "ndjson"
+	Parquet Format = "parquet"
+)
+
+// ErrUnsupported is returned for a format query param that names no known format
+var ErrUnsupported = errors.New("unsupported format")
+
+// mediaTypes maps the media types clients accept to the formats they stand for
+var mediaTypes = map[string]Format{
+	"application/json":               JSON,
+	"text/csv":                       CSV,
+	"application/x-ndjson":           NDJSON,
+	"application/vnd.apache.parquet": Parquet,
+}
+
+// Negotiate picks the format of a response from the format query param, or from the Accept header without it.
+// The first media type in Accept that has a format wins, and JSON is used when none does.
+func Negotiate(format, accept string) (Format, error) {
+	switch Format(strings.ToLower(format)) {
+	case CSV, NDJSON, Parquet:
+		return Format(strings.ToLower(format)), nil
+	case "json":
+		return JSON, nil
+	case JSON:
+	default:
+		return JSON, ErrUnsupported
+	}
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		if f, ok := mediaTypes[mediaType]; ok {
+			return f, nil
+		}
+	}
+
+	return JSON, nil
+}
+
+// ContentType is the Content-Type of a response in the format
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case NDJSON:
+		return "application/x-ndjson"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+// Row is an exported rate
+type Row struct {
+	Code string
+	// Time is the instant of the rate, written to Parquet as a timestamp
+	Time time.Time
+	// Date is Time rendered for the text formats, in the time zone of the request
+	Date   string
+	Value  decimal.Decimal
+	Filled bool
+}
+
+// Writer writes rows in a format. Close must be called to flush the rows still buffered.
+type Writer interface {
+	Write(row Row) error
+	Close() error
+}
+
+// NewWriter returns a writer of rows in the format to w
+func NewWriter(f Format, w io.Writer) (Writer, error) {
+	switch f {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case NDJSON:
+		return newNDJSONWriter(w), nil
+	case Parquet:
+		return newParquetWriter(w), nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Filename is the name an export of the currencies in symbols is downloaded as
+func Filename(symbols []string, f Format) string {
+	return strings.Join(symbols, "_") + "." + string(f)
+}
@@ -0,0 +1,115 @@
+package export
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func exportRows() []Row {
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	return []Row{
+		{Code: "MXN", Time: at, Date: "2024-03-01T19:15:00", Value: decimal.RequireFromString("17.0123456789")},
+		{Code: "EUR", Time: at, Date: "2024-03-01T19:15:00", Value: decimal.RequireFromString("0.92"), Filled: true},
+	}
+}
+
+func writeRows(t *testing.T, f Format) []byte {
+	var buffer bytes.Buffer
+	w, err := NewWriter(f, &buffer)
+	require.NoError(t, err)
+
+	for _, row := range exportRows() {
+		require.NoError(t, w.Write(row))
+	}
+	require.NoError(t, w.Close())
+
+	return buffer.Bytes()
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		format, accept string
+		expected       Format
+	}{
+		{"", "", JSON},
+		{"", "*/*", JSON},
+		{"", "text/csv", CSV},
+		{"", "application/x-ndjson;q=0.9, application/json", NDJSON},
+		{"", "application/vnd.apache.parquet", Parquet},
+		{"CSV", "application/x-ndjson", CSV},
+		{"json", "text/csv", JSON},
+	}
+
+	for _, c := range cases {
+		// When
+		f, err := Negotiate(c.format, c.accept)
+
+		// Then
+		require.NoError(t, err)
+		require.Equal(t, c.expected, f, "format %q, accept %q", c.format, c.accept)
+	}
+}
+
+func TestNegotiate_Unsupported(t *testing.T) {
+	// When
+	_, err := Negotiate("xml", "")
+
+	// Then
+	require.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestCSVWriter(t *testing.T) {
+	// When
+	written := writeRows(t, CSV)
+
+	// Then
+	expected := "code,date,value,filled\nMXN,2024-03-01T19:15:00,17.0123456789,false\nEUR,2024-03-01T19:15:00,0.92,true\n"
+	require.Equal(t, expected, string(written))
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	// When
+	written := writeRows(t, NDJSON)
+
+	// Then
+	expected := `{"code":"MXN","date":"2024-03-01T19:15:00","value":17.0123456789,"filled":false}` + "\n" +
+		`{"code":"EUR","date":"2024-03-01T19:15:00","value":0.92,"filled":true}` + "\n"
+	require.Equal(t, expected, string(written))
+}
+
+func TestParquetWriter(t *testing.T) {
+	// When
+	written := writeRows(t, Parquet)
+
+	// Then
+	rows, err := parquet.Read[parquetRow](bytes.NewReader(written), int64(len(written)))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, "MXN", rows[0].Code)
+	require.True(t, rows[0].Time.Equal(exportRows()[0].Time))
+	require.Equal(t, "17.0123456789", decimal.NewFromBigInt(new(big.Int).SetBytes(rows[0].Value[:]), -parquetScale).String())
+	require.True(t, rows[1].Filled)
+}
+
+func TestDecimalBytes_Negative(t *testing.T) {
+	// When
+	encoded, err := decimalBytes(decimal.RequireFromString("-0.000000000000000001"))
+
+	// Then every byte of the two's complement of -1 is set
+	require.NoError(t, err)
+	require.Equal(t, bytes.Repeat([]byte{0xff}, 16), encoded[:])
+}
+
+func TestDecimalBytes_TooLarge(t *testing.T) {
+	// When
+	_, err := decimalBytes(decimal.RequireFromString("1e20"))
+
+	// Then
+	require.Error(t, err)
+}
@@ -0,0 +1,302 @@
+package export
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// JobTTL is how long a job, and its file, is kept after it was requested
+	JobTTL = config.Seconds("EXPORT_JOB_TTL", 24*time.Hour)
+	// JobTimeout is how long a job may run, after which a job left running by a stopped worker is picked up again
+	JobTimeout = config.Seconds("EXPORT_JOB_TIMEOUT", time.Hour)
+)
+
+// maxAttempts is the number of times a job is picked up before it is given up on
+const maxAttempts = 3
+
+// Errors recorded on failed jobs, which are shown to their users. The cause of a failure is only logged, since it may
+// reveal the database or the storage.
+var (
+	JobFailedMessage    = "export could not be written"
+	JobAbandonedMessage = fmt.Sprintf("export did not finish after %d attempts", maxAttempts)
+)
+
+// Store keeps the files of export jobs, nil until InitJobs configured it
+var Store storage.Storage
+
+// NewJobID returns a random job ID
+func NewJobID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic("Failed to generate export job ID: " + err.Error())
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// JobQuery rebuilds the query of a job
+func JobQuery(job models.ExportJob) (Query, error) {
+	loc, err := daterange.LoadLocation(job.TZ)
+	if err != nil {
+		return Query{}, err
+	}
+
+	q := Query{
+		Symbols: strings.Split(job.Symbols, ","),
+		Base:    job.Base,
+		Range:   daterange.Range{Start: job.Start, End: job.End, Location: loc, Zoned: job.TZ != ""},
+	}
+
+	if job.Step != "" {
+		if q.Step, err = rates.ParseStep(job.Step); err != nil {
+			return Query{}, err
+		}
+	}
+	if q.Fill, err = rates.ParseFill(job.Fill); err != nil {
+		return Query{}, err
+	}
+
+	return q, nil
+}
+
+// Claim marks the oldest queued job as running and returns it, reporting false when there is none. Jobs left running
+// past JobTimeout, by a worker that stopped, are claimed again, and returned failed once they were claimed maxAttempts times.
+func Claim(db *gorm.DB, now time.Time) (models.ExportJob, bool, error) {
+	var job models.ExportJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Skipping locked jobs lets every worker claim a different one
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR (status = ? AND started_at < ?)", models.ExportQueued, models.ExportRunning, now.Add(-JobTimeout)).
+			Where("expires_at > ?", now).
+			Order("created_at").
+			First(&job).Error
+		if err != nil {
+			return err
+		}
+
+		if job.Attempts >= maxAttempts {
+			job.Status = models.ExportFailed
+			job.Error = JobAbandonedMessage
+			job.FinishedAt = &now
+			return tx.Model(&job).Updates(map[string]interface{}{"status": job.Status, "error": job.Error, "finished_at": now}).Error
+		}
+
+		job.Status = models.ExportRunning
+		job.StartedAt = &now
+		job.Attempts++
+		return tx.Model(&job).Updates(map[string]interface{}{"status": job.Status, "started_at": now, "attempts": job.Attempts}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return job, false, nil
+	}
+	if err != nil {
+		return job, false, err
+	}
+
+	return job, true, nil
+}
+
+// Run writes the file of a claimed job to store and records how it went
+func Run(ctx context.Context, db *gorm.DB, store storage.Storage, job models.ExportJob) error {
+	updates := map[string]interface{}{"finished_at": time.Now()}
+	if err := write(ctx, db, store, &job); err != nil {
+		log.Printf("Error running export job %s: %s\n", job.ID, err)
+		updates["status"] = models.ExportFailed
+		updates["error"] = JobFailedMessage
+	} else {
+		updates["status"] = models.ExportDone
+		updates["rows"] = job.Rows
+		updates["size"] = job.Size
+		updates["storage_key"] = job.StorageKey
+	}
+
+	// The job may have expired, and been deleted, while it ran
+	result := db.Model(&models.ExportJob{}).Where("id = ? AND status = ?", job.ID, models.ExportRunning).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 && job.StorageKey != "" {
+		return store.Delete(ctx, job.StorageKey)
+	}
+
+	return nil
+}
+
+// write exports the rates of job to a temporary file, then uploads it, so the upload size is known
+func write(ctx context.Context, db *gorm.DB, store storage.Storage, job *models.ExportJob) error {
+	q, err := JobQuery(*job)
+	if err != nil {
+		return err
+	}
+
+	format := Format(job.Format)
+	file, err := os.CreateTemp("", "export-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w, err := NewWriter(format, file)
+	if err != nil {
+		return err
+	}
+
+	var rows int64
+	err = Each(ctx, db, q, func(row Row) error {
+		rows++
+		return w.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := "exports/" + job.ID + "." + job.Format
+	if err := store.Put(ctx, key, file, size, format.ContentType()); err != nil {
+		return err
+	}
+
+	job.Rows, job.Size, job.StorageKey = rows, size, key
+	return nil
+}
+
+// Expire deletes the jobs past their expiry along with their files, returning how many were deleted
+func Expire(ctx context.Context, db *gorm.DB, store storage.Storage, now time.Time) (int, error) {
+	var jobs []models.ExportJob
+	if err := db.WithContext(ctx).Where("expires_at <= ?", now).Limit(100).Find(&jobs).Error; err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, job := range jobs {
+		if job.StorageKey != "" {
+			if err := store.Delete(ctx, job.StorageKey); err != nil {
+				return deleted, err
+			}
+		}
+
+		if err := db.WithContext(ctx).Delete(&job).Error; err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// DeleteOwnedBy deletes the jobs of the user with userID along with their files, which are kept until they expire
+// otherwise. Files are left alone when store is nil.
+func DeleteOwnedBy(ctx context.Context, db *gorm.DB, store storage.Storage, userID int) error {
+	var jobs []models.ExportJob
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Find(&jobs).Error; err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.ExportJob{}).Error; err != nil {
+		return err
+	}
+
+	// A job still running deletes its own file once it finds its row gone, see Run
+	if store == nil {
+		return nil
+	}
+	for _, job := range jobs {
+		if job.StorageKey == "" {
+			continue
+		}
+		if err := store.Delete(ctx, job.StorageKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InitJobs configures the storage of export jobs, then starts EXPORT_WORKERS workers and the expiry of old jobs.
+// Store is set before it returns, so handlers never read it while it is being set.
+func InitJobs() {
+	store, err := storage.New(storage.LoadConfig())
+	if err != nil {
+		log.Printf("Export jobs are disabled: %s\n", err)
+		return
+	}
+	Store = store
+
+	poll := config.Seconds("EXPORT_POLL_INTERVAL", 5*time.Second)
+	for i := 0; i < config.Int("EXPORT_WORKERS", 2); i++ {
+		go work(store, poll)
+	}
+	go expire(store, poll)
+}
+
+// expire deletes expired jobs every poll
+func expire(store storage.Storage, poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		if _, err := Expire(context.Background(), database.DB, store, time.Now()); err != nil {
+			log.Printf("Error expiring export jobs: %s\n", err)
+		}
+	}
+}
+
+// work runs queued jobs, one at a time, checking for new ones every poll
+func work(store storage.Storage, poll time.Duration) {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		for {
+			job, ok, err := Claim(database.DB, time.Now())
+			if err != nil {
+				log.Printf("Error claiming export job: %s\n", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			if job.Status != models.ExportRunning {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), JobTimeout)
+			if err := Run(ctx, database.DB, store, job); err != nil {
+				log.Printf("Error recording export job %s: %s\n", job.ID, err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/storage"
+)
+
+var jobColumns = []string{"id", "user_id", "symbols", "base", "start", "end", "tz", "step", "fill", "format", "status", "attempts", "expires_at"}
+
+func TestClaim(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	now := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job" WHERE \(status = \$1 OR \(status = \$2 AND started_at < \$3\)\) AND expires_at > \$4 ORDER BY created_at,"export_job"."id" LIMIT \$5 FOR UPDATE SKIP LOCKED`).
+		WithArgs(models.ExportQueued, models.ExportRunning, now.Add(-JobTimeout), now, 1).
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow("job", 1, "MXN", "USD", start, now, "", "", "", "csv", models.ExportQueued, 0, now.Add(time.Hour)))
+	dbMock.ExpectExec(`UPDATE "export_job" SET "attempts"=\$1,"started_at"=\$2,"status"=\$3 WHERE "id" = \$4`).
+		WithArgs(1, now, models.ExportRunning, "job").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	job, ok, err := Claim(gormDB, now)
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "job", job.ID)
+	require.Equal(t, models.ExportRunning, job.Status)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestClaim_GivesUp(t *testing.T) {
+	// Given a job left running by workers that stopped maxAttempts times
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	now := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job"`).
+		WillReturnRows(sqlmock.NewRows(jobColumns).
+			AddRow("job", 1, "MXN", "USD", now, now, "", "", "", "csv", models.ExportRunning, maxAttempts, now.Add(time.Hour)))
+	dbMock.ExpectExec(`UPDATE "export_job" SET "error"=\$1,"finished_at"=\$2,"status"=\$3 WHERE "id" = \$4`).
+		WithArgs("export did not finish after 3 attempts", now, models.ExportFailed, "job").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	job, ok, err := Claim(gormDB, now)
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, models.ExportFailed, job.Status)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestRun(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 23, 59, 59, 999999000, time.UTC)
+	job := models.ExportJob{ID: "job", Symbols: "MXN", Base: "USD", Start: start, End: end, Format: "csv", Status: models.ExportRunning}
+
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY created_at, code`).
+		WithArgs("MXN", start, end, "MXN", start, end, "MXN", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("MXN", start.Add(time.Hour), "17.05"))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "export_job" SET (.+) WHERE id = (.+) AND status = (.+)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	err = Run(context.Background(), gormDB, store, job)
+
+	// Then
+	require.NoError(t, err)
+	file, err := store.Open(context.Background(), "exports/job.csv")
+	require.NoError(t, err)
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.Equal(t, "code,date,value,filled\nMXN,2024-03-01T01:00:00,17.05,false\n", string(content))
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestRun_Failed(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 1, 23, 59, 59, 999999000, time.UTC)
+	job := models.ExportJob{ID: "job", Symbols: "MXN", Base: "USD", Start: start, End: end, Format: "csv", Status: models.ExportRunning}
+
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY created_at, code`).
+		WillReturnError(errors.New(`pq: relation "currency" does not exist`))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE "export_job" SET (.+) WHERE id = (.+) AND status = (.+)`).
+		WithArgs(JobFailedMessage, sqlmock.AnyArg(), models.ExportFailed, "job", models.ExportRunning).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	err = Run(context.Background(), gormDB, store, job)
+
+	// Then the cause of the failure is not recorded on the job
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestExpire(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "exports/job.csv", strings.NewReader(""), 0, "text/csv"))
+
+	now := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job" WHERE expires_at <= \$1 LIMIT \$2`).
+		WithArgs(now, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}).AddRow("job", "exports/job.csv"))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`DELETE FROM "export_job" WHERE "export_job"."id" = \$1`).
+		WithArgs("job").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+
+	// When
+	deleted, err := Expire(ctx, gormDB, store, now)
+
+	// Then the file is deleted along with the job
+	require.NoError(t, err)
+	require.Equal(t, 1, deleted)
+	_, err = store.Open(ctx, "exports/job.csv")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestDeleteOwnedBy(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "exports/job.csv", strings.NewReader(""), 0, "text/csv"))
+
+	dbMock.ExpectQuery(`SELECT \* FROM "export_job" WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}).AddRow("job", "exports/job.csv").AddRow("queued", ""))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`DELETE FROM "export_job" WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	dbMock.ExpectCommit()
+
+	// When
+	err = DeleteOwnedBy(ctx, gormDB, store, 1)
+
+	// Then the files are deleted along with the jobs
+	require.NoError(t, err)
+	_, err = store.Open(ctx, "exports/job.csv")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
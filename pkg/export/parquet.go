@@ -0,0 +1,97 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// parquetScale is the number of decimals values are written with, as DECIMAL(38, 18)
+const parquetScale = 18
+
+// parquetRowGroup is the number of rows buffered before a row group is written, bounding the memory of an export
+var parquetRowGroup = config.Int("EXPORT_PARQUET_ROW_GROUP", 100000)
+
+// parquetBatch is the number of rows handed to the parquet writer at once
+const parquetBatch = 1024
+
+var (
+	// maxUnscaled is the first unscaled value that does not fit in 38 digits
+	maxUnscaled = new(big.Int).Exp(big.NewInt(10), big.NewInt(38), nil)
+	// twoTo128 turns a negative unscaled value into its 16 byte two's complement
+	twoTo128 = new(big.Int).Lsh(big.NewInt(1), 128)
+)
+
+// parquetRow is a row as stored in Parquet, with the time as a UTC timestamp
+type parquetRow struct {
+	Code   string    `parquet:"code,dict"`
+	Time   time.Time `parquet:"time,timestamp(microsecond)"`
+	Value  [16]byte  `parquet:"value,decimal(18:38)"`
+	Filled bool      `parquet:"filled"`
+}
+
+// parquetWriter writes rows in row groups of parquetRowGroup rows, compressed with zstd
+type parquetWriter struct {
+	w     *parquet.GenericWriter[parquetRow]
+	batch []parquetRow
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w: parquet.NewGenericWriter[parquetRow](w,
+			parquet.MaxRowsPerRowGroup(int64(parquetRowGroup)),
+			parquet.Compression(&zstd.Codec{})),
+		batch: make([]parquetRow, 0, parquetBatch),
+	}
+}
+
+func (w *parquetWriter) Write(row Row) error {
+	value, err := decimalBytes(row.Value)
+	if err != nil {
+		return err
+	}
+
+	w.batch = append(w.batch, parquetRow{Code: row.Code, Time: row.Time.UTC(), Value: value, Filled: row.Filled})
+	if len(w.batch) < parquetBatch {
+		return nil
+	}
+
+	return w.flush()
+}
+
+func (w *parquetWriter) flush() error {
+	_, err := w.w.Write(w.batch)
+	w.batch = w.batch[:0]
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	return w.w.Close()
+}
+
+// decimalBytes encodes value as the big-endian two's complement of its unscaled value at parquetScale
+func decimalBytes(value decimal.Decimal) ([16]byte, error) {
+	var encoded [16]byte
+
+	unscaled := value.Shift(parquetScale).RoundBank(0).BigInt()
+	if new(big.Int).Abs(unscaled).Cmp(maxUnscaled) >= 0 {
+		return encoded, fmt.Errorf("value %s does not fit in DECIMAL(38, %d)", value, parquetScale)
+	}
+
+	if unscaled.Sign() < 0 {
+		unscaled.Add(unscaled, twoTo128)
+	}
+	unscaled.FillBytes(encoded[:])
+
+	return encoded, nil
+}
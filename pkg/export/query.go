@@ -0,0 +1,72 @@
+package export
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/rates"
+	"gorm.io/gorm"
+)
+
+// Query selects the rates an export writes
+type Query struct {
+	Symbols []string
+	// Base is the currency rates are quoted against
+	Base  string
+	Range daterange.Range
+	// Step resamples the rates on buckets of its length when set, filling gaps with Fill
+	Step time.Duration
+	Fill rates.Fill
+}
+
+// Each calls fn with the rates of the query, oldest first.
+// Raw history is streamed from a cursor, while resampled history, bounded by the number of buckets, is read at once.
+func Each(ctx context.Context, db *gorm.DB, q Query, fn func(Row) error) error {
+	codes := q.Symbols
+	if q.Base != rates.Base && !slices.Contains(codes, q.Base) {
+		codes = append(slices.Clone(codes), q.Base)
+	}
+
+	// The base is only read to rebase the symbols
+	emit := func(rate rates.Sample) error {
+		if !slices.Contains(q.Symbols, rate.Code) {
+			return nil
+		}
+
+		return fn(Row{
+			Code:   rate.Code,
+			Time:   rate.Time,
+			Date:   q.Range.Format(rate.Time),
+			Value:  rate.Value,
+			Filled: rate.Filled,
+		})
+	}
+
+	if q.Step == 0 {
+		return rates.Stream(ctx, db, codes, q.Base, q.Range.Start, q.Range.End, emit)
+	}
+
+	history, err := rates.History(ctx, db, codes, q.Range.Start, q.Range.End)
+	if err != nil {
+		return err
+	}
+
+	grid := rates.NewGrid(q.Range.Start, q.Range.End, q.Step, q.Range.Location)
+	history = rates.Rebase(rates.Resample(history, grid, q.Fill), q.Base)
+
+	// Order rates like the streamed ones
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Time.Before(history[j].Time)
+	})
+
+	for _, rate := range history {
+		if err := emit(rate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// csvWriter writes a header line, then a line per row
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (w *csvWriter) Write(row Row) error {
+	if !w.header {
+		w.header = true
+		if err := w.w.Write([]string{"code", "date", "value", "filled"}); err != nil {
+			return err
+		}
+	}
+
+	return w.w.Write([]string{row.Code, row.Date, row.Value.String(), strconv.FormatBool(row.Filled)})
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// ndjsonRow is a row as a line of NDJSON, with the value as a number that keeps its precision
+type ndjsonRow struct {
+	Code   string      `json:"code"`
+	Date   string      `json:"date"`
+	Value  json.Number `json:"value"`
+	Filled bool        `json:"filled"`
+}
+
+// ndjsonWriter writes a JSON object per line
+type ndjsonWriter struct {
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	buffer := bufio.NewWriter(w)
+	return &ndjsonWriter{buffer: buffer, encoder: json.NewEncoder(buffer)}
+}
+
+func (w *ndjsonWriter) Write(row Row) error {
+	return w.encoder.Encode(ndjsonRow{Code: row.Code, Date: row.Date, Value: json.Number(row.Value.String()), Filled: row.Filled})
+}
+
+func (w *ndjsonWriter) Close() error {
+	return w.buffer.Flush()
+}
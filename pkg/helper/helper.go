@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"net/http"
@@ -22,12 +26,22 @@ func SetupTestDatabase(t *testing.T) (sqlmock.Sqlmock, *gorm.DB) {
 	// Replace the actual database with the mock database for testing
 	gormDB, err := gorm.Open(postgres.New(postgres.Config{
 		Conn: db,
-	}), &gorm.Config{})
+	}), &gorm.Config{TranslateError: true})
 	require.NoError(t, err)
 
 	return dbMock, gormDB
 }
 
+// SetupTestCache points the cache at an in-memory Redis server for testing.
+func SetupTestCache(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	cache.Rdb = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	cache.Default = cache.NewRedisStore(cache.Rdb)
+	cache.PurgeLocal()
+
+	return server
+}
+
 // PerformRequest performs an HTTP request and returns the response recorder.
 func PerformRequest(router *gin.Engine, method, path string, requestBody ...[]byte) *httptest.ResponseRecorder {
 	var reqBody []byte
@@ -47,3 +61,14 @@ func ToJSON(v interface{}) []byte {
 	result, _ := json.Marshal(v)
 	return result
 }
+
+// DecodeProblem decodes the problem details answered in w, checking it was answered as one.
+func DecodeProblem(t *testing.T, w *httptest.ResponseRecorder) problem.Details {
+	require.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+	var details problem.Details
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &details))
+	require.Equal(t, w.Code, details.Status)
+
+	return details
+}
@@ -1,22 +1,25 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
 )
 
 func APIKeyAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
-		if apiKey == os.Getenv("API_SECRET_KEY") {
+		if validAPIKey(c.GetHeader("X-API-Key")) {
 			c.Next()
 		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Unauthorized",
-			})
-			c.Abort()
+			problem.Write(c, problem.New(http.StatusUnauthorized, "invalid_api_key", "Unauthorized"))
 		}
 	}
 }
+
+// validAPIKey reports whether apiKey is API_SECRET_KEY, comparing them in constant time
+func validAPIKey(apiKey string) bool {
+	return subtle.ConstantTimeCompare([]byte(apiKey), []byte(os.Getenv("API_SECRET_KEY"))) == 1
+}
@@ -1,50 +1,56 @@
 package middleware
 
 import (
+	"errors"
 	auth "github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+	"gorm.io/gorm"
 )
 
+const BearerSchema = "Bearer "
+
 func JWTAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		const BearerSchema = "Bearer "
 		header := c.GetHeader("Authorization")
 		if header == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization Header"})
-			c.Abort()
+			problem.Write(c, problem.New(http.StatusUnauthorized, "missing_token", "Missing Authorization Header"))
 			return
 		}
 
 		if !strings.HasPrefix(header, BearerSchema) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid Authorization Header"})
-			c.Abort()
+			problem.Write(c, problem.New(http.StatusUnauthorized, "invalid_token", "Invalid Authorization Header"))
 			return
 		}
 
-		tokenStr := header[len(BearerSchema):]
-		claims := &auth.Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return auth.JwtKey, nil
-		})
-
+		claims, err := auth.ParseToken(header[len(BearerSchema):])
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			problem.Write(c, problem.New(http.StatusUnauthorized, "invalid_token", "Invalid token"))
 			return
 		}
 
-		if !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+		// Tokens are revoked when the password or role changes or the account is deleted, and the role is read from
+		// the account rather than trusted from the token
+		var user models.User
+		err = database.DB.WithContext(c.Request.Context()).Select("id", "username", "role", "token_version").
+			Where("id = ?", claims.UserID).Take(&user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			problem.Write(c, problem.Internal(err))
+			return
+		}
+		if err != nil || !claims.Current(user) {
+			problem.Write(c, problem.New(http.StatusUnauthorized, "invalid_token", "Invalid token"))
 			return
 		}
 
-		c.Set("username", claims.Username)
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
 		c.Next()
 	}
 }
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+)
+
+func performAuthenticatedRequest(t *testing.T, user models.User) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.GET("/me", JWTAuth(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetInt("user_id"), "username": c.GetString("username"), "role": c.GetString("role")})
+	})
+
+	token, err := auth.GenerateToken(user)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/me", nil)
+	req.Header.Set("Authorization", BearerSchema+token)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestJWTAuth_Current(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+	database.DB = gormDB
+
+	dbMock.ExpectQuery(`SELECT "id","username","role","token_version" FROM "user" WHERE id = (.+) LIMIT (.+)`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "token_version"}).AddRow(1, "test", models.RoleUser, 2))
+
+	// When the token still claims a role the user lost
+	w := performAuthenticatedRequest(t, models.User{ID: 1, Username: "test", Role: models.RoleAdmin, TokenVersion: 2})
+
+	// Then the role of the account is used
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"user_id": 1, "username": "test", "role": "user"}`, w.Body.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestJWTAuth_Revoked(t *testing.T) {
+	tests := map[string]*sqlmock.Rows{
+		"password changed": sqlmock.NewRows([]string{"id", "username", "token_version"}).AddRow(1, "test", 3),
+		"account deleted":  sqlmock.NewRows([]string{"id", "username", "token_version"}),
+	}
+
+	for name, rows := range tests {
+		// Given
+		dbMock, gormDB := helper.SetupTestDatabase(t)
+		database.DB = gormDB
+
+		dbMock.ExpectQuery(`SELECT "id","username","role","token_version" FROM "user" WHERE id = (.+) LIMIT (.+)`).
+			WithArgs(1, 1).
+			WillReturnRows(rows)
+
+		// When
+		w := performAuthenticatedRequest(t, models.User{ID: 1, Username: "test", TokenVersion: 2})
+
+		// Then
+		require.Equal(t, http.StatusUnauthorized, w.Code, name)
+		require.Equal(t, "invalid_token", helper.DecodeProblem(t, w).Code, name)
+		require.NoError(t, dbMock.ExpectationsWereMet(), name)
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestDeprecated(t *testing.T) {
+	// Given
+	r := gin.New()
+	deprecatedAt := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 4, 19, 0, 0, 0, 0, time.UTC)
+	r.GET("/old", Deprecated(deprecatedAt, sunset, "/new"), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "ok"})
+	})
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/old", nil)
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "@1792368000", w.Header().Get("Deprecation"))
+	require.Equal(t, "Mon, 19 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"))
+	require.Equal(t, `</new>; rel="successor-version"`, w.Header().Get("Link"))
+}
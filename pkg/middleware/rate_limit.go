@@ -1,21 +1,148 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/time/rate"
+	"github.com/go-redis/redis/v8"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/auth"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
 )
 
-func RateLimiter(r rate.Limit, b int) gin.HandlerFunc {
-	limiter := rate.NewLimiter(r, b)
+// RateLimitPolicy describes a token bucket shared by every replica through Redis.
+// Each client starts with Burst tokens, which refill at Limit tokens per Period.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+	Burst  int
+	// Key identifies the client a request is accounted to.
+	Key func(c *gin.Context) string
+}
+
+// DefaultRateLimitPolicy is applied to every route, keyed by user, API key or IP.
+func DefaultRateLimitPolicy() RateLimitPolicy {
+	limit := config.Int("RATE_LIMIT_REQUESTS", 60)
+	return RateLimitPolicy{
+		Name:   "default",
+		Limit:  limit,
+		Period: config.Seconds("RATE_LIMIT_PERIOD", time.Minute),
+		Burst:  config.Int("RATE_LIMIT_BURST", limit),
+		Key:    ClientIdentity,
+	}
+}
+
+// LoginRateLimitPolicy is the stricter policy for credential endpoints, keyed by IP.
+func LoginRateLimitPolicy() RateLimitPolicy {
+	limit := config.Int("LOGIN_RATE_LIMIT_REQUESTS", 5)
+	return RateLimitPolicy{
+		Name:   "login",
+		Limit:  limit,
+		Period: config.Seconds("LOGIN_RATE_LIMIT_PERIOD", time.Minute),
+		Burst:  config.Int("LOGIN_RATE_LIMIT_BURST", limit),
+		Key: func(c *gin.Context) string {
+			return "ip:" + c.ClientIP()
+		},
+	}
+}
+
+// ClientIdentity keys a request by authenticated user, then API key, then client IP.
+// The API key only identifies a client once it matches API_SECRET_KEY, so sending made-up keys doesn't get a client a
+// new bucket per request.
+func ClientIdentity(c *gin.Context) string {
+	if username := c.GetString("username"); username != "" {
+		return "user:" + username
+	}
+
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, BearerSchema) {
+		if claims, err := auth.ParseToken(header[len(BearerSchema):]); err == nil && claims.Username != "" {
+			return "user:" + claims.Username
+		}
+	}
+
+	if apiKey := c.GetHeader("X-API-Key"); apiKey != "" && validAPIKey(apiKey) {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// tokenBucket refills and takes one token atomically.
+// It returns whether the request is allowed and the tokens left in the bucket.
+var tokenBucket = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity / rate))
+
+return {allowed, tostring(tokens)}
+`)
+
+// RateLimiter enforces policy per client and reports the bucket state in RateLimit-* headers.
+// When Redis cannot be reached requests are let through rather than rejected.
+func RateLimiter(policy RateLimitPolicy) gin.HandlerFunc {
+	// Tokens refilled per millisecond
+	refill := float64(policy.Limit) / float64(policy.Period.Milliseconds())
+
 	return func(c *gin.Context) {
-		if !limiter.AllowN(time.Now(), 1) {
-			c.String(http.StatusTooManyRequests, "Rate limit exceeded")
-			c.Abort()
+		key := fmt.Sprintf("ratelimit:%s:%s", policy.Name, policy.Key(c))
+		now := time.Now().UnixMilli()
+
+		result, err := tokenBucket.Run(cache.Ctx, cache.Rdb, []string{key}, policy.Burst, refill, now).Slice()
+		if err != nil || len(result) != 2 {
+			log.Printf("Error checking rate limit for %s: %v\n", key, err)
+			c.Next()
+			return
+		}
+
+		allowed, _ := result[0].(int64)
+		tokens, _ := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+
+		// Seconds until the bucket is full again
+		reset := math.Ceil((float64(policy.Burst) - tokens) / refill / 1000)
+
+		c.Header("RateLimit-Limit", strconv.Itoa(policy.Burst))
+		c.Header("RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(reset)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Period.Seconds())))
+
+		if allowed != 1 {
+			// Seconds until the next token is available
+			retryAfter := math.Ceil((1 - tokens) / refill / 1000)
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter)))
+			problem.Write(c, problem.New(http.StatusTooManyRequests, problem.CodeRateLimited, "Rate limit exceeded"))
 			return
 		}
+
 		c.Next()
 	}
 }
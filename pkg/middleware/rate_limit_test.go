@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func newRateLimitedRouter(policy RateLimitPolicy) *gin.Engine {
+	r := gin.New()
+	r.Use(RateLimiter(policy))
+	r.GET("/limited", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "ok"})
+	})
+
+	return r
+}
+
+func performLimitedRequest(r *gin.Engine, apiKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/limited", nil)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func testPolicy() RateLimitPolicy {
+	return RateLimitPolicy{Name: "test", Limit: 2, Period: time.Minute, Burst: 2, Key: ClientIdentity}
+}
+
+func TestRateLimiter_Allowed(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	r := newRateLimitedRouter(testPolicy())
+
+	// When
+	w := performLimitedRequest(r, "client-a")
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+}
+
+func TestRateLimiter_Exceeded(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	r := newRateLimitedRouter(testPolicy())
+
+	// When
+	performLimitedRequest(r, "client-a")
+	performLimitedRequest(r, "client-a")
+	w := performLimitedRequest(r, "client-a")
+
+	// Then
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	details := helper.DecodeProblem(t, w)
+	require.Equal(t, "rate_limited", details.Code)
+	require.Equal(t, "Rate limit exceeded", details.Detail)
+}
+
+func TestRateLimiter_PerClient(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	t.Setenv("API_SECRET_KEY", "client-a")
+	r := newRateLimitedRouter(testPolicy())
+
+	// When
+	performLimitedRequest(r, "client-a")
+	performLimitedRequest(r, "client-a")
+	w := performLimitedRequest(r, "")
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+}
+
+func TestRateLimiter_RotatingInvalidKeys(t *testing.T) {
+	// Given
+	helper.SetupTestCache(t)
+	t.Setenv("API_SECRET_KEY", "secret")
+	r := newRateLimitedRouter(testPolicy())
+
+	// When
+	performLimitedRequest(r, "bogus-1")
+	performLimitedRequest(r, "bogus-2")
+	w := performLimitedRequest(r, "bogus-3")
+
+	// Then
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestRateLimiter_RedisUnavailable(t *testing.T) {
+	// Given
+	server := helper.SetupTestCache(t)
+	r := newRateLimitedRouter(testPolicy())
+	server.Close()
+
+	// When
+	w := performLimitedRequest(r, "client-a")
+
+	// Then
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+)
+
+// Recovery answers a panicking request with an internal error, logging the panic and its stack with the request ID.
+// A response already under way is cut short instead, by aborting the connection, so the client does not take it
+// for a complete one.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			// Handlers abort on purpose, and net/http closes the connection quietly
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			// The client went away, there is no one to answer
+			if err, ok := recovered.(error); ok && (errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)) {
+				c.Abort()
+				return
+			}
+
+			log.Printf("Panic serving %s %s request_id=%s: %v\n%s", c.Request.Method, c.Request.URL.Path,
+				c.GetString("request_id"), recovered, debug.Stack())
+
+			if c.Writer.Written() {
+				panic(http.ErrAbortHandler)
+			}
+			problem.Write(c, problem.Internal(fmt.Errorf("panic: %v", recovered)))
+		}()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestRecovery_Panic(t *testing.T) {
+	// Given a handler that panics with an internal error
+	r := gin.New()
+	r.Use(RequestID(), Recovery())
+	r.GET("/panic", func(c *gin.Context) {
+		panic(errors.New("pq: password authentication failed for user \"postgres\""))
+	})
+
+	// When
+	w := helper.PerformRequest(r, "GET", "/panic", nil)
+
+	// Then the client gets an internal error it can report, without the cause
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	details := helper.DecodeProblem(t, w)
+	require.Equal(t, "internal_error", details.Code)
+	require.Equal(t, "An unexpected error occurred", details.Detail)
+	require.Equal(t, w.Header().Get(RequestIDHeader), details.RequestID)
+	require.NotContains(t, w.Body.String(), "postgres")
+}
+
+func TestRecovery_AbortHandler(t *testing.T) {
+	// Given a handler aborting a response under way
+	r := gin.New()
+	r.Use(Recovery())
+	r.GET("/abort", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic(http.ErrAbortHandler)
+	})
+
+	// When / Then the panic reaches net/http, which closes the connection
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		helper.PerformRequest(r, "GET", "/abort", nil)
+	})
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequestIDRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/id", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("request_id"))
+	})
+
+	return r
+}
+
+func performRequestIDRequest(r *gin.Engine, requestID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/id", nil)
+	if requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequestID_Generated(t *testing.T) {
+	// Given
+	r := newRequestIDRouter()
+
+	// When
+	w := performRequestIDRequest(r, "")
+
+	// Then a new ID is set on the context and the response
+	require.Len(t, w.Header().Get(RequestIDHeader), 32)
+	require.Equal(t, w.Header().Get(RequestIDHeader), w.Body.String())
+}
+
+func TestRequestID_FromClient(t *testing.T) {
+	// Given
+	r := newRequestIDRouter()
+
+	// When
+	kept := performRequestIDRequest(r, "abc-123.def_4")
+	replaced := performRequestIDRequest(r, "bad id\nforged log line")
+
+	// Then IDs that are safe to log are kept, others replaced
+	require.Equal(t, "abc-123.def_4", kept.Header().Get(RequestIDHeader))
+	require.Equal(t, "abc-123.def_4", kept.Body.String())
+	require.Len(t, replaced.Header().Get(RequestIDHeader), 32)
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/problem"
+)
+
+// RequireRole only lets through users authenticated by JWTAuth with the given role.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != role {
+			problem.Write(c, problem.New(http.StatusForbidden, problem.CodeForbidden, "Forbidden"))
+			return
+		}
+		c.Next()
+	}
+}
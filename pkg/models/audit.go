@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type AuditLog struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Event     string    `json:"event" gorm:"index; not null"`
+	Username  string    `json:"username" gorm:"index"`
+	IP        string    `json:"ip"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_log"
+}
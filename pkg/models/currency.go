@@ -1,18 +1,29 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"github.com/shopspring/decimal"
+)
+
+func init() {
+	// Rates stay JSON numbers, written with every digit instead of strings
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+// Currency is a rate sample of the currency with metadata Code
 type Currency struct {
-	ID        int       `json:"id" gorm:"type:integer;autoIncrement:true"`
-	Name      string    `json:"name" gorm:"index; not null"`
-	Code      string    `json:"code" gorm:"not null"`
-	Value     float64   `json:"value" gorm:"not null"`
-	CreatedAt time.Time `json:"created_at" gorm:"type:timestamp;default:CURRENT_TIMESTAMP"`
+	ID        int             `json:"id" gorm:"type:integer;autoIncrement:true"`
+	Code      string          `json:"code" gorm:"not null;index:idx_currency_code_created_at,priority:1"`
+	Value     decimal.Decimal `json:"value" gorm:"type:numeric;not null"`
+	CreatedAt time.Time       `json:"created_at" gorm:"type:timestamp;default:CURRENT_TIMESTAMP;index:idx_currency_code_created_at,priority:2;index:idx_currency_created_at"`
 }
 
 type CurrencyData struct {
-	Date  string  `json:"date"`
-	Value float64 `json:"value"`
+	Date  string          `json:"date"`
+	Value decimal.Decimal `json:"value"`
+	// Filled marks values filling a gap of a resampled series
+	Filled bool `json:"filled,omitempty"`
 }
 
 type GroupedCurrencies struct {
@@ -25,11 +36,42 @@ type CurrencyAPIResponse struct {
 		LastUpdatedAt time.Time `json:"last_updated_at"`
 	} `json:"meta"`
 	Data map[string]struct {
-		Code  string  `json:"code"`
-		Value float64 `json:"value"`
+		Code  string          `json:"code"`
+		Value decimal.Decimal `json:"value"`
 	} `json:"data"`
 }
 
 func (Currency) TableName() string {
 	return "currency"
 }
+
+// CurrencyStats summarizes the history of a currency within a date range
+type CurrencyStats struct {
+	Code    string              `json:"code"`
+	From    string              `json:"from"`
+	To      string              `json:"to"`
+	Samples int64               `json:"samples"`
+	Min     decimal.NullDecimal `json:"min" swaggertype:"number"`
+	Max     decimal.NullDecimal `json:"max" swaggertype:"number"`
+	Mean    decimal.NullDecimal `json:"mean" swaggertype:"number"`
+	StdDev  decimal.NullDecimal `json:"stddev" swaggertype:"number"`
+	// Change is the percent change from the first rate to the last
+	Change decimal.NullDecimal `json:"change" swaggertype:"number"`
+	// Volatility is the annualized standard deviation of the log returns
+	Volatility decimal.NullDecimal `json:"volatility" swaggertype:"number"`
+	// SMA and EMA are the moving averages at the end of the range, by window in samples
+	SMA map[string]decimal.NullDecimal `json:"sma" swaggertype:"object,number"`
+	EMA map[string]decimal.NullDecimal `json:"ema" swaggertype:"object,number"`
+}
+
+// CorrelationMatrix holds the correlation of the log returns of every pair of currencies
+type CorrelationMatrix struct {
+	Symbols []string `json:"symbols"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Step    string   `json:"step"`
+	// Matrix holds the correlation of Symbols[i] and Symbols[j] at [i][j], null without enough returns
+	Matrix [][]*float64 `json:"matrix"`
+	// Observations holds the number of returns every correlation was computed from
+	Observations [][]int `json:"observations"`
+}
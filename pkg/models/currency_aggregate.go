@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CurrencyAggregate rolls up the samples of a currency within a time bucket
+type CurrencyAggregate struct {
+	Code   string    `json:"code" gorm:"primaryKey"`
+	Bucket time.Time `json:"bucket" gorm:"primaryKey;type:timestamp"`
+	// Value is the average rate over the bucket
+	Value   decimal.Decimal `json:"value" gorm:"type:numeric;not null"`
+	Low     decimal.Decimal `json:"low" gorm:"type:numeric;not null"`
+	High    decimal.Decimal `json:"high" gorm:"type:numeric;not null"`
+	Samples int64           `json:"samples" gorm:"not null"`
+}
+
+// CurrencyHourly holds hourly aggregates of samples older than the raw retention
+type CurrencyHourly struct {
+	CurrencyAggregate
+}
+
+// CurrencyDaily holds daily aggregates of samples older than the hourly retention
+type CurrencyDaily struct {
+	CurrencyAggregate
+}
+
+func (CurrencyHourly) TableName() string {
+	return "currency_hourly"
+}
+
+func (CurrencyDaily) TableName() string {
+	return "currency_daily"
+}
@@ -0,0 +1,15 @@
+package models
+
+// CurrencyMetadata describes a currency, identified by its ISO 4217 code
+type CurrencyMetadata struct {
+	Code string `json:"code" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"not null"`
+	// MinorUnits is the number of decimals amounts are displayed with
+	MinorUnits int    `json:"minor_units" gorm:"not null;default:2"`
+	Symbol     string `json:"symbol" gorm:"not null;default:''"`
+	Active     bool   `json:"active" gorm:"not null;default:true"`
+}
+
+func (CurrencyMetadata) TableName() string {
+	return "currencies"
+}
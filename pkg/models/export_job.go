@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+const (
+	ExportQueued  = "queued"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+)
+
+// ExportJob is an export of rate history written to storage in the background
+type ExportJob struct {
+	ID string `gorm:"primaryKey;size:32"`
+	// UserID is the user who requested the export, the only one who can read it
+	UserID int `gorm:"index;not null"`
+	// Symbols are the comma-separated currencies exported
+	Symbols string `gorm:"not null"`
+	Base    string `gorm:"not null"`
+	// Start and End are the resolved range, in UTC, and TZ the time zone dates are rendered in
+	Start  time.Time `gorm:"not null"`
+	End    time.Time `gorm:"not null"`
+	TZ     string
+	Step   string
+	Fill   string
+	Format string `gorm:"not null"`
+
+	Status string `gorm:"index;not null"`
+	Error  string
+	// Attempts counts the times a worker picked the job up
+	Attempts   int `gorm:"not null;default:0"`
+	Rows       int64
+	Size       int64
+	StorageKey string
+
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+	ExpiresAt  time.Time `gorm:"index;not null"`
+}
+
+func (ExportJob) TableName() string {
+	return "export_job"
+}
+
+// ExportRequest asks for an export job, with the same parameters as /history
+type ExportRequest struct {
+	Symbols []string `json:"symbols" binding:"required"`
+	Base    string   `json:"base"`
+	Finit   string   `json:"finit"`
+	Fend    string   `json:"fend"`
+	TZ      string   `json:"tz"`
+	Step    string   `json:"step"`
+	Fill    string   `json:"fill"`
+	// Format is csv, ndjson or parquet
+	Format string `json:"format" binding:"required"`
+}
+
+// ExportStatus is the public view of an export job
+type ExportStatus struct {
+	ID      string   `json:"id"`
+	Status  string   `json:"status"`
+	Symbols []string `json:"symbols"`
+	Base    string   `json:"base"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Step    string   `json:"step,omitempty"`
+	Fill    string   `json:"fill,omitempty"`
+	Format  string   `json:"format"`
+	Rows    int64    `json:"rows"`
+	Size    int64    `json:"size"`
+	// ErrorCode identifies why a failed job failed, and Error describes it
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// DownloadURL links to the file once the job is done
+	DownloadURL string     `json:"download_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
@@ -2,12 +2,30 @@ package models
 
 import "time"
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	ID        int       `json:"id" gorm:"primaryKey;autoIncrement:true"`
-	Username  string    `json:"username" gorm:"uniqueIndex"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+	ID          int     `json:"id" gorm:"primaryKey;autoIncrement:true"`
+	Username    string  `json:"username" gorm:"uniqueIndex"`
+	Password    string  `json:"password"`
+	Role        string  `json:"role" gorm:"not null;default:user"`
+	TOTPSecret  string  `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabled bool    `json:"totp_enabled" gorm:"column:totp_enabled;not null;default:false"`
+	OIDCSubject *string `json:"-" gorm:"column:oidc_subject;uniqueIndex"`
+	// TokenVersion is bumped to revoke every token issued before, such as when the password changes
+	TokenVersion int       `json:"-" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+}
+
+type RecoveryCode struct {
+	ID       uint       `gorm:"primaryKey"`
+	UserID   int        `gorm:"index; not null"`
+	CodeHash string     `gorm:"not null"`
+	UsedAt   *time.Time `gorm:"type:timestamp"`
 }
 
 type LoginUser struct {
@@ -15,6 +33,51 @@ type LoginUser struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type UserProfile struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type ChangePassword struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type TwoFactorCode struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorLogin struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	// Code is either a TOTP code or an unused recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// Profile returns the public view of the user, without the password hash
+func (u User) Profile() UserProfile {
+	return UserProfile{
+		ID:          u.ID,
+		Username:    u.Username,
+		Role:        u.Role,
+		TOTPEnabled: u.TOTPEnabled,
+		CreatedAt:   u.CreatedAt,
+		UpdatedAt:   u.UpdatedAt,
+	}
+}
+
 func (User) TableName() string {
 	return "user"
 }
+
+func (RecoveryCode) TableName() string {
+	return "recovery_code"
+}
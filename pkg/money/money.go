@@ -0,0 +1,98 @@
+// Package money rounds and converts amounts with decimal precision.
+package money
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// RoundingMode decides how amounts halfway between two minor units are rounded
+type RoundingMode string
+
+// Rounding modes selected with MONEY_ROUNDING
+const (
+	// HalfEven rounds to the even neighbour, the banker's rounding
+	HalfEven RoundingMode = "half-even"
+	// HalfUp rounds away from zero
+	HalfUp RoundingMode = "half-up"
+)
+
+// Rounding is the mode conversion results are rounded with
+var Rounding = loadRounding()
+
+func loadRounding() RoundingMode {
+	mode, err := ParseRoundingMode(config.String("MONEY_ROUNDING", string(HalfEven)))
+	if err != nil {
+		log.Printf("%s, rounding %s\n", err, HalfEven)
+		return HalfEven
+	}
+
+	return mode
+}
+
+// ParseRoundingMode returns the rounding mode named s
+func ParseRoundingMode(s string) (RoundingMode, error) {
+	switch mode := RoundingMode(s); mode {
+	case HalfEven, HalfUp:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown rounding mode %q", s)
+	}
+}
+
+// Round rounds amount to minorUnits decimals
+func (m RoundingMode) Round(amount decimal.Decimal, minorUnits int32) decimal.Decimal {
+	if m == HalfUp {
+		return amount.Round(minorUnits)
+	}
+
+	return amount.RoundBank(minorUnits)
+}
+
+// Divide returns a / b rounded to places decimals. Halfway cases are told from the exact remainder, so the quotient
+// is rounded once rather than first to the default division precision.
+func (m RoundingMode) Divide(a, b decimal.Decimal, places int32) decimal.Decimal {
+	quotient, remainder := a.QuoRem(b, places)
+	if remainder.IsZero() {
+		return quotient
+	}
+
+	// The quotient is truncated, and the remainder decides whether it is at least halfway to the next unit
+	unit := decimal.New(1, -places)
+	half := remainder.Abs().Mul(decimal.NewFromInt(2)).Cmp(b.Abs().Mul(unit))
+	if half < 0 || half == 0 && m == HalfEven && quotient.Shift(places).BigInt().Bit(0) == 0 {
+		return quotient
+	}
+
+	if a.Sign() != b.Sign() {
+		return quotient.Sub(unit)
+	}
+	return quotient.Add(unit)
+}
+
+// RateDecimals is the number of decimals cross rates are rounded to
+const RateDecimals = 16
+
+// CrossRate returns how much of a currency one unit of another is worth, given their rates fromRate and toRate
+// against the same base, rounded to RateDecimals
+func CrossRate(fromRate, toRate decimal.Decimal) (decimal.Decimal, error) {
+	if fromRate.IsZero() {
+		return decimal.Decimal{}, fmt.Errorf("cannot quote against a currency with a zero rate")
+	}
+
+	return Rounding.Divide(toRate, fromRate, RateDecimals), nil
+}
+
+// Convert converts amount between two currencies given their rates against the same base,
+// rounding the result to the minor units of the target currency
+func Convert(amount, fromRate, toRate decimal.Decimal, minorUnits int32) (decimal.Decimal, error) {
+	if fromRate.IsZero() {
+		return decimal.Decimal{}, fmt.Errorf("cannot convert from a currency with a zero rate")
+	}
+
+	// Multiplying first keeps the division, the only inexact step, to the end
+	return Rounding.Divide(amount.Mul(toRate), fromRate, minorUnits), nil
+}
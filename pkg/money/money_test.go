@@ -0,0 +1,114 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundingMode_Round(t *testing.T) {
+	tests := []struct {
+		mode     RoundingMode
+		amount   string
+		places   int32
+		expected string
+	}{
+		{HalfEven, "2.345", 2, "2.34"},
+		{HalfEven, "2.355", 2, "2.36"},
+		{HalfEven, "-2.345", 2, "-2.34"},
+		{HalfUp, "2.345", 2, "2.35"},
+		{HalfUp, "-2.345", 2, "-2.35"},
+		{HalfEven, "1234.5", 0, "1234"},
+		{HalfUp, "0.1235", 3, "0.124"},
+	}
+
+	for _, test := range tests {
+		// When
+		rounded := test.mode.Round(decimal.RequireFromString(test.amount), test.places)
+
+		// Then
+		require.Equal(t, test.expected, rounded.String(), "%s %s", test.mode, test.amount)
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	// When
+	mode, err := ParseRoundingMode("half-up")
+	_, invalidErr := ParseRoundingMode("ceiling")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, HalfUp, mode)
+	require.Error(t, invalidErr)
+}
+
+func TestConvert(t *testing.T) {
+	// Given rates against USD
+	mxn := decimal.RequireFromString("17.0625")
+	jpy := decimal.RequireFromString("150.32")
+
+	// When
+	yen, err := Convert(decimal.RequireFromString("100"), mxn, jpy, 0)
+
+	// Then 100 MXN are rounded to whole yen
+	require.NoError(t, err)
+	require.Equal(t, "881", yen.String())
+}
+
+func TestConvert_NoFloatArtifacts(t *testing.T) {
+	// When
+	converted, err := Convert(decimal.RequireFromString("0.1"), decimal.NewFromInt(1), decimal.RequireFromString("0.2"), 4)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "0.02", converted.String())
+}
+
+func TestConvert_ZeroRate(t *testing.T) {
+	// When
+	_, err := Convert(decimal.NewFromInt(1), decimal.Zero, decimal.NewFromInt(1), 2)
+
+	// Then
+	require.Error(t, err)
+}
+
+func TestRoundingMode_Divide(t *testing.T) {
+	tests := []struct {
+		mode     RoundingMode
+		a, b     string
+		places   int32
+		expected string
+	}{
+		{HalfEven, "1", "3", 4, "0.3333"},
+		{HalfEven, "2", "3", 4, "0.6667"},
+		{HalfEven, "-2", "3", 4, "-0.6667"},
+		{HalfEven, "2", "-3", 4, "-0.6667"},
+		{HalfEven, "0.125", "1", 2, "0.12"},
+		{HalfUp, "0.125", "1", 2, "0.13"},
+		{HalfEven, "-0.125", "1", 2, "-0.12"},
+		{HalfUp, "-0.125", "1", 2, "-0.13"},
+		{HalfEven, "0.135", "1", 2, "0.14"},
+		// Just above halfway, which rounding to 16 decimals first would take for halfway
+		{HalfEven, "0.12500000000000000001", "1", 2, "0.13"},
+	}
+
+	for _, test := range tests {
+		// When
+		quotient := test.mode.Divide(decimal.RequireFromString(test.a), decimal.RequireFromString(test.b), test.places)
+
+		// Then
+		require.Equal(t, test.expected, quotient.String(), "%s %s / %s", test.mode, test.a, test.b)
+	}
+}
+
+func TestCrossRate(t *testing.T) {
+	// When
+	rate, err := CrossRate(decimal.RequireFromString("0.92"), decimal.RequireFromString("17.05"))
+	_, zeroErr := CrossRate(decimal.Zero, decimal.NewFromInt(1))
+
+	// Then 1 EUR is worth 17.05 / 0.92 MXN
+	require.NoError(t, err)
+	require.Equal(t, "18.5326086956521739", rate.String())
+	require.Error(t, zeroErr)
+}
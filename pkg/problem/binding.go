@@ -0,0 +1,55 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report fields by their JSON name, the one clients send
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// Binding converts an error binding a request body into a validation error listing the fields that failed
+func Binding(err error) *Error {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]FieldError, len(validationErrors))
+		for i, fieldError := range validationErrors {
+			message := fmt.Sprintf("%s is invalid", fieldError.Field())
+			if fieldError.Tag() == "required" {
+				message = fmt.Sprintf("%s is required", fieldError.Field())
+			}
+			fields[i] = FieldError{Field: fieldError.Field(), Code: fieldError.Tag(), Message: message}
+		}
+
+		return &Error{Status: http.StatusBadRequest, Code: CodeValidation, Detail: "The request body is invalid", Fields: fields}
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return &Error{
+			Status: http.StatusBadRequest,
+			Code:   CodeValidation,
+			Detail: "The request body is invalid",
+			Fields: []FieldError{{Field: typeError.Field, Code: "type", Message: fmt.Sprintf("%s must be a %s", typeError.Field, typeError.Type)}},
+		}
+	}
+
+	return New(http.StatusBadRequest, CodeBadRequest, "The request body is not valid JSON")
+}
@@ -0,0 +1,139 @@
+// Package problem answers API errors as RFC 7807 problem details, with stable machine-readable codes.
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// typePrefix makes a code the URI of its problem type
+const typePrefix = "urn:boletia-currency-api:problem:"
+
+// Codes shared by every endpoint, endpoints define their own next to their handlers
+const (
+	CodeBadRequest       = "bad_request"
+	CodeValidation       = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal_error"
+)
+
+// FieldError is a parameter, or body field, that failed validation
+type FieldError struct {
+	Field string `json:"field"`
+	// Code is the rule the value broke, like required
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an error answered to the client
+type Error struct {
+	Status int
+	// Code identifies the error for clients, and must not change once published
+	Code string
+	// Detail is the explanation sent to the client
+	Detail string
+	Fields []FieldError
+	// Err is the internal cause, logged and never sent
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %s", e.Code, e.Detail, e.Err)
+	}
+
+	return e.Code + ": " + e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error answered with status
+func New(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// Invalid returns a validation error of a single field
+func Invalid(field, message string) *Error {
+	return &Error{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidation,
+		Detail: message,
+		Fields: []FieldError{{Field: field, Code: "invalid", Message: message}},
+	}
+}
+
+// Internal wraps an unexpected error, answered without revealing it
+func Internal(err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: "An unexpected error occurred", Err: err}
+}
+
+// Details is the body of an error response
+type Details struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Code and the members below extend RFC 7807
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// fieldError is implemented by the errors of packages that parse request values without answering requests, like
+// *daterange.FieldError, to name the field the value was read from
+type fieldError interface {
+	error
+	InvalidField() string
+}
+
+// From returns err as an Error: the one it wraps, a validation error when it names the field it was read from, or an
+// internal error otherwise
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var field fieldError
+	if errors.As(err, &field) {
+		return Invalid(field.InvalidField(), field.Error())
+	}
+
+	return Internal(err)
+}
+
+// Write answers with err as problem details and aborts the request. Errors are converted with From, and internal errors are logged with the request ID, so a client report can be matched with its cause.
+func Write(c *gin.Context, err error) {
+	e := From(err)
+
+	requestID := c.GetString("request_id")
+	if e.Status >= http.StatusInternalServerError {
+		log.Printf("Error serving %s %s request_id=%s: %s\n", c.Request.Method, c.Request.URL.Path, requestID, e)
+	}
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(e.Status, Details{
+		Type:      typePrefix + e.Code,
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  c.Request.URL.Path,
+		Code:      e.Code,
+		RequestID: requestID,
+		Errors:    e.Fields,
+	})
+}
@@ -0,0 +1,112 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func performProblemRequest(handler gin.HandlerFunc, body string) (*httptest.ResponseRecorder, Details) {
+	r := gin.New()
+	r.POST("/problem", func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		handler(c)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/problem", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	var details Details
+	_ = json.Unmarshal(w.Body.Bytes(), &details)
+	return w, details
+}
+
+func TestWrite_Error(t *testing.T) {
+	// When
+	w, details := performProblemRequest(func(c *gin.Context) {
+		Write(c, Invalid("symbols", "Invalid symbols"))
+	}, "")
+
+	// Then
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	require.Equal(t, Details{
+		Type:      "urn:boletia-currency-api:problem:validation_failed",
+		Title:     "Bad Request",
+		Status:    http.StatusBadRequest,
+		Detail:    "Invalid symbols",
+		Instance:  "/problem",
+		Code:      CodeValidation,
+		RequestID: "req-1",
+		Errors:    []FieldError{{Field: "symbols", Code: "invalid", Message: "Invalid symbols"}},
+	}, details)
+}
+
+func TestWrite_Internal(t *testing.T) {
+	// When an unexpected error is written
+	w, details := performProblemRequest(func(c *gin.Context) {
+		Write(c, errors.New(`relation "currency" does not exist`))
+	}, "")
+
+	// Then it is answered without revealing it
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Equal(t, CodeInternal, details.Code)
+	require.Equal(t, "An unexpected error occurred", details.Detail)
+	require.NotContains(t, w.Body.String(), "relation")
+}
+
+type bindingBody struct {
+	Symbols []string `json:"symbols" binding:"required"`
+	Format  string   `json:"format" binding:"omitempty,oneof=csv ndjson"`
+}
+
+// fieldErr names the field it was read from, like *daterange.FieldError
+type fieldErr struct{}
+
+func (fieldErr) Error() string        { return "Invalid step" }
+func (fieldErr) InvalidField() string { return "step" }
+
+func TestFrom(t *testing.T) {
+	// When
+	own := From(fmt.Errorf("wrapped: %w", New(http.StatusNotFound, "no_rates", "No currencies found")))
+	field := From(fieldErr{})
+	internal := From(errors.New("connection refused"))
+
+	// Then
+	require.Equal(t, "no_rates", own.Code)
+	require.Equal(t, Invalid("step", "Invalid step"), field)
+	require.Equal(t, CodeInternal, internal.Code)
+}
+
+func TestBinding(t *testing.T) {
+	bind := func(c *gin.Context) {
+		var body bindingBody
+		if err := c.ShouldBindJSON(&body); err != nil {
+			Write(c, Binding(err))
+		}
+	}
+
+	// When
+	_, invalid := performProblemRequest(bind, `{"format":"xml"}`)
+	_, mistyped := performProblemRequest(bind, `{"symbols":"MXN"}`)
+	_, malformed := performProblemRequest(bind, `{"symbols":`)
+
+	// Then fields are reported by their JSON name
+	require.Equal(t, CodeValidation, invalid.Code)
+	require.Equal(t, []FieldError{
+		{Field: "symbols", Code: "required", Message: "symbols is required"},
+		{Field: "format", Code: "oneof", Message: "format is invalid"},
+	}, invalid.Errors)
+	require.Equal(t, []FieldError{{Field: "symbols", Code: "type", Message: "symbols must be a []string"}}, mistyped.Errors)
+	require.Equal(t, CodeBadRequest, malformed.Code)
+	require.Empty(t, malformed.Errors)
+}
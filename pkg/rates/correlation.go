@@ -0,0 +1,88 @@
+package rates
+
+import (
+	"math"
+	"time"
+)
+
+// Correlation returns the Pearson correlation of the log returns of every pair of codes, from history resampled
+// on grid, along with the number of returns each one was computed from. Returns are only taken between adjacent
+// buckets, and a pair only from the buckets where both currencies have one, so gaps don't skew the result.
+// A correlation is nil when it has fewer than two returns or a currency did not move.
+func Correlation(history []Sample, grid Grid, codes []string) ([][]*float64, [][]int) {
+	index := make(map[time.Time]int, grid.Len())
+	for i, bucket := range grid.Buckets {
+		index[bucket] = i
+	}
+
+	// returns holds the log return into every bucket, by code
+	returns := make(map[string]map[int]float64, len(codes))
+	for i, sample := range history {
+		if i == 0 || history[i-1].Code != sample.Code {
+			continue
+		}
+
+		previous := history[i-1]
+		bucket, ok := index[sample.Time]
+		if !ok || index[previous.Time] != bucket-1 || previous.Value.Sign() <= 0 || sample.Value.Sign() <= 0 {
+			continue
+		}
+
+		if returns[sample.Code] == nil {
+			returns[sample.Code] = make(map[int]float64)
+		}
+		returns[sample.Code][bucket] = math.Log(sample.Value.Div(previous.Value).InexactFloat64())
+	}
+
+	matrix := make([][]*float64, len(codes))
+	observations := make([][]int, len(codes))
+	for i := range codes {
+		matrix[i] = make([]*float64, len(codes))
+		observations[i] = make([]int, len(codes))
+		for j := range codes {
+			matrix[i][j], observations[i][j] = pearson(returns[codes[i]], returns[codes[j]])
+		}
+	}
+
+	return matrix, observations
+}
+
+// pearson correlates x and y over the buckets where both have a return
+func pearson(x, y map[int]float64) (*float64, int) {
+	var xs, ys []float64
+	for bucket, xr := range x {
+		if yr, ok := y[bucket]; ok {
+			xs = append(xs, xr)
+			ys = append(ys, yr)
+		}
+	}
+
+	n := len(xs)
+	if n < 2 {
+		return nil, n
+	}
+
+	var xMean, yMean float64
+	for i := range xs {
+		xMean += xs[i]
+		yMean += ys[i]
+	}
+	xMean /= float64(n)
+	yMean /= float64(n)
+
+	var covariance, xVariance, yVariance float64
+	for i := range xs {
+		dx, dy := xs[i]-xMean, ys[i]-yMean
+		covariance += dx * dy
+		xVariance += dx * dx
+		yVariance += dy * dy
+	}
+
+	if xVariance == 0 || yVariance == 0 {
+		return nil, n
+	}
+
+	// Rounding can push perfectly correlated series past 1
+	correlation := math.Max(-1, math.Min(1, covariance/math.Sqrt(xVariance*yVariance)))
+	return &correlation, n
+}
@@ -0,0 +1,56 @@
+package rates
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func daily(code string, values ...string) []Sample {
+	var history []Sample
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		history = append(history, Sample{Code: code, Time: time.Date(2024, 3, 1+i, 0, 0, 0, 0, time.UTC), Value: decimal.RequireFromString(value)})
+	}
+
+	return history
+}
+
+func TestCorrelation(t *testing.T) {
+	// Given BRL moving like EUR, MXN against it, and a missing EUR day
+	grid := NewGrid(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC), 24*time.Hour, time.UTC)
+
+	var history []Sample
+	history = append(history, daily("BRL", "5", "5.5", "5", "5.5", "6.05", "5.5")...)
+	history = append(history, daily("EUR", "1", "1.1", "1", "", "1.21", "1.1")...)
+	history = append(history, daily("MXN", "20", "18", "20", "18", "16.2", "18")...)
+
+	// When
+	matrix, observations := Correlation(history, grid, []string{"BRL", "EUR", "MXN"})
+
+	// Then the two EUR returns around the missing day are left out
+	require.InDelta(t, 1, *matrix[0][1], 1e-9)
+	require.InDelta(t, -1, *matrix[0][2], 1e-9)
+	require.InDelta(t, 1, *matrix[1][1], 1e-9)
+	require.Equal(t, 3, observations[0][1])
+	require.Equal(t, 5, observations[0][2])
+	require.Equal(t, *matrix[0][1], *matrix[1][0])
+}
+
+func TestCorrelation_NotEnoughReturns(t *testing.T) {
+	// Given
+	grid := NewGrid(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), 24*time.Hour, time.UTC)
+	history := append(daily("EUR", "1", "1.1"), daily("MXN", "20", "20")...)
+
+	// When
+	matrix, observations := Correlation(history, grid, []string{"EUR", "MXN", "BRL"})
+
+	// Then
+	require.Nil(t, matrix[0][1])
+	require.Equal(t, 1, observations[0][1])
+	require.Nil(t, matrix[2][2])
+}
@@ -0,0 +1,177 @@
+// Package rates reads rate history across its resolution tiers.
+package rates
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/money"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Base is the currency the stored rates are quoted against
+var Base = config.String("CURRENCY_BASE", "USD")
+
+// Sample is the rate of a currency at a time
+type Sample struct {
+	Code  string
+	Time  time.Time       `gorm:"column:created_at"`
+	Value decimal.Decimal `gorm:"type:numeric"`
+	// Filled is set on samples Resample filled a gap with
+	Filled bool `gorm:"-"`
+}
+
+// historyUnion reads raw samples along with the hourly and daily aggregates of older ones.
+// The retention job moves samples between tiers in a single transaction, so they never overlap.
+const historyUnion = `SELECT code, created_at, value FROM currency
+	WHERE code IN @codes AND created_at BETWEEN @start AND @end
+UNION ALL
+SELECT code, bucket AS created_at, value FROM currency_hourly
+	WHERE code IN @codes AND bucket BETWEEN @start AND @end
+UNION ALL
+SELECT code, bucket AS created_at, value FROM currency_daily
+	WHERE code IN @codes AND bucket BETWEEN @start AND @end`
+
+const historyQuery = historyUnion + `
+ORDER BY code, created_at`
+
+// streamQuery orders rates by time, so rates taken together are adjacent and can be rebased as they are read
+const streamQuery = historyUnion + `
+ORDER BY created_at, code`
+
+// History returns the rates of the currencies in codes between start and end, by code and oldest first.
+// Recent rates are raw samples, older ones hourly or daily averages depending on their age.
+func History(ctx context.Context, db *gorm.DB, codes []string, start, end time.Time) ([]Sample, error) {
+	var history []Sample
+
+	// Table routes the query to the currency read replicas
+	err := db.WithContext(ctx).Table(models.Currency{}.TableName()).
+		Raw(historyQuery, map[string]interface{}{"codes": codes, "start": start, "end": end}).
+		Scan(&history).Error
+
+	return history, err
+}
+
+// allQuery reads every stored rate, across the tiers like historyUnion
+const allQuery = `SELECT code, created_at, value FROM currency
+UNION ALL
+SELECT code, bucket AS created_at, value FROM currency_hourly
+UNION ALL
+SELECT code, bucket AS created_at, value FROM currency_daily
+ORDER BY code, created_at`
+
+// All returns every stored rate of every currency, by code and oldest first, at the resolution kept for each period
+func All(ctx context.Context, db *gorm.DB) ([]Sample, error) {
+	var all []Sample
+
+	// Table routes the query to the currency read replicas
+	err := db.WithContext(ctx).Table(models.Currency{}.TableName()).Raw(allQuery).Scan(&all).Error
+
+	return all, err
+}
+
+// latestQuery reads the last raw sample of every known currency, found with one descent of the (code, created_at)
+// index per currency rather than by reading the history of them all. The %s placeholder receives the filter on codes,
+// if any.
+const latestQuery = `SELECT c.code, l.created_at, l.value FROM currencies c
+	CROSS JOIN LATERAL (SELECT created_at, value FROM currency
+		WHERE code = c.code ORDER BY created_at DESC LIMIT 1) l%s
+	ORDER BY c.code`
+
+// Latest returns the last rate of every currency in codes that has one, or of every currency when codes is nil, by code.
+// Rates are read from the primary, since a lagging replica would miss the rates the daemon just stored.
+func Latest(ctx context.Context, db *gorm.DB, codes []string) ([]Sample, error) {
+	var latest []Sample
+
+	query := db.WithContext(ctx).Clauses(dbresolver.Write).Table(models.Currency{}.TableName())
+	if codes == nil {
+		query = query.Raw(fmt.Sprintf(latestQuery, ""))
+	} else {
+		query = query.Raw(fmt.Sprintf(latestQuery, "\n\tWHERE c.code IN @codes"), map[string]interface{}{"codes": codes})
+	}
+
+	err := query.Scan(&latest).Error
+
+	return latest, err
+}
+
+// Stream calls fn with the rates of the currencies in codes between start and end, quoted against base, oldest first.
+// Rows are read from a cursor and rebased a time at a time, so the history is never held in memory.
+func Stream(ctx context.Context, db *gorm.DB, codes []string, base string, start, end time.Time, fn func(Sample) error) error {
+	rows, err := db.WithContext(ctx).Table(models.Currency{}.TableName()).
+		Raw(streamQuery, map[string]interface{}{"codes": codes, "start": start, "end": end}).
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// batch holds the rates read at the same time
+	var batch []Sample
+	flush := func() error {
+		for _, rate := range Rebase(batch, base) {
+			if err := fn(rate); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		var rate Sample
+		if err := rows.Scan(&rate.Code, &rate.Time, &rate.Value); err != nil {
+			return err
+		}
+
+		if len(batch) > 0 && !batch[0].Time.Equal(rate.Time) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, rate)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// Rebase quotes history against base instead of Base, dividing every rate by the rate of base at the same time with
+// the configured money rounding.
+// Rates at times without a rate of base are dropped, and rates computed from a filled one are filled.
+func Rebase(history []Sample, base string) []Sample {
+	if base == Base {
+		return history
+	}
+
+	baseRates := make(map[time.Time]Sample)
+	for _, rate := range history {
+		if rate.Code == base && !rate.Value.IsZero() {
+			baseRates[rate.Time] = rate
+		}
+	}
+
+	rebased := make([]Sample, 0, len(history))
+	for _, rate := range history {
+		baseRate, ok := baseRates[rate.Time]
+		if !ok {
+			continue
+		}
+
+		var err error
+		if rate.Value, err = money.CrossRate(baseRate.Value, rate.Value); err != nil {
+			continue
+		}
+		rate.Filled = rate.Filled || baseRate.Filled
+		rebased = append(rebased, rate)
+	}
+
+	return rebased
+}
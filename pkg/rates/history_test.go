@@ -0,0 +1,146 @@
+package rates
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestHistory_ReadsEveryTier(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) UNION ALL SELECT code, bucket AS created_at, value FROM currency_hourly (.+) UNION ALL SELECT code, bucket AS created_at, value FROM currency_daily (.+) ORDER BY code, created_at`).
+		WithArgs("EUR", "USD", start, end, "EUR", "USD", start, end, "EUR", "USD", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", start, "0.92").
+			AddRow("USD", start, "1").
+			AddRow("USD", end, "1"))
+
+	// When
+	history, err := History(context.Background(), gormDB, []string{"EUR", "USD"}, start, end)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	require.Equal(t, "0.92", history[0].Value.String())
+	require.Equal(t, end, history[2].Time)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestRebase(t *testing.T) {
+	// Given rates against USD, with no EUR rate at the second time
+	first := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	history := []Sample{
+		{Code: "EUR", Time: first, Value: decimal.RequireFromString("0.8")},
+		{Code: "MXN", Time: first, Value: decimal.RequireFromString("17"), Filled: true},
+		{Code: "MXN", Time: second, Value: decimal.RequireFromString("17.2")},
+	}
+
+	// When
+	rebased := Rebase(history, "EUR")
+
+	// Then
+	require.Len(t, rebased, 2)
+	require.Equal(t, "1", rebased[0].Value.String())
+	require.Equal(t, "21.25", rebased[1].Value.String())
+	require.True(t, rebased[1].Filled)
+	require.Equal(t, "0.8", history[0].Value.String())
+}
+
+func TestRebase_StoredBase(t *testing.T) {
+	// Given
+	history := []Sample{{Code: "EUR", Value: decimal.RequireFromString("0.8")}}
+
+	// When
+	rebased := Rebase(history, Base)
+
+	// Then
+	require.Equal(t, history, rebased)
+}
+
+func TestStream_RebasesEveryTime(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	dbMock.ExpectQuery(`SELECT code, created_at, value FROM currency (.+) ORDER BY created_at, code`).
+		WithArgs("EUR", "MXN", start, end, "EUR", "MXN", start, end, "EUR", "MXN", start, end).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", start, "0.8").
+			AddRow("MXN", start, "17").
+			AddRow("MXN", end, "17.2").
+			AddRow("EUR", end, "0.86").
+			AddRow("MXN", end.Add(time.Hour), "17.3"))
+
+	// When
+	var streamed []Sample
+	err := Stream(context.Background(), gormDB, []string{"EUR", "MXN"}, "EUR", start, end, func(rate Sample) error {
+		streamed = append(streamed, rate)
+		return nil
+	})
+
+	// Then the last MXN rate is dropped, having no EUR rate to be divided by
+	require.NoError(t, err)
+	require.Len(t, streamed, 4)
+	require.Equal(t, "21.25", streamed[1].Value.String())
+	require.Equal(t, "MXN", streamed[2].Code)
+	require.Equal(t, "20", streamed[2].Value.String())
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestLatest(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(`SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+WHERE c.code IN \(\$1,\$2\)\s+ORDER BY c.code`).
+		WithArgs("EUR", "MXN").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).
+			AddRow("EUR", at, "0.92").
+			AddRow("MXN", at, "17.05"))
+
+	// When
+	latest, err := Latest(context.Background(), gormDB, []string{"EUR", "MXN"})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []Sample{
+		{Code: "EUR", Time: at, Value: decimal.RequireFromString("0.92")},
+		{Code: "MXN", Time: at, Value: decimal.RequireFromString("17.05")},
+	}, latest)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestLatest_All(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	at := time.Date(2024, 3, 1, 19, 15, 0, 0, time.UTC)
+	dbMock.ExpectQuery(`SELECT c.code, l.created_at, l.value FROM currencies c\s+CROSS JOIN LATERAL \(.+\) l\s+ORDER BY c.code`).
+		WithoutArgs().
+		WillReturnRows(sqlmock.NewRows([]string{"code", "created_at", "value"}).AddRow("EUR", at, "0.92"))
+
+	// When
+	latest, err := Latest(context.Background(), gormDB, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, latest, 1)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
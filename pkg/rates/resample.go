@@ -0,0 +1,222 @@
+package rates
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+)
+
+// MaxBuckets is the most buckets a resampled series can have
+var MaxBuckets = config.Int("HISTORY_MAX_BUCKETS", 10000)
+
+// Fill is how Resample fills buckets without samples
+type Fill string
+
+// Fill methods selected with the fill query param
+const (
+	// FillNone leaves empty buckets out
+	FillNone Fill = "none"
+	// FillPrevious repeats the last known rate
+	FillPrevious Fill = "previous"
+	// FillLinear interpolates between the known rates around the gap
+	FillLinear Fill = "linear"
+)
+
+// ParseFill returns the fill method named s, FillNone when it is empty
+func ParseFill(s string) (Fill, error) {
+	switch fill := Fill(s); fill {
+	case "":
+		return FillNone, nil
+	case FillNone, FillPrevious, FillLinear:
+		return fill, nil
+	default:
+		return "", fmt.Errorf("unknown fill %q", s)
+	}
+}
+
+var stepPattern = regexp.MustCompile(`^(\d+)([mhd])$`)
+
+// ParseStep parses a bucket length of whole minutes, hours or days, as in 5m, 1h or 1d
+func ParseStep(s string) (time.Duration, error) {
+	match := stepPattern.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("invalid step %q", s)
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid step %q", s)
+	}
+
+	unit := map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[match[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// Resampling is how history is aligned, not at all when Step is zero
+type Resampling struct {
+	Step time.Duration
+	Fill Fill
+}
+
+// Key identifies the resampling in cache keys
+func (r Resampling) Key() string {
+	if r.Step == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("_%s_%s", r.Step, r.Fill)
+}
+
+// ParseResampling parses the step and fill of a history within dateRange. Errors are a *daterange.FieldError naming
+// the param the value was read from.
+func ParseResampling(stepValue, fillValue string, dateRange daterange.Range) (Resampling, error) {
+	fill, err := ParseFill(fillValue)
+	if err != nil {
+		return Resampling{}, &daterange.FieldError{Field: "fill", Message: "Invalid fill"}
+	}
+
+	if stepValue == "" {
+		if fill != FillNone {
+			return Resampling{}, &daterange.FieldError{Field: "fill", Message: "fill requires a step"}
+		}
+		return Resampling{}, nil
+	}
+
+	step, err := ParseRangeStep(stepValue, dateRange)
+	if err != nil {
+		return Resampling{}, err
+	}
+
+	return Resampling{Step: step, Fill: fill}, nil
+}
+
+// ParseRangeStep parses the step of a history within dateRange, which must split it into at most MaxBuckets buckets.
+// Errors are a *daterange.FieldError.
+func ParseRangeStep(value string, dateRange daterange.Range) (time.Duration, error) {
+	step, err := ParseStep(value)
+	if err != nil {
+		return 0, &daterange.FieldError{Field: "step", Message: "Invalid step"}
+	}
+
+	if dateRange.End.Sub(dateRange.Start)/step >= time.Duration(MaxBuckets) {
+		return 0, &daterange.FieldError{Field: "step", Message: fmt.Sprintf("The step must split the range into at most %d buckets", MaxBuckets)}
+	}
+
+	return step, nil
+}
+
+// Grid is a sequence of aligned buckets covering a range
+type Grid struct {
+	// Buckets are the bucket starts, in order
+	Buckets []time.Time
+}
+
+// NewGrid returns the buckets of length step covering start to end. Buckets are aligned on multiples
+// of step since the epoch, or on midnight in loc when step is whole days, so every series shares them.
+func NewGrid(start, end time.Time, step time.Duration, loc *time.Location) Grid {
+	var buckets []time.Time
+
+	if days := int(step / (24 * time.Hour)); step%(24*time.Hour) == 0 {
+		// Days follow the calendar of loc, and may not last 24 hours
+		local := start.In(loc)
+		for bucket := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc); !bucket.After(end); bucket = bucket.AddDate(0, 0, days) {
+			buckets = append(buckets, bucket)
+		}
+	} else {
+		for bucket := start.Truncate(step); !bucket.After(end); bucket = bucket.Add(step) {
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	return Grid{Buckets: buckets}
+}
+
+// Len returns the number of buckets
+func (g Grid) Len() int {
+	return len(g.Buckets)
+}
+
+// bucket returns the index of the bucket holding t, or -1 when it is before the first one
+func (g Grid) bucket(t time.Time) int {
+	return sort.Search(len(g.Buckets), func(i int) bool { return g.Buckets[i].After(t) }) - 1
+}
+
+// Resample aligns history, ordered by code and time, on the buckets of grid. Every bucket takes the last rate
+// sampled within it, the rate at its close, and buckets without samples are filled as fill says.
+func Resample(history []Sample, grid Grid, fill Fill) []Sample {
+	var resampled []Sample
+
+	for start := 0; start < len(history); {
+		end := start
+		for end < len(history) && history[end].Code == history[start].Code {
+			end++
+		}
+
+		resampled = append(resampled, resampleSeries(history[start:end], grid, fill)...)
+		start = end
+	}
+
+	return resampled
+}
+
+// resampleSeries resamples the samples of a single currency
+func resampleSeries(series []Sample, grid Grid, fill Fill) []Sample {
+	code := series[0].Code
+
+	values := make([]*decimal.Decimal, grid.Len())
+	for _, sample := range series {
+		if i := grid.bucket(sample.Time); i >= 0 {
+			value := sample.Value
+			values[i] = &value
+		}
+	}
+
+	var resampled []Sample
+	previous := -1
+	for i, value := range values {
+		if value != nil {
+			resampled = append(resampled, Sample{Code: code, Time: grid.Buckets[i], Value: *value})
+			previous = i
+			continue
+		}
+
+		// Leading gaps have nothing to fill from
+		if previous < 0 {
+			continue
+		}
+
+		switch fill {
+		case FillPrevious:
+			resampled = append(resampled, Sample{Code: code, Time: grid.Buckets[i], Value: *values[previous], Filled: true})
+		case FillLinear:
+			next := nextValue(values, i)
+			if next < 0 {
+				continue
+			}
+
+			elapsed := decimal.NewFromInt(int64(grid.Buckets[i].Sub(grid.Buckets[previous])))
+			span := decimal.NewFromInt(int64(grid.Buckets[next].Sub(grid.Buckets[previous])))
+			value := values[previous].Add(values[next].Sub(*values[previous]).Mul(elapsed).Div(span))
+			resampled = append(resampled, Sample{Code: code, Time: grid.Buckets[i], Value: value, Filled: true})
+		}
+	}
+
+	return resampled
+}
+
+// nextValue returns the index of the first bucket after i with a value, or -1
+func nextValue(values []*decimal.Decimal, i int) int {
+	for j := i + 1; j < len(values); j++ {
+		if values[j] != nil {
+			return j
+		}
+	}
+
+	return -1
+}
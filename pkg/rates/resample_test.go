@@ -0,0 +1,122 @@
+package rates
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/daterange"
+)
+
+func TestParseStep(t *testing.T) {
+	tests := map[string]time.Duration{"5m": 5 * time.Minute, "1h": time.Hour, "1d": 24 * time.Hour, "2d": 48 * time.Hour}
+
+	for value, expected := range tests {
+		// When
+		step, err := ParseStep(value)
+
+		// Then
+		require.NoError(t, err, value)
+		require.Equal(t, expected, step, value)
+	}
+
+	for _, value := range []string{"", "0m", "1w", "1.5h", "-1h", "h"} {
+		_, err := ParseStep(value)
+		require.Error(t, err, value)
+	}
+}
+
+func TestParseResampling(t *testing.T) {
+	// Given
+	day := daterange.Range{Start: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)}
+
+	// When
+	resampling, err := ParseResampling("1h", "previous", day)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, Resampling{Step: time.Hour, Fill: FillPrevious}, resampling)
+	require.Equal(t, "_1h0m0s_previous", resampling.Key())
+
+	tests := []struct {
+		step, fill string
+		field      string
+	}{
+		{"1h", "nearest", "fill"},
+		{"", "linear", "fill"},
+		{"1w", "", "step"},
+		{"1m", "", "step"},
+	}
+	for _, test := range tests {
+		_, err := ParseResampling(test.step, test.fill, daterange.Range{Start: day.Start, End: day.Start.AddDate(0, 0, 30)})
+
+		var fieldErr *daterange.FieldError
+		require.ErrorAs(t, err, &fieldErr, test.step)
+		require.Equal(t, test.field, fieldErr.Field, test.step)
+	}
+}
+
+func TestNewGrid_Aligned(t *testing.T) {
+	// When
+	grid := NewGrid(time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC), time.Date(2024, 3, 1, 10, 20, 0, 0, time.UTC), 5*time.Minute, time.UTC)
+
+	// Then
+	require.Equal(t, []time.Time{
+		time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 10, 10, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 10, 20, 0, 0, time.UTC),
+	}, grid.Buckets)
+}
+
+func TestNewGrid_DaysInZone(t *testing.T) {
+	// Given a range across the start of daylight saving time in New York
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	// When
+	grid := NewGrid(time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 12, 0, 0, 0, time.UTC), 24*time.Hour, newYork)
+
+	// Then every bucket starts at midnight in New York
+	require.Equal(t, 3, grid.Len())
+	for _, bucket := range grid.Buckets {
+		require.Zero(t, bucket.In(newYork).Hour())
+	}
+}
+
+func TestResample(t *testing.T) {
+	// Given hourly buckets where MXN has no sample at 11:00 and 12:00
+	grid := NewGrid(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), time.Hour, time.UTC)
+	history := []Sample{
+		{Code: "EUR", Time: time.Date(2024, 3, 1, 11, 30, 0, 0, time.UTC), Value: decimal.RequireFromString("0.9")},
+		{Code: "MXN", Time: time.Date(2024, 3, 1, 10, 10, 0, 0, time.UTC), Value: decimal.RequireFromString("16.9")},
+		{Code: "MXN", Time: time.Date(2024, 3, 1, 10, 50, 0, 0, time.UTC), Value: decimal.RequireFromString("17")},
+		{Code: "MXN", Time: time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC), Value: decimal.RequireFromString("17.3")},
+	}
+
+	tests := []struct {
+		fill     Fill
+		expected []string
+	}{
+		{FillNone, []string{"EUR 11 0.9", "MXN 10 17", "MXN 13 17.3"}},
+		{FillPrevious, []string{"EUR 11 0.9", "EUR 12 0.9*", "EUR 13 0.9*", "MXN 10 17", "MXN 11 17*", "MXN 12 17*", "MXN 13 17.3"}},
+		{FillLinear, []string{"EUR 11 0.9", "MXN 10 17", "MXN 11 17.1*", "MXN 12 17.2*", "MXN 13 17.3"}},
+	}
+
+	for _, test := range tests {
+		// When
+		resampled := Resample(history, grid, test.fill)
+
+		// Then the leading gap of EUR is never filled, and its trailing one only from the previous rate
+		var actual []string
+		for _, sample := range resampled {
+			point := sample.Code + " " + sample.Time.Format("15") + " " + sample.Value.String()
+			if sample.Filled {
+				point += "*"
+			}
+			actual = append(actual, point)
+		}
+		require.Equal(t, test.expected, actual, test.fill)
+	}
+}
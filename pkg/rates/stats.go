@@ -0,0 +1,113 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/models"
+	"gorm.io/gorm"
+)
+
+// secondsPerYear is the length of a Julian year, which volatility is annualized over
+const secondsPerYear = 365.25 * 24 * 60 * 60
+
+// statsQuery summarizes the history of a currency. Samples are irregular, as older ones are hourly or daily
+// averages, so every log return is scaled by the square root of its interval in years before its deviation is taken.
+// The %s placeholder receives a column per moving average window.
+const statsQuery = `WITH history AS (` + historyUnion + `),
+returns AS (
+	SELECT value, created_at,
+		ROW_NUMBER() OVER w AS position,
+		ROW_NUMBER() OVER (ORDER BY created_at DESC) AS recency,
+		LN(value / NULLIF(LAG(value) OVER w, 0)) AS log_return,
+		NULLIF(EXTRACT(EPOCH FROM created_at - LAG(created_at) OVER w), 0) AS seconds
+	FROM history
+	WINDOW w AS (ORDER BY created_at)
+)
+SELECT COUNT(*) AS samples, MIN(value) AS min, MAX(value) AS max, AVG(value) AS mean, STDDEV_SAMP(value) AS std_dev,
+	(ARRAY_AGG(value ORDER BY created_at))[1] AS first, (ARRAY_AGG(value ORDER BY created_at DESC))[1] AS last,
+	STDDEV_SAMP(log_return / SQRT(seconds / @year)) AS volatility%s
+FROM returns`
+
+// emaColumn computes the exponential moving average over n samples at the end of the history, seeded with the
+// simple average of its first n samples. The recursion ema = ema + alpha * (value - ema) is unrolled: the seed and
+// every later value weigh alpha, times 1 - alpha for every sample after them.
+const emaColumn = `,
+	CASE WHEN COUNT(*) >= %[1]d THEN
+		POWER(1 - %[2]s, COUNT(*) - %[1]d) * AVG(value) FILTER (WHERE position <= %[1]d)
+		+ %[2]s * COALESCE(SUM(POWER(1 - %[2]s, recency - 1) * value) FILTER (WHERE position > %[1]d), 0)
+	END AS ema_%[1]d`
+
+// Stats summarizes the history of the currency code between start and end, with the simple moving average
+// over the last n samples for every n in sma, and the exponential one for every n in ema
+func Stats(ctx context.Context, db *gorm.DB, code string, start, end time.Time, sma, ema []int) (models.CurrencyStats, error) {
+	var columns strings.Builder
+	for _, n := range sma {
+		fmt.Fprintf(&columns, ",\n\tCASE WHEN COUNT(*) >= %[1]d THEN AVG(value) FILTER (WHERE recency <= %[1]d) END AS sma_%[1]d", n)
+	}
+	for _, n := range ema {
+		fmt.Fprintf(&columns, emaColumn, n, fmt.Sprintf("(2.0 / %d)", n+1))
+	}
+
+	row := make(map[string]interface{})
+	err := db.WithContext(ctx).Table(models.Currency{}.TableName()).
+		Raw(fmt.Sprintf(statsQuery, columns.String()), map[string]interface{}{
+			"codes": []string{code}, "start": start, "end": end, "year": secondsPerYear,
+		}).
+		Scan(&row).Error
+	if err != nil {
+		return models.CurrencyStats{}, err
+	}
+
+	stats := models.CurrencyStats{
+		Code:       code,
+		Samples:    toInt(row["samples"]),
+		Min:        toDecimal(row["min"]),
+		Max:        toDecimal(row["max"]),
+		Mean:       toDecimal(row["mean"]),
+		StdDev:     toDecimal(row["std_dev"]),
+		Volatility: toDecimal(row["volatility"]),
+		SMA:        make(map[string]decimal.NullDecimal, len(sma)),
+		EMA:        make(map[string]decimal.NullDecimal, len(ema)),
+	}
+
+	if first, last := toDecimal(row["first"]), toDecimal(row["last"]); first.Valid && last.Valid && !first.Decimal.IsZero() {
+		stats.Change = decimal.NewNullDecimal(last.Decimal.Sub(first.Decimal).Mul(decimal.NewFromInt(100)).Div(first.Decimal))
+	}
+
+	for _, n := range sma {
+		stats.SMA[fmt.Sprint(n)] = toDecimal(row[fmt.Sprintf("sma_%d", n)])
+	}
+
+	for _, n := range ema {
+		stats.EMA[fmt.Sprint(n)] = toDecimal(row[fmt.Sprintf("ema_%d", n)])
+	}
+
+	return stats, nil
+}
+
+// toDecimal converts a numeric column, null when it is
+func toDecimal(value interface{}) decimal.NullDecimal {
+	var d decimal.NullDecimal
+	if err := d.Scan(value); err != nil {
+		return decimal.NullDecimal{}
+	}
+
+	return d
+}
+
+func toInt(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case int:
+		return int64(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,38 @@
+package rates
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestStats(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	dbMock.ExpectQuery(`WITH history AS \(SELECT code, created_at, value FROM currency (.+) STDDEV_SAMP\(log_return / SQRT\(seconds / (.+)\)\) AS volatility,\s+CASE WHEN COUNT\(\*\) >= 2 THEN AVG\(value\) FILTER \(WHERE recency <= 2\) END AS sma_2,\s+CASE WHEN COUNT\(\*\) >= 3 THEN\s+POWER\(1 - \(2\.0 / 4\), COUNT\(\*\) - 3\) (.+) AS ema_3,(.+) AS ema_5\s+FROM returns`).
+		WillReturnRows(sqlmock.NewRows([]string{"samples", "min", "max", "mean", "std_dev", "first", "last", "volatility", "sma_2", "ema_3", "ema_5"}).
+			AddRow(int64(4), "10", "14", "12.25", "1.707825127659933", "10", "13", "0.41", "13.5", "12.5", nil))
+
+	// When
+	stats, err := Stats(context.Background(), gormDB, "MXN", start, end, []int{2}, []int{3, 5})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, int64(4), stats.Samples)
+	require.Equal(t, "10", stats.Min.Decimal.String())
+	require.Equal(t, "30", stats.Change.Decimal.String())
+	require.Equal(t, "0.41", stats.Volatility.Decimal.String())
+	require.Equal(t, "13.5", stats.SMA["2"].Decimal.String())
+	require.Equal(t, "12.5", stats.EMA["3"].Decimal.String())
+	require.False(t, stats.EMA["5"].Valid)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
@@ -0,0 +1,153 @@
+// Package retention rolls up old rate samples into hourly and daily aggregates.
+package retention
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/cache"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"gorm.io/gorm"
+)
+
+// lockID is the advisory lock keeping concurrent instances from rolling up the same samples
+const lockID = 7203385412
+
+// Policy is how long every resolution tier is kept
+type Policy struct {
+	// Raw samples older than Raw are rolled up into hourly aggregates
+	Raw time.Duration
+	// Hourly aggregates older than Hourly are rolled up into daily aggregates
+	Hourly time.Duration
+	// Daily aggregates older than Daily are deleted, zero keeps them forever
+	Daily time.Duration
+}
+
+// LoadPolicy reads the retention policy from the environment
+func LoadPolicy() (Policy, error) {
+	policy := Policy{
+		Raw:    time.Duration(config.Int("RETENTION_RAW_DAYS", 30)) * 24 * time.Hour,
+		Hourly: time.Duration(config.Int("RETENTION_HOURLY_DAYS", 365)) * 24 * time.Hour,
+		Daily:  time.Duration(config.Int("RETENTION_DAILY_DAYS", 0)) * 24 * time.Hour,
+	}
+
+	if policy.Raw <= 0 || policy.Hourly < policy.Raw {
+		return policy, fmt.Errorf("RETENTION_RAW_DAYS must be positive and at most RETENTION_HOURLY_DAYS")
+	}
+	if policy.Daily != 0 && policy.Daily < policy.Hourly {
+		return policy, fmt.Errorf("RETENTION_DAILY_DAYS must be zero or at least RETENTION_HOURLY_DAYS")
+	}
+
+	return policy, nil
+}
+
+const rollupHourly = `INSERT INTO currency_hourly (code, bucket, value, low, high, samples)
+SELECT code, date_trunc('hour', created_at), AVG(value), MIN(value), MAX(value), COUNT(*)
+FROM currency WHERE created_at < ? GROUP BY code, date_trunc('hour', created_at)
+ON CONFLICT (code, bucket) DO UPDATE SET
+	value = (currency_hourly.value * currency_hourly.samples + EXCLUDED.value * EXCLUDED.samples) / (currency_hourly.samples + EXCLUDED.samples),
+	low = LEAST(currency_hourly.low, EXCLUDED.low),
+	high = GREATEST(currency_hourly.high, EXCLUDED.high),
+	samples = currency_hourly.samples + EXCLUDED.samples`
+
+const rollupDaily = `INSERT INTO currency_daily (code, bucket, value, low, high, samples)
+SELECT code, date_trunc('day', bucket), SUM(value * samples) / SUM(samples), MIN(low), MAX(high), SUM(samples)
+FROM currency_hourly WHERE bucket < ? GROUP BY code, date_trunc('day', bucket)
+ON CONFLICT (code, bucket) DO UPDATE SET
+	value = (currency_daily.value * currency_daily.samples + EXCLUDED.value * EXCLUDED.samples) / (currency_daily.samples + EXCLUDED.samples),
+	low = LEAST(currency_daily.low, EXCLUDED.low),
+	high = GREATEST(currency_daily.high, EXCLUDED.high),
+	samples = currency_daily.samples + EXCLUDED.samples`
+
+// step runs sql with the cutoff of its tier
+type step struct {
+	sql    string
+	cutoff time.Time
+}
+
+// Run applies policy as of now, returning how many rows were rolled up or purged.
+// Every run moves rows between tiers in one transaction, so history reads never see a sample twice or miss it.
+// When the currency table is partitioned or a hypertable, the partitions or chunks holding only expired samples are
+// dropped once those are rolled up, so only the one the cutoff falls in is purged row by row.
+// Nothing is done while another instance is running it.
+func Run(db *gorm.DB, policy Policy, now time.Time) (int64, error) {
+	now = now.UTC()
+	rawCutoff := now.Add(-policy.Raw).Truncate(time.Hour)
+	hourlyCutoff := truncateDay(now.Add(-policy.Hourly))
+
+	steps := []step{
+		{`DELETE FROM currency WHERE created_at < ?`, rawCutoff},
+		{rollupDaily, hourlyCutoff},
+		{`DELETE FROM currency_hourly WHERE bucket < ?`, hourlyCutoff},
+	}
+	if policy.Daily > 0 {
+		steps = append(steps, step{`DELETE FROM currency_daily WHERE bucket < ?`, truncateDay(now.Add(-policy.Daily))})
+	}
+
+	var affected int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw(`SELECT pg_try_advisory_xact_lock(?)`, lockID).Scan(&locked).Error; err != nil || !locked {
+			return err
+		}
+
+		// Raw samples are rolled up before the partitions or chunks holding them are dropped
+		result := tx.Exec(rollupHourly, rawCutoff)
+		if result.Error != nil {
+			return result.Error
+		}
+		affected += result.RowsAffected
+
+		if err := database.DropCurrencyPartitions(tx, rawCutoff); err != nil {
+			return err
+		}
+
+		for _, step := range steps {
+			result := tx.Exec(step.sql, step.cutoff)
+			if result.Error != nil {
+				return result.Error
+			}
+			affected += result.RowsAffected
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// InitRetention applies the retention policy every RETENTION_INTERVAL
+func InitRetention() {
+	policy, err := LoadPolicy()
+	if err != nil {
+		log.Printf("Retention is disabled: %s\n", err)
+		return
+	}
+
+	ticker := time.NewTicker(config.Seconds("RETENTION_INTERVAL", time.Hour))
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		affected, err := Run(database.DB, policy, time.Now())
+		if err != nil {
+			log.Printf("Error applying retention: %s\n", err)
+			continue
+		}
+
+		// Cached history may hold raw samples that are now aggregated
+		if affected > 0 {
+			if err := cache.History.Invalidate(cache.Ctx); err != nil {
+				log.Printf("Error invalidating %s cache: %s\n", cache.History.Name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package retention
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/database"
+	"github.com/wjoseperez20/boletia-currency-api/pkg/helper"
+)
+
+func TestLoadPolicy_Defaults(t *testing.T) {
+	// When
+	policy, err := LoadPolicy()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, 30*24*time.Hour, policy.Raw)
+	require.Equal(t, 365*24*time.Hour, policy.Hourly)
+	require.Zero(t, policy.Daily)
+}
+
+func TestLoadPolicy_HourlyShorterThanRaw(t *testing.T) {
+	// Given
+	t.Setenv("RETENTION_RAW_DAYS", "90")
+	t.Setenv("RETENTION_HOURLY_DAYS", "30")
+
+	// When
+	_, err := LoadPolicy()
+
+	// Then
+	require.Error(t, err)
+}
+
+func TestRun_RollsUpAndPurges(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	now := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	rawCutoff := time.Date(2024, 5, 16, 10, 0, 0, 0, time.UTC)
+	hourlyCutoff := time.Date(2023, 6, 16, 0, 0, 0, 0, time.UTC)
+	dailyCutoff := time.Date(2021, 6, 16, 0, 0, 0, 0, time.UTC)
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_xact_lock($1)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(true))
+	dbMock.ExpectExec(`INSERT INTO currency_hourly (.+) FROM currency WHERE created_at < (.+) ON CONFLICT`).
+		WithArgs(rawCutoff).WillReturnResult(sqlmock.NewResult(0, 24))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DELETE FROM currency WHERE created_at < $1`)).
+		WithArgs(rawCutoff).WillReturnResult(sqlmock.NewResult(0, 1440))
+	dbMock.ExpectExec(`INSERT INTO currency_daily (.+) FROM currency_hourly WHERE bucket < (.+) ON CONFLICT`).
+		WithArgs(hourlyCutoff).WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DELETE FROM currency_hourly WHERE bucket < $1`)).
+		WithArgs(hourlyCutoff).WillReturnResult(sqlmock.NewResult(0, 24))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DELETE FROM currency_daily WHERE bucket < $1`)).
+		WithArgs(dailyCutoff).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+
+	policy := Policy{Raw: 30 * 24 * time.Hour, Hourly: 365 * 24 * time.Hour, Daily: 3 * 365 * 24 * time.Hour}
+
+	// When
+	affected, err := Run(gormDB, policy, now)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, int64(1489), affected)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestRun_DropsPartitionsAfterRollup(t *testing.T) {
+	// Given
+	database.CurrencyStorage = database.StoragePartitioned
+	t.Cleanup(func() { database.CurrencyStorage = database.StoragePlain })
+
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	now := time.Date(2024, 6, 15, 10, 30, 0, 0, time.UTC)
+	rawCutoff := time.Date(2024, 5, 16, 10, 0, 0, 0, time.UTC)
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_xact_lock($1)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(true))
+	dbMock.ExpectExec(`INSERT INTO currency_hourly (.+) FROM currency WHERE created_at < (.+) ON CONFLICT`).
+		WithArgs(rawCutoff).WillReturnResult(sqlmock.NewResult(0, 48))
+	dbMock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("currency_y2024m04").
+			AddRow("currency_y2024m05").
+			AddRow("currency_y2024m06"))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DROP TABLE IF EXISTS currency_y2024m04`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DELETE FROM currency WHERE created_at < $1`)).
+		WithArgs(rawCutoff).WillReturnResult(sqlmock.NewResult(0, 1440))
+	dbMock.ExpectExec(`INSERT INTO currency_daily`).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(regexp.QuoteMeta(`DELETE FROM currency_hourly WHERE bucket < $1`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+
+	// When
+	_, err := Run(gormDB, Policy{Raw: 30 * 24 * time.Hour, Hourly: 365 * 24 * time.Hour}, now)
+
+	// Then the expired partition is dropped once rolled up, and only the May rows are deleted one by one
+	require.NoError(t, err)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+func TestRun_LockedByAnotherInstance(t *testing.T) {
+	// Given
+	dbMock, gormDB := helper.SetupTestDatabase(t)
+	defer dbMock.ExpectClose()
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(regexp.QuoteMeta(`SELECT pg_try_advisory_xact_lock($1)`)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(false))
+	dbMock.ExpectCommit()
+
+	// When
+	affected, err := Run(gormDB, Policy{Raw: time.Hour, Hourly: 24 * time.Hour}, time.Now())
+
+	// Then
+	require.NoError(t, err)
+	require.Zero(t, affected)
+	require.NoError(t, dbMock.ExpectationsWereMet())
+}
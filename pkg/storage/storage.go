@@ -0,0 +1,74 @@
+// Package storage keeps files, like history exports, on the local filesystem or in an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/wjoseperez20/boletia-currency-api/pkg/config"
+)
+
+// ErrNotFound is returned when reading a file that does not exist
+var ErrNotFound = errors.New("file not found")
+
+// Storage stores files by key
+type Storage interface {
+	// Put stores the size bytes read from r at key
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open reads the file at key
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// URL returns a link downloading the file at key as filename until expiry passes,
+	// or an empty one when the backend cannot serve files itself and they must be read with Open
+	URL(ctx context.Context, key, filename string, expiry time.Duration) (string, error)
+	// Delete removes the file at key, succeeding when it does not exist
+	Delete(ctx context.Context, key string) error
+}
+
+// Config is the storage backend read from the environment
+type Config struct {
+	// Backend is local or s3
+	Backend string
+	// Dir is the directory the local backend keeps files in
+	Dir string
+
+	// S3Endpoint is the host, and port, of the S3-compatible service
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+	S3TLS       bool
+	// S3PathStyle puts the bucket in the path instead of the host, as most S3-compatible services expect
+	S3PathStyle bool
+}
+
+// LoadConfig reads the storage backend from the environment
+func LoadConfig() Config {
+	return Config{
+		Backend: config.String("STORAGE_BACKEND", "local"),
+		Dir:     config.String("STORAGE_DIR", "exports"),
+
+		S3Endpoint:  config.String("STORAGE_S3_ENDPOINT", "s3.amazonaws.com"),
+		S3Bucket:    config.String("STORAGE_S3_BUCKET", ""),
+		S3Region:    config.String("STORAGE_S3_REGION", "us-east-1"),
+		S3AccessKey: config.String("STORAGE_S3_ACCESS_KEY", ""),
+		S3SecretKey: config.String("STORAGE_S3_SECRET_KEY", ""),
+		S3TLS:       config.Bool("STORAGE_S3_TLS", true),
+		S3PathStyle: config.Bool("STORAGE_S3_PATH_STYLE", false),
+	}
+}
+
+// New returns the backend described by cfg
+func New(cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocal(cfg.Dir)
+	case "s3":
+		return NewS3(cfg)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", cfg.Backend)
+	}
+}
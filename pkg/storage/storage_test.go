@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a local stand-in for an S3-compatible service, keeping objects in memory by path
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+			body = decodeChunked(body)
+		}
+		f.objects[r.URL.Path] = body
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message></Error>`)
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodGet {
+			w.Write(body)
+		}
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeChunked strips the signature of every chunk of a payload signed in chunks over plain HTTP
+func decodeChunked(body []byte) []byte {
+	var decoded []byte
+	for len(body) > 0 {
+		header, rest, _ := strings.Cut(string(body), "\r\n")
+		size, _ := strconv.ParseInt(strings.SplitN(header, ";", 2)[0], 16, 64)
+		if size == 0 {
+			break
+		}
+		decoded = append(decoded, rest[:size]...)
+		body = []byte(rest[size+2:])
+	}
+
+	return decoded
+}
+
+func setupFakeS3(t *testing.T) *S3 {
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte)})
+	t.Cleanup(server.Close)
+
+	s3, err := NewS3(Config{
+		S3Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		S3Bucket:    "exports",
+		S3Region:    "us-east-1",
+		S3AccessKey: "access",
+		S3SecretKey: "secret",
+		S3PathStyle: true,
+	})
+	require.NoError(t, err)
+
+	return s3
+}
+
+func testRoundTrip(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	// When
+	require.NoError(t, s.Put(ctx, "jobs/a.csv", strings.NewReader("code,value\n"), 11, "text/csv"))
+	file, err := s.Open(ctx, "jobs/a.csv")
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, file.Close())
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "code,value\n", string(content))
+
+	require.NoError(t, s.Delete(ctx, "jobs/a.csv"))
+	_, err = s.Open(ctx, "jobs/a.csv")
+	require.True(t, errors.Is(err, ErrNotFound))
+	require.NoError(t, s.Delete(ctx, "jobs/a.csv"))
+}
+
+func TestLocal_RoundTrip(t *testing.T) {
+	// Given
+	local, err := NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	testRoundTrip(t, local)
+}
+
+func TestLocal_InvalidKey(t *testing.T) {
+	// Given
+	local, err := NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	// When
+	err = local.Put(context.Background(), "../escape.csv", strings.NewReader(""), 0, "text/csv")
+
+	// Then
+	require.Error(t, err)
+}
+
+func TestS3_RoundTrip(t *testing.T) {
+	// Given
+	s3 := setupFakeS3(t)
+
+	testRoundTrip(t, s3)
+}
+
+func TestS3_URL(t *testing.T) {
+	// Given
+	s3 := setupFakeS3(t)
+
+	// When
+	link, err := s3.URL(context.Background(), "jobs/a.csv", "MXN.csv", time.Hour)
+
+	// Then
+	require.NoError(t, err)
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	require.Equal(t, "/exports/jobs/a.csv", u.Path)
+	require.Equal(t, "3600", u.Query().Get("X-Amz-Expires"))
+	require.Equal(t, `attachment; filename=MXN.csv`, u.Query().Get("response-content-disposition"))
+}